@@ -2,7 +2,10 @@
 package buttons
 
 // Button represents a mask of pointer presses/releases.
-type Button uint8
+//
+// Buttons up to Eight fit in the button-mask of a standard PointerEvent.
+// Nine requires the ExtendedMouseButtons pseudo-encoding.
+type Button uint16
 
 //go:generate stringer -type=Button
 
@@ -16,9 +19,22 @@ const (
 	Six
 	Seven
 	Eight
+	Nine
 	None Button = 0
 )
 
+// Conventional uses of the buttons.
+const (
+	WheelUp    = Four
+	WheelDown  = Five
+	WheelLeft  = Six
+	WheelRight = Seven
+	Back       = Eight
+	Forward    = Nine
+)
+
+// Mask returns the button-mask of a standard PointerEvent, which only holds
+// the buttons up to Eight.
 func Mask(button Button) uint8 {
 	return uint8(button)
 }
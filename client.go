@@ -4,8 +4,8 @@ package vnc
 
 import (
 	"fmt"
+	"image"
 	"strings"
-	"unicode"
 
 	"github.com/phox/go-vnc/buttons"
 	"github.com/phox/go-vnc/encodings"
@@ -14,6 +14,18 @@ import (
 	"github.com/phox/go-vnc/rfbflags"
 )
 
+// ClientMessage is the interface satisfied by client messages, as read by a
+// server.
+type ClientMessage interface {
+	// The type of the message that is sent down on the wire.
+	Type() messages.ClientMessage
+
+	// Read reads the contents of the message from the reader. At the point
+	// this is called, the message type has already been read from the reader.
+	// This should return a new ClientMessage that is the appropriate type.
+	Read(*ServerConn) (ClientMessage, error)
+}
+
 // SetPixelFormatMessage holds the wire format message.
 type SetPixelFormatMessage struct {
 	Msg messages.ClientMessage // message-type
@@ -21,6 +33,28 @@ type SetPixelFormatMessage struct {
 	PF  PixelFormat            // pixel-format
 }
 
+// Verify that interfaces are honored.
+var _ ClientMessage = (*SetPixelFormatMessage)(nil)
+
+// Type implements the ClientMessage interface.
+func (*SetPixelFormatMessage) Type() messages.ClientMessage { return messages.SetPixelFormat }
+
+// Read implements the ClientMessage interface. The pixel format becomes the
+// one used for the connection.
+func (*SetPixelFormatMessage) Read(c *ServerConn) (ClientMessage, error) {
+	var msg SetPixelFormatMessage
+	if err := c.receiveMessage(messages.SetPixelFormat, &msg); err != nil {
+		return nil, err
+	}
+	switch msg.PF.BPP {
+	case 8, 16, 32:
+	default:
+		return nil, fmt.Errorf("invalid bits-per-pixel: %d", msg.PF.BPP)
+	}
+	c.setPixelFormat(msg.PF)
+	return &msg, nil
+}
+
 // SetPixelFormat sets the format in which pixel values should be sent
 // in FramebufferUpdate messages from the server.
 //
@@ -50,6 +84,33 @@ type SetEncodingsMessage struct {
 	NumEncs uint16                 // number-of-encodings
 }
 
+// SetEncodings represents a SetEncodings message read by a server.
+type SetEncodings struct {
+	SetEncodingsMessage
+	Encodings []encodings.Encoding // encoding-types, in order of preference
+}
+
+// Verify that interfaces are honored.
+var _ ClientMessage = (*SetEncodings)(nil)
+
+// Type implements the ClientMessage interface.
+func (*SetEncodings) Type() messages.ClientMessage { return messages.SetEncodings }
+
+// Read implements the ClientMessage interface. The encodings become the ones
+// used for the connection.
+func (*SetEncodings) Read(c *ServerConn) (ClientMessage, error) {
+	var msg SetEncodings
+	if err := c.receiveMessage(messages.SetEncodings, &msg.SetEncodingsMessage); err != nil {
+		return nil, err
+	}
+	msg.Encodings = make([]encodings.Encoding, msg.NumEncs)
+	if err := c.receive(&msg.Encodings); err != nil {
+		return nil, err
+	}
+	c.setEncodings(msg.Encodings)
+	return &msg, nil
+}
+
 // SetEncodings sets the encoding types in which the pixel data can be sent
 // from the server. After calling this method, the encs slice given should not
 // be modified.
@@ -59,6 +120,13 @@ type SetEncodingsMessage struct {
 //
 // See RFC 6143 Section 7.5.2
 func (c *ClientConn) SetEncodings(encs Encodings) error {
+	c.setEncodingsMu.Lock()
+	defer c.setEncodingsMu.Unlock()
+	return c.setEncodings(encs)
+}
+
+// setEncodings implements SetEncodings. c.setEncodingsMu must be held.
+func (c *ClientConn) setEncodings(encs Encodings) error {
 	// Make sure RawEncoding is supported.
 	haveRaw := false
 	for _, v := range encs {
@@ -94,7 +162,9 @@ func (c *ClientConn) SetEncodings(encs Encodings) error {
 		return err
 	}
 
+	c.mu.Lock()
 	c.encodings = encs
+	c.mu.Unlock()
 	return nil
 }
 
@@ -106,13 +176,35 @@ type FramebufferUpdateRequestMessage struct {
 	Width, Height uint16                 // width, height
 }
 
+// Verify that interfaces are honored.
+var _ ClientMessage = (*FramebufferUpdateRequestMessage)(nil)
+
+// Type implements the ClientMessage interface.
+func (*FramebufferUpdateRequestMessage) Type() messages.ClientMessage {
+	return messages.FramebufferUpdateRequest
+}
+
+// Read implements the ClientMessage interface.
+func (*FramebufferUpdateRequestMessage) Read(c *ServerConn) (ClientMessage, error) {
+	var msg FramebufferUpdateRequestMessage
+	if err := c.receiveMessage(messages.FramebufferUpdateRequest, &msg); err != nil {
+		return nil, err
+	}
+	c.requestUpdate(&msg)
+	return &msg, nil
+}
+
 // Requests a framebuffer update from the server. There may be an indefinite
 // time between the request and the actual framebuffer update being received.
 //
 // See RFC 6143 Section 7.5.3
 func (c *ClientConn) FramebufferUpdateRequest(inc rfbflags.RFBFlag, x, y, w, h uint16) error {
 	msg := FramebufferUpdateRequestMessage{messages.FramebufferUpdateRequest, inc, x, y, w, h}
-	return c.send(&msg)
+	if err := c.send(&msg); err != nil {
+		return err
+	}
+	c.updateRequested(rfbflags.ToBool(inc))
+	return nil
 }
 
 // KeyEventMessage holds the wire format message.
@@ -123,6 +215,21 @@ type KeyEventMessage struct {
 	Key      keys.Key               // key
 }
 
+// Verify that interfaces are honored.
+var _ ClientMessage = (*KeyEventMessage)(nil)
+
+// Type implements the ClientMessage interface.
+func (*KeyEventMessage) Type() messages.ClientMessage { return messages.KeyEvent }
+
+// Read implements the ClientMessage interface.
+func (*KeyEventMessage) Read(c *ServerConn) (ClientMessage, error) {
+	var msg KeyEventMessage
+	if err := c.receiveMessage(messages.KeyEvent, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
 const (
 	PressKey   = true
 	ReleaseKey = false
@@ -151,18 +258,41 @@ type PointerEventMessage struct {
 	X, Y uint16                 // x-, y-position
 }
 
+// Verify that interfaces are honored.
+var _ ClientMessage = (*PointerEventMessage)(nil)
+
+// Type implements the ClientMessage interface.
+func (*PointerEventMessage) Type() messages.ClientMessage { return messages.PointerEvent }
+
+// Read implements the ClientMessage interface.
+func (*PointerEventMessage) Read(c *ServerConn) (ClientMessage, error) {
+	var msg PointerEventMessage
+	if err := c.receiveMessage(messages.PointerEvent, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
 // PointerEvent indicates that pointer movement or a pointer button
 // press or release.
 //
 // The `button` is a bitwise mask of various Button values. When a button
-// is set, it is pressed, when it is unset, it is released.
+// is set, it is pressed, when it is unset, it is released. The Nine button
+// requires the server to support the ExtendedMouseButtons pseudo-encoding.
 //
 // See RFC 6143 Section 7.5.5
 func (c *ClientConn) PointerEvent(button buttons.Button, x, y uint16) error {
-	msg := PointerEventMessage{messages.PointerEvent, uint8(button), x, y}
+	msg, err := c.pointerEventMessage(button, x, y)
+	if err != nil {
+		return err
+	}
 	if err := c.send(msg); err != nil {
 		return err
 	}
+	c.setPointerPos(image.Pt(int(x), int(y)))
+	c.mu.Lock()
+	c.pointerButtons = button
+	c.mu.Unlock()
 
 	settleUI()
 	return nil
@@ -175,17 +305,53 @@ type ClientCutTextMessage struct {
 	Length uint32                 // length
 }
 
+// ClientCutText represents a ClientCutText message read by a server.
+type ClientCutText struct {
+	ClientCutTextMessage
+	Text string // text, decoded from Latin-1
+}
+
+// Verify that interfaces are honored.
+var _ ClientMessage = (*ClientCutText)(nil)
+
+// Type implements the ClientMessage interface.
+func (*ClientCutText) Type() messages.ClientMessage { return messages.ClientCutText }
+
+// Read implements the ClientMessage interface.
+func (*ClientCutText) Read(c *ServerConn) (ClientMessage, error) {
+	var msg ClientCutText
+	if err := c.receiveMessage(messages.ClientCutText, &msg.ClientCutTextMessage); err != nil {
+		return nil, err
+	}
+	// Negative lengths are only used by the Extended Clipboard, once the
+	// server has announced its caps.
+	if int32(msg.Length) < 0 {
+		return nil, fmt.Errorf("extended clipboard is not supported")
+	}
+	if msg.Length > maxClipboardSize {
+		return nil, fmt.Errorf("client cut text too long: %d bytes", msg.Length)
+	}
+
+	text := make([]byte, msg.Length)
+	if err := c.receive(&text); err != nil {
+		return nil, err
+	}
+	msg.Text = latin1ToString(text)
+	return &msg, nil
+}
+
 // ClientCutText tells the server that the client has new text in its cut buffer.
 // The text string MUST only contain Latin-1 characters. This encoding
 // is compatible with Go's native string format, but can only use up to
 // unicode.MaxLatin1 values.
 //
+// If the server supports the Extended Clipboard, then the text may contain
+// any Unicode characters, and is sent with ClientClipboard instead.
+//
 // See RFC 6143 Section 7.5.6
 func (c *ClientConn) ClientCutText(text string) error {
-	for _, char := range text {
-		if char > unicode.MaxLatin1 {
-			return NewVNCError(fmt.Sprintf("Character %q is not valid Latin-1", char))
-		}
+	if c.ExtendedClipboardSupported() {
+		return c.ClientClipboard(map[ClipboardFlags]string{ClipboardText: text})
 	}
 
 	// Strip carriage-return (0x0d) chars.
@@ -193,14 +359,19 @@ func (c *ClientConn) ClientCutText(text string) error {
 	// alone. No carriage-return (0x0d) is used."
 	text = strings.Join(strings.Split(text, "\r"), "")
 
+	latin1, err := stringToLatin1(text)
+	if err != nil {
+		return err
+	}
+
 	msg := ClientCutTextMessage{
 		Msg:    messages.ClientCutText,
-		Length: uint32(len(text)),
+		Length: uint32(len(latin1)),
 	}
 	if err := c.send(msg); err != nil {
 		return err
 	}
-	if err := c.send([]byte(text)); err != nil {
+	if err := c.send(latin1); err != nil {
 		return err
 	}
 
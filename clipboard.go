@@ -0,0 +1,391 @@
+/*
+Implementation of the Extended Clipboard pseudo-encoding.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#extended-clipboard-pseudo-encoding
+*/
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/messages"
+)
+
+//-----------------------------------------------------------------------------
+// Extended Clipboard
+//
+// The Extended Clipboard pseudo-encoding reuses the ClientCutText and
+// ServerCutText messages with a negative length, to exchange UTF-8 text in
+// several formats. Each message carries a single action, and the formats it
+// applies to.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#extended-clipboard-pseudo-encoding
+
+// ClipboardFlags is a bitwise mask of an Extended Clipboard action and the
+// formats it applies to.
+type ClipboardFlags uint32
+
+// Clipboard formats.
+const (
+	ClipboardText  ClipboardFlags = 1 << iota // Plain text.
+	ClipboardRTF                              // Microsoft Rich Text Format.
+	ClipboardHTML                             // Microsoft HTML Clipboard Format.
+	ClipboardDIB                              // Microsoft Device Independent Bitmap.
+	ClipboardFiles                            // Files, which are not yet specified.
+)
+
+// Clipboard actions.
+const (
+	// ClipboardCaps announces the supported formats and actions, and the
+	// maximum size of unsolicited data for each format.
+	ClipboardCaps ClipboardFlags = 1 << (iota + 24)
+	// ClipboardRequest asks for the data of the formats.
+	ClipboardRequest
+	// ClipboardPeek asks for a ClipboardNotify of the available formats.
+	ClipboardPeek
+	// ClipboardNotify announces the formats that are available.
+	ClipboardNotify
+	// ClipboardProvide carries the data of the formats.
+	ClipboardProvide
+)
+
+const (
+	clipboardFormats ClipboardFlags = 0xffff
+	clipboardActions ClipboardFlags = 0xff << 24
+
+	// The formats and actions supported by the client.
+	clipboardSupportedFormats = ClipboardText | ClipboardRTF | ClipboardHTML
+	clipboardSupportedActions = ClipboardCaps | ClipboardRequest | ClipboardPeek | ClipboardNotify | ClipboardProvide
+)
+
+// maxClipboardSize is the maximum size of an Extended Clipboard message, and
+// of the data of each format within it.
+const maxClipboardSize = 20 << 20
+
+// clipboardState holds the Extended Clipboard state of a connection.
+type clipboardState struct {
+	caps  ClipboardFlags            // The caps of the server, if any.
+	sizes map[ClipboardFlags]uint32 // The maximum sizes the server accepts.
+	data  map[ClipboardFlags]string // The contents of the client clipboard.
+}
+
+// ExtendedClipboardSupported returns true once the server has indicated that
+// it supports the Extended Clipboard, by sending its caps.
+func (c *ClientConn) ExtendedClipboardSupported() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.clipboard.caps&ClipboardCaps != 0
+}
+
+// ExtendedClipboardPseudoEncoding tells the server that the client supports
+// the Extended Clipboard.
+type ExtendedClipboardPseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*ExtendedClipboardPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*ExtendedClipboardPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (*ExtendedClipboardPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	return nil, fmt.Errorf("ExtendedClipboardPseudoEncoding is never sent by the server")
+}
+
+// String implements the fmt.Stringer interface.
+func (*ExtendedClipboardPseudoEncoding) String() string { return "ExtendedClipboardPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*ExtendedClipboardPseudoEncoding) Type() encodings.Encoding {
+	return encodings.ExtendedClipboardPseudo
+}
+
+// ExtendedClipboard holds an Extended Clipboard message.
+type ExtendedClipboard struct {
+	Flags ClipboardFlags
+
+	// Sizes holds the maximum size of unsolicited data for each format, for
+	// ClipboardCaps.
+	Sizes map[ClipboardFlags]uint32
+
+	// Data holds the text of each format, for ClipboardProvide. Line endings
+	// are converted to newlines.
+	Data map[ClipboardFlags]string
+}
+
+// Action returns the action of the message. Caps messages also carry the
+// actions that are supported, so ClipboardCaps takes precedence.
+func (m *ExtendedClipboard) Action() ClipboardFlags {
+	actions := m.Flags & clipboardActions
+	return actions & -actions
+}
+
+// Formats returns the formats the action applies to.
+func (m *ExtendedClipboard) Formats() ClipboardFlags { return m.Flags & clipboardFormats }
+
+// clipboardFormatList returns the formats in flags, in wire order.
+func clipboardFormatList(flags ClipboardFlags) []ClipboardFlags {
+	var formats []ClipboardFlags
+	for f := ClipboardFlags(1); f&clipboardFormats != 0; f <<= 1 {
+		if flags&f != 0 {
+			formats = append(formats, f)
+		}
+	}
+	return formats
+}
+
+// encodeClipboardText converts text to UTF-8 with CRLF line endings and a
+// terminating NUL, as the text formats require.
+func encodeClipboardText(text string) []byte {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, "\n", "\r\n", -1)
+	return append([]byte(text), 0)
+}
+
+// decodeClipboardText reverses encodeClipboardText.
+func decodeClipboardText(b []byte) (string, error) {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	if !utf8.Valid(b) {
+		return "", fmt.Errorf("clipboard text is not valid UTF-8")
+	}
+	return strings.Replace(string(b), "\r\n", "\n", -1), nil
+}
+
+// sendClipboard sends an Extended Clipboard message to the server.
+func (c *ClientConn) sendClipboard(flags ClipboardFlags, payload []byte) error {
+	buf := NewBuffer(nil)
+	msg := ClientCutTextMessage{
+		Msg:    messages.ClientCutText,
+		Length: uint32(-int32(4 + len(payload))),
+	}
+	if err := buf.Write(msg); err != nil {
+		return err
+	}
+	if err := buf.Write(flags); err != nil {
+		return err
+	}
+	if err := buf.Write(payload); err != nil {
+		return err
+	}
+	return c.send(buf.Bytes())
+}
+
+// sendClipboardCaps tells the server which formats and actions the client
+// supports.
+func (c *ClientConn) sendClipboardCaps() error {
+	buf := NewBuffer(nil)
+	for range clipboardFormatList(clipboardSupportedFormats) {
+		if err := buf.Write(uint32(maxClipboardSize)); err != nil {
+			return err
+		}
+	}
+	return c.sendClipboard(clipboardSupportedActions|clipboardSupportedFormats, buf.Bytes())
+}
+
+// provideClipboard sends the client clipboard contents for the formats that
+// the client has. Other formats are omitted.
+func (c *ClientConn) provideClipboard(formats ClipboardFlags) error {
+	c.mu.Lock()
+	data := make(map[ClipboardFlags][]byte)
+	for _, f := range clipboardFormatList(formats) {
+		if text, ok := c.clipboard.data[f]; ok {
+			data[f] = encodeClipboardText(text)
+		}
+	}
+	c.mu.Unlock()
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	var provided ClipboardFlags
+	for _, f := range clipboardFormatList(formats) {
+		b, ok := data[f]
+		if !ok {
+			continue
+		}
+		if err := binary.Write(zw, binary.BigEndian, uint32(len(b))); err != nil {
+			return err
+		}
+		if _, err := zw.Write(b); err != nil {
+			return err
+		}
+		provided |= f
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return c.sendClipboard(ClipboardProvide|provided, buf.Bytes())
+}
+
+// notifyClipboard tells the server which formats the client clipboard holds.
+func (c *ClientConn) notifyClipboard() error {
+	c.mu.Lock()
+	var formats ClipboardFlags
+	for f := range c.clipboard.data {
+		formats |= f
+	}
+	c.mu.Unlock()
+	return c.sendClipboard(ClipboardNotify|formats, nil)
+}
+
+// ClientClipboard replaces the contents of the client clipboard with the text
+// of each format, and tells the server about it. Only the ClipboardText,
+// ClipboardRTF and ClipboardHTML formats are supported.
+//
+// If the server supports notifications, it is notified of the new formats and
+// requests the ones it wants. Otherwise, the text is provided to it directly.
+//
+// It requires the server to support the Extended Clipboard.
+func (c *ClientConn) ClientClipboard(data map[ClipboardFlags]string) error {
+	var formats ClipboardFlags
+	for f := range data {
+		if f&^clipboardSupportedFormats != 0 || len(clipboardFormatList(f)) != 1 {
+			return fmt.Errorf("unsupported clipboard format: %#x", uint32(f))
+		}
+		formats |= f
+	}
+	if !c.ExtendedClipboardSupported() {
+		return fmt.Errorf("extended clipboard is not supported by the server")
+	}
+
+	c.mu.Lock()
+	c.clipboard.data = make(map[ClipboardFlags]string, len(data))
+	for f, text := range data {
+		c.clipboard.data[f] = text
+	}
+	caps, sizes := c.clipboard.caps, c.clipboard.sizes
+	c.mu.Unlock()
+
+	var err error
+	switch {
+	case caps&ClipboardNotify != 0:
+		err = c.notifyClipboard()
+	case caps&ClipboardProvide != 0:
+		// Only provide the formats that fit in the sizes the server accepts.
+		var fits ClipboardFlags
+		for _, f := range clipboardFormatList(formats & caps) {
+			if len(encodeClipboardText(data[f])) <= int(sizes[f]) {
+				fits |= f
+			}
+		}
+		err = c.provideClipboard(fits)
+	}
+	if err != nil {
+		return err
+	}
+
+	settleUI()
+	return nil
+}
+
+// RequestClipboard asks the server for the contents of its clipboard in the
+// given formats, which it sends with a ClipboardProvide. It requires the
+// server to support the Extended Clipboard.
+func (c *ClientConn) RequestClipboard(formats ClipboardFlags) error {
+	return c.sendClipboard(ClipboardRequest|formats&clipboardFormats, nil)
+}
+
+// PeekClipboard asks the server which formats its clipboard holds, which it
+// announces with a ClipboardNotify. It requires the server to support the
+// Extended Clipboard.
+func (c *ClientConn) PeekClipboard() error {
+	return c.sendClipboard(ClipboardPeek, nil)
+}
+
+// readExtendedClipboard reads an Extended Clipboard message of the given
+// length from the server, and handles its action.
+//
+// Caps are answered with the caps of the client, requests are answered with
+// the client clipboard contents, and peeks are answered with a notification
+// of the formats it holds.
+func (c *ClientConn) readExtendedClipboard(length uint32) (*ExtendedClipboard, error) {
+	if length < 4 || length > maxClipboardSize {
+		return nil, fmt.Errorf("invalid extended clipboard message length: %d", length)
+	}
+	payload := make([]byte, length)
+	if err := c.receive(&payload); err != nil {
+		return nil, err
+	}
+
+	msg := &ExtendedClipboard{Flags: ClipboardFlags(binary.BigEndian.Uint32(payload))}
+	payload = payload[4:]
+
+	action := msg.Action()
+	if action != ClipboardCaps && msg.Flags&clipboardActions != action {
+		return nil, fmt.Errorf("invalid extended clipboard actions: %#x", uint32(msg.Flags&clipboardActions))
+	}
+
+	switch action {
+	case ClipboardCaps:
+		msg.Sizes = make(map[ClipboardFlags]uint32)
+		for _, f := range clipboardFormatList(msg.Formats()) {
+			if len(payload) < 4 {
+				return nil, fmt.Errorf("extended clipboard caps too short")
+			}
+			msg.Sizes[f] = binary.BigEndian.Uint32(payload)
+			payload = payload[4:]
+		}
+		c.mu.Lock()
+		c.clipboard.caps, c.clipboard.sizes = msg.Flags, msg.Sizes
+		c.mu.Unlock()
+		if err := c.sendClipboardCaps(); err != nil {
+			return nil, fmt.Errorf("unable to send extended clipboard caps: %s", err)
+		}
+
+	case ClipboardRequest:
+		if err := c.provideClipboard(msg.Formats()); err != nil {
+			return nil, fmt.Errorf("unable to provide clipboard: %s", err)
+		}
+
+	case ClipboardPeek:
+		if err := c.notifyClipboard(); err != nil {
+			return nil, fmt.Errorf("unable to notify clipboard: %s", err)
+		}
+
+	case ClipboardNotify:
+
+	case ClipboardProvide:
+		zr, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read provided clipboard: %s", err)
+		}
+		msg.Data = make(map[ClipboardFlags]string)
+		for _, f := range clipboardFormatList(msg.Formats()) {
+			var size uint32
+			if err := binary.Read(zr, binary.BigEndian, &size); err != nil {
+				return nil, fmt.Errorf("unable to read provided clipboard: %s", err)
+			}
+			if size > maxClipboardSize {
+				return nil, fmt.Errorf("provided clipboard too large: %d bytes", size)
+			}
+			if f&clipboardSupportedFormats == 0 {
+				if _, err := io.CopyN(ioutil.Discard, zr, int64(size)); err != nil {
+					return nil, fmt.Errorf("unable to read provided clipboard: %s", err)
+				}
+				continue
+			}
+			b := make([]byte, size)
+			if _, err := io.ReadFull(zr, b); err != nil {
+				return nil, fmt.Errorf("unable to read provided clipboard: %s", err)
+			}
+			if msg.Data[f], err = decodeClipboardText(b); err != nil {
+				return nil, err
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("invalid extended clipboard action: %#x", uint32(action))
+	}
+
+	return msg, nil
+}
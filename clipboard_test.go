@@ -0,0 +1,194 @@
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/messages"
+)
+
+// sendServerClipboard writes an Extended Clipboard ServerCutText message,
+// sans message-type, to the connection.
+func sendServerClipboard(t *testing.T, conn *ClientConn, flags ClipboardFlags, payload []byte) {
+	if err := conn.send([3]byte{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send(-int32(4 + len(payload))); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send(flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send(payload); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receiveClientClipboard reads an Extended Clipboard ClientCutText message
+// from the connection.
+func receiveClientClipboard(t *testing.T, conn *ClientConn) (ClipboardFlags, []byte) {
+	var msg ClientCutTextMessage
+	if err := conn.receive(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Msg != messages.ClientCutText {
+		t.Fatalf("incorrect message-type; got = %v, want = %v", msg.Msg, messages.ClientCutText)
+	}
+	length := -int32(msg.Length)
+	if length < 4 {
+		t.Fatalf("invalid extended clipboard length: %d", length)
+	}
+	var flags ClipboardFlags
+	if err := conn.receive(&flags); err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, length-4)
+	if err := conn.receive(&payload); err != nil {
+		t.Fatal(err)
+	}
+	return flags, payload
+}
+
+// compressClipboard returns the payload of a ClipboardProvide message.
+func compressClipboard(t *testing.T, data ...[]byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	for _, b := range data {
+		if err := binary.Write(zw, binary.BigEndian, uint32(len(b))); err != nil {
+			t.Fatal(err)
+		}
+		zw.Write(b)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtendedClipboardPseudoEncoding(t *testing.T) {
+	e := &ExtendedClipboardPseudoEncoding{}
+	if got, want := e.Type(), encodings.ExtendedClipboardPseudo; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+	if got, want := uint32(e.Type()), uint32(0xc0a1e5ce); got != want {
+		t.Errorf("incorrect encoding value; got = %#x, want = %#x", got, want)
+	}
+}
+
+func TestExtendedClipboard(t *testing.T) {
+	defer SetSettle(Settle())
+	SetSettle(0)
+
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	if err := conn.ClientClipboard(map[ClipboardFlags]string{ClipboardText: "ünïcode"}); err == nil {
+		t.Error("expected error before extended clipboard is supported")
+	}
+
+	// The server announces its caps, and the client answers with its own.
+	caps := ClipboardCaps | ClipboardRequest | ClipboardNotify | ClipboardProvide | ClipboardText | ClipboardHTML
+	sendServerClipboard(t, conn, caps, []byte{0, 0, 0, 16, 0, 0, 1, 0})
+	msg, err := (&ServerCutText{}).Read(conn)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &ExtendedClipboard{Flags: caps, Sizes: map[ClipboardFlags]uint32{ClipboardText: 16, ClipboardHTML: 256}}
+	if got := msg.(*ServerCutText).Extended; !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect caps; got = %+v, want = %+v", got, want)
+	}
+	if !conn.ExtendedClipboardSupported() {
+		t.Error("extended clipboard not supported after caps were received")
+	}
+	flags, payload := receiveClientClipboard(t, conn)
+	if got, want := flags, clipboardSupportedActions|ClipboardText|ClipboardRTF|ClipboardHTML; got != want {
+		t.Errorf("incorrect client caps; got = %#x, want = %#x", got, want)
+	}
+	if got, want := len(payload), 12; got != want {
+		t.Errorf("incorrect client caps length; got = %d, want = %d", got, want)
+	}
+
+	// New client text is announced, and provided when the server requests it.
+	if err := conn.ClientCutText("ünï\ncode"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if flags, _ := receiveClientClipboard(t, conn); flags != ClipboardNotify|ClipboardText {
+		t.Errorf("incorrect notification; got = %#x", flags)
+	}
+	sendServerClipboard(t, conn, ClipboardRequest|ClipboardText|ClipboardHTML, nil)
+	if _, err := (&ServerCutText{}).Read(conn); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	flags, payload = receiveClientClipboard(t, conn)
+	if got, want := flags, ClipboardProvide|ClipboardText; got != want {
+		t.Errorf("incorrect provide flags; got = %#x, want = %#x", got, want)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := data, append([]byte{0, 0, 0, 12}, "ünï\r\ncode\x00"...); !bytes.Equal(got, want) {
+		t.Errorf("incorrect provided data; got = %q, want = %q", got, want)
+	}
+
+	// Peeks are answered with the available formats.
+	sendServerClipboard(t, conn, ClipboardPeek, nil)
+	if _, err := (&ServerCutText{}).Read(conn); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if flags, _ := receiveClientClipboard(t, conn); flags != ClipboardNotify|ClipboardText {
+		t.Errorf("incorrect peek response; got = %#x", flags)
+	}
+
+	// Text provided by the server is decoded.
+	sendServerClipboard(t, conn, ClipboardProvide|ClipboardText|ClipboardDIB,
+		compressClipboard(t, []byte("grüße\r\nwelt\x00"), []byte{1, 2, 3}))
+	msg, err = (&ServerCutText{}).Read(conn)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := msg.(*ServerCutText).Text, "grüße\nwelt"; got != want {
+		t.Errorf("incorrect text; got = %q, want = %q", got, want)
+	}
+	if mockConn.b.Len() != 0 {
+		t.Errorf("%d unexpected bytes sent", mockConn.b.Len())
+	}
+
+	// Without notifications, text is provided directly if it fits.
+	sendServerClipboard(t, conn, ClipboardCaps|ClipboardProvide|ClipboardText, []byte{0, 0, 0, 16})
+	if _, err := (&ServerCutText{}).Read(conn); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	receiveClientClipboard(t, conn)
+	for _, tt := range []struct {
+		text  string
+		flags ClipboardFlags
+	}{
+		{"short", ClipboardProvide | ClipboardText},
+		{"far too long for the server", ClipboardProvide},
+	} {
+		if err := conn.ClientCutText(tt.text); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if flags, _ := receiveClientClipboard(t, conn); flags != tt.flags {
+			t.Errorf("incorrect flags for %q; got = %#x, want = %#x", tt.text, flags, tt.flags)
+		}
+	}
+
+	if err := conn.ClientClipboard(map[ClipboardFlags]string{ClipboardDIB: ""}); err == nil {
+		t.Error("expected error for unsupported format")
+	}
+	sendServerClipboard(t, conn, ClipboardRequest|ClipboardPeek, nil)
+	if _, err := (&ServerCutText{}).Read(conn); err == nil {
+		t.Error("expected error for invalid action")
+	}
+}
@@ -0,0 +1,401 @@
+/*
+Implementation of the Cursor, XCursor, Cursor With Alpha and PointerPos
+pseudo-encodings.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#cursor-pseudo-encoding
+*/
+package vnc
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/messages"
+	"github.com/phox/go-vnc/rfbflags"
+)
+
+// Cursor holds the shape of the remote cursor, as sent by the server with the
+// Cursor, XCursor or Cursor With Alpha pseudo-encodings.
+type Cursor struct {
+	// Image holds the cursor pixels, with bounds starting at the origin.
+	// Pixels are opaque, unless the cursor was sent with an alpha channel.
+	Image *image.RGBA
+
+	// Mask is opaque where the cursor is drawn, and transparent elsewhere.
+	// It is nil if the cursor was sent with an alpha channel, which is then
+	// used instead.
+	Mask *image.Alpha
+
+	// Hotspot is the point in Image that is placed at the pointer position.
+	Hotspot image.Point
+}
+
+// Draw composites the cursor onto dst with its hotspot at p, as a viewer
+// would when displaying the pointer at that position.
+func (cur *Cursor) Draw(dst draw.Image, p image.Point) {
+	r := cur.Image.Rect.Add(p.Sub(cur.Hotspot))
+	if cur.Mask == nil {
+		draw.Draw(dst, r, cur.Image, image.Point{}, draw.Over)
+		return
+	}
+	draw.DrawMask(dst, r, cur.Image, image.Point{}, cur.Mask, image.Point{}, draw.Over)
+}
+
+// Cursor returns the most recent cursor shape sent by the server, or nil if
+// the server has not sent one, or has hidden the cursor.
+func (c *ClientConn) Cursor() *Cursor {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cursor
+}
+
+// PointerPos returns the last known position of the pointer, either as moved
+// by the server with the PointerPos pseudo-encoding, or as sent with
+// PointerEvent.
+func (c *ClientConn) PointerPos() image.Point {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pointerPos
+}
+
+// setPointerPos stores the position of the pointer.
+func (c *ClientConn) setPointerPos(p image.Point) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pointerPos = p
+}
+
+// setCursor stores the cursor shape, and queues a CursorUpdate message.
+func (c *ClientConn) setCursor(cur *Cursor) {
+	c.mu.Lock()
+	c.cursor = cur
+	c.mu.Unlock()
+	c.queueMessage(&CursorUpdate{cur})
+}
+
+// maxCursorSize is the largest width and height of a cursor shape, as in
+// TigerVNC. Larger cursors are rejected before anything is allocated for them.
+const maxCursorSize = 256
+
+// checkCursorSize returns an error if the cursor shape of rect is larger than
+// maxCursorSize.
+func checkCursorSize(rect *Rectangle) error {
+	if rect.Width > maxCursorSize || rect.Height > maxCursorSize {
+		return fmt.Errorf("cursor size %dx%d exceeds %dx%d", rect.Width, rect.Height, maxCursorSize, maxCursorSize)
+	}
+	return nil
+}
+
+// readCursorMask reads a bitmask of the given size, with one bit per pixel and
+// rows padded to a whole number of bytes. Set bits are opaque.
+func readCursorMask(r io.Reader, size image.Point) (*image.Alpha, error) {
+	stride := (size.X + 7) / 8
+	data := make([]byte, stride*size.Y)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	mask := image.NewAlpha(image.Rectangle{Max: size})
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			if data[y*stride+x/8]&(0x80>>uint(x%8)) != 0 {
+				mask.SetAlpha(x, y, color.Alpha{0xff})
+			}
+		}
+	}
+	return mask, nil
+}
+
+//-----------------------------------------------------------------------------
+// Cursor Pseudo-Encoding
+//
+// The Cursor pseudo-encoding sends the cursor shape as pixels in the
+// connection's pixel format, followed by a bitmask. The rectangle position
+// gives the hotspot. An empty rectangle hides the cursor.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#cursor-pseudo-encoding
+
+// CursorPseudoEncoding represents a cursor shape update.
+type CursorPseudoEncoding struct {
+	// Cursor holds the new cursor shape, or nil if the cursor is hidden.
+	Cursor *Cursor
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*CursorPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*CursorPseudoEncoding) Marshal() ([]byte, error) {
+	return nil, fmt.Errorf("Marshal() unimplemented")
+}
+
+// Read implements the Encoding interface.
+func (*CursorPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	if err := checkCursorSize(rect); err != nil {
+		return nil, err
+	}
+
+	var cur *Cursor
+	if rect.Area() > 0 {
+		size := image.Pt(int(rect.Width), int(rect.Height))
+		r := &connReader{c}
+		img := image.NewRGBA(image.Rectangle{Max: size})
+		if err := c.readPixels(r, img, img.Rect, c.pixelFormat.bytesPerPixel()); err != nil {
+			return nil, fmt.Errorf("unable to read cursor pixels: %s", err)
+		}
+		mask, err := readCursorMask(r, size)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read cursor mask: %s", err)
+		}
+		cur = &Cursor{img, mask, image.Pt(int(rect.X), int(rect.Y))}
+	}
+	c.setCursor(cur)
+
+	return &CursorPseudoEncoding{cur}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*CursorPseudoEncoding) String() string { return "CursorPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*CursorPseudoEncoding) Type() encodings.Encoding { return encodings.CursorPseudo }
+
+//-----------------------------------------------------------------------------
+// XCursor Pseudo-Encoding
+//
+// The XCursor pseudo-encoding sends the cursor shape as a two color bitmap,
+// followed by a bitmask. The rectangle position gives the hotspot. An empty
+// rectangle hides the cursor.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#x-cursor-pseudo-encoding
+
+// XCursorPseudoEncoding represents a cursor shape update.
+type XCursorPseudoEncoding struct {
+	// Cursor holds the new cursor shape, or nil if the cursor is hidden.
+	Cursor *Cursor
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*XCursorPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*XCursorPseudoEncoding) Marshal() ([]byte, error) {
+	return nil, fmt.Errorf("Marshal() unimplemented")
+}
+
+// Read implements the Encoding interface.
+func (*XCursorPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	if err := checkCursorSize(rect); err != nil {
+		return nil, err
+	}
+
+	var cur *Cursor
+	if rect.Area() > 0 {
+		var colors [2][3]uint8 // primary and secondary red, green, blue
+		if err := c.receive(&colors); err != nil {
+			return nil, fmt.Errorf("unable to read cursor colors: %s", err)
+		}
+		size := image.Pt(int(rect.Width), int(rect.Height))
+		r := &connReader{c}
+		bitmap, err := readCursorMask(r, size)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read cursor bitmap: %s", err)
+		}
+		mask, err := readCursorMask(r, size)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read cursor mask: %s", err)
+		}
+
+		primary := color.RGBA{colors[0][0], colors[0][1], colors[0][2], 0xff}
+		secondary := color.RGBA{colors[1][0], colors[1][1], colors[1][2], 0xff}
+		img := image.NewRGBA(image.Rectangle{Max: size})
+		for i, a := range bitmap.Pix {
+			col := secondary
+			if a != 0 {
+				col = primary
+			}
+			img.SetRGBA(i%size.X, i/size.X, col)
+		}
+		cur = &Cursor{img, mask, image.Pt(int(rect.X), int(rect.Y))}
+	}
+	c.setCursor(cur)
+
+	return &XCursorPseudoEncoding{cur}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*XCursorPseudoEncoding) String() string { return "XCursorPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*XCursorPseudoEncoding) Type() encodings.Encoding { return encodings.XCursorPseudo }
+
+//-----------------------------------------------------------------------------
+// Cursor With Alpha Pseudo-Encoding
+//
+// The Cursor With Alpha pseudo-encoding sends the cursor shape as RGBA pixels
+// with premultiplied alpha, themselves encoded with another encoding. The
+// rectangle position gives the hotspot. An empty rectangle hides the cursor.
+//
+// The pixel data may use any of the encodings of the client, other than
+// pseudo-encodings. The alpha channel is lost with encodings that do not send
+// whole pixel values, which are RRE, CoRRE and the JPEG and gradient
+// compression of Tight, so such cursors are drawn opaque. Pixel data in an
+// encoding the client does not support cannot be skipped, and fails the
+// connection.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#cursor-with-alpha-pseudo-encoding
+
+// CursorWithAlphaPseudoEncoding represents a cursor shape update with an
+// alpha channel.
+type CursorWithAlphaPseudoEncoding struct {
+	// Cursor holds the new cursor shape, or nil if the cursor is hidden.
+	Cursor *Cursor
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*CursorWithAlphaPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*CursorWithAlphaPseudoEncoding) Marshal() ([]byte, error) {
+	return nil, fmt.Errorf("Marshal() unimplemented")
+}
+
+// Read implements the Encoding interface.
+func (*CursorWithAlphaPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	if err := checkCursorSize(rect); err != nil {
+		return nil, err
+	}
+
+	var enc encodings.Encoding
+	if err := c.receive(&enc); err != nil {
+		return nil, fmt.Errorf("unable to read cursor encoding: %s", err)
+	}
+
+	var cur *Cursor
+	if enc != encodings.Raw {
+		img, err := c.readCursorPixels(enc, rect)
+		if err != nil {
+			return nil, err
+		}
+		if img != nil {
+			cur = &Cursor{img, nil, image.Pt(int(rect.X), int(rect.Y))}
+		}
+	} else if rect.Area() > 0 {
+		// The pixels are red, green, blue and alpha bytes with premultiplied
+		// alpha, which is the memory layout of an image.RGBA.
+		img := image.NewRGBA(image.Rect(0, 0, int(rect.Width), int(rect.Height)))
+		if _, err := io.ReadFull(&connReader{c}, img.Pix); err != nil {
+			return nil, fmt.Errorf("unable to read cursor pixels: %s", err)
+		}
+		cur = &Cursor{img, nil, image.Pt(int(rect.X), int(rect.Y))}
+	}
+	c.setCursor(cur)
+
+	return &CursorWithAlphaPseudoEncoding{cur}, nil
+}
+
+// cursorPixelFormat is the format of Cursor With Alpha pixels: red, green,
+// blue and alpha bytes, in that order.
+var cursorPixelFormat = PixelFormat{
+	BPP:        32,
+	Depth:      32,
+	BigEndian:  rfbflags.RFBFalse,
+	TrueColor:  rfbflags.RFBTrue,
+	RedMax:     255,
+	GreenMax:   255,
+	BlueMax:    255,
+	RedShift:   0,
+	GreenShift: 8,
+	BlueShift:  16,
+}
+
+// readCursorPixels reads the Cursor With Alpha pixels of rect sent with the
+// encoding enc, by decoding them with the pixel format of cursors into an
+// image of their own. It returns nil for an empty rectangle.
+func (c *ClientConn) readCursorPixels(enc encodings.Encoding, rect *Rectangle) (*image.RGBA, error) {
+	e, ok := c.Encodable(enc)
+	if !ok || enc < 0 {
+		return nil, fmt.Errorf("unsupported cursor encoding: %v", enc)
+	}
+
+	pf := c.pixelFormat
+	c.pixelFormat, c.alphaPixels = cursorPixelFormat, true
+	c.target = NewFramebuffer(int(rect.Width), int(rect.Height))
+	defer func() {
+		c.pixelFormat, c.alphaPixels, c.target = pf, false, nil
+	}()
+
+	if _, err := e.Read(c, &Rectangle{Width: rect.Width, Height: rect.Height, Enc: e}); err != nil {
+		return nil, fmt.Errorf("unable to read cursor pixels: %s", err)
+	}
+	if rect.Area() == 0 {
+		return nil, nil
+	}
+	return c.target.Snapshot(), nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*CursorWithAlphaPseudoEncoding) String() string { return "CursorWithAlphaPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*CursorWithAlphaPseudoEncoding) Type() encodings.Encoding {
+	return encodings.CursorWithAlphaPseudo
+}
+
+//-----------------------------------------------------------------------------
+// PointerPos Pseudo-Encoding
+//
+// The PointerPos pseudo-encoding tells the client that the server has moved
+// the pointer. The rectangle position gives the new pointer position.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#pointerpos-pseudo-encoding
+
+// PointerPosPseudoEncoding represents a pointer position update.
+type PointerPosPseudoEncoding struct {
+	X, Y uint16
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*PointerPosPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*PointerPosPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (*PointerPosPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	c.setPointerPos(image.Pt(int(rect.X), int(rect.Y)))
+	return &PointerPosPseudoEncoding{rect.X, rect.Y}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*PointerPosPseudoEncoding) String() string { return "PointerPosPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*PointerPosPseudoEncoding) Type() encodings.Encoding { return encodings.PointerPosPseudo }
+
+//-----------------------------------------------------------------------------
+// CursorUpdate is synthesized by the client when a FramebufferUpdate contains
+// a Cursor, XCursor or Cursor With Alpha pseudo-encoded rectangle. It is sent on the
+// ServerMessage channel after the FramebufferUpdate that contained it.
+
+// CursorUpdate holds the new cursor shape.
+type CursorUpdate struct {
+	// Cursor holds the new cursor shape, or nil if the cursor is hidden.
+	Cursor *Cursor
+}
+
+// Verify that interfaces are honored.
+var _ ServerMessage = (*CursorUpdate)(nil)
+
+// Type implements the ServerMessage interface.
+func (*CursorUpdate) Type() messages.ServerMessage { return messages.CursorUpdate }
+
+// Read implements the ServerMessage interface. CursorUpdate is never sent on
+// the wire, so it can not be read.
+func (*CursorUpdate) Read(c *ClientConn) (ServerMessage, error) {
+	return nil, fmt.Errorf("CursorUpdate is not a wire message")
+}
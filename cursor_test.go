@@ -0,0 +1,332 @@
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/messages"
+)
+
+func TestCursorPseudoEncoding_Type(t *testing.T) {
+	e := &CursorPseudoEncoding{}
+	if got, want := e.Type(), encodings.CursorPseudo; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+	if got, want := encodings.ColorPseudo, encodings.CursorPseudo; got != want {
+		t.Errorf("incorrect deprecated encoding; got = %s, want = %s", got, want)
+	}
+}
+
+func TestCursorPseudoEncoding_Read(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat{BPP: 32, Depth: 24, BigEndian: RFBTrue, TrueColor: RFBTrue,
+		RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0}
+
+	// A 2x2 cursor with its hotspot at (1, 0), and the bottom right pixel
+	// transparent.
+	data := []byte{
+		0, 255, 0, 0, 0, 0, 255, 0, // red, green
+		0, 0, 0, 255, 0, 255, 255, 255, // blue, white
+		0xc0, 0x80, // mask
+	}
+	if err := conn.send(data); err != nil {
+		t.Fatal(err)
+	}
+	rect := &Rectangle{X: 1, Y: 0, Width: 2, Height: 2}
+	enc, err := (&CursorPseudoEncoding{}).Read(conn, rect)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mockConn.b.Len() != 0 {
+		t.Errorf("%d bytes left unread", mockConn.b.Len())
+	}
+
+	cur := enc.(*CursorPseudoEncoding).Cursor
+	if got, want := conn.Cursor(), cur; got != want {
+		t.Errorf("incorrect connection cursor; got = %v, want = %v", got, want)
+	}
+	if got, want := cur.Hotspot, image.Pt(1, 0); got != want {
+		t.Errorf("incorrect hotspot; got = %v, want = %v", got, want)
+	}
+	for _, tt := range []struct {
+		p     image.Point
+		color color.RGBA
+		alpha uint8
+	}{
+		{image.Pt(0, 0), color.RGBA{255, 0, 0, 255}, 0xff},
+		{image.Pt(1, 0), color.RGBA{0, 255, 0, 255}, 0xff},
+		{image.Pt(0, 1), color.RGBA{0, 0, 255, 255}, 0xff},
+		{image.Pt(1, 1), color.RGBA{255, 255, 255, 255}, 0},
+	} {
+		if got, want := cur.Image.RGBAAt(tt.p.X, tt.p.Y), tt.color; got != want {
+			t.Errorf("incorrect pixel at %v; got = %v, want = %v", tt.p, got, want)
+		}
+		if got, want := cur.Mask.AlphaAt(tt.p.X, tt.p.Y).A, tt.alpha; got != want {
+			t.Errorf("incorrect mask at %v; got = %v, want = %v", tt.p, got, want)
+		}
+	}
+
+	// An empty rectangle hides the cursor.
+	if _, err := (&CursorPseudoEncoding{}).Read(conn, &Rectangle{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := conn.Cursor(); got != nil {
+		t.Errorf("expected hidden cursor; got = %v", got)
+	}
+	if got, want := len(conn.takePending()), 2; got != want {
+		t.Errorf("incorrect number of pending messages; got = %d, want = %d", got, want)
+	}
+}
+
+func TestCursorPseudoEncoding_ReadTooLarge(t *testing.T) {
+	for _, e := range []Encoding{&CursorPseudoEncoding{}, &XCursorPseudoEncoding{}, &CursorWithAlphaPseudoEncoding{}} {
+		for _, rect := range []*Rectangle{
+			{Width: maxCursorSize + 1, Height: 1},
+			{Width: 1, Height: 0xffff},
+		} {
+			conn := NewClientConn(&MockConn{}, &ClientConfig{})
+			_, err := e.Read(conn, rect)
+			if err == nil || !strings.Contains(err.Error(), "exceeds") {
+				t.Errorf("%s: expected error for a %dx%d cursor; got = %v", e, rect.Width, rect.Height, err)
+			}
+		}
+	}
+}
+
+func TestXCursorPseudoEncoding_Type(t *testing.T) {
+	e := &XCursorPseudoEncoding{}
+	if got, want := e.Type(), encodings.XCursorPseudo; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+}
+
+func TestXCursorPseudoEncoding_Read(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	// A 9x1 cursor, so that rows are padded to two bytes.
+	data := []byte{
+		255, 0, 0, // primary
+		0, 0, 255, // secondary
+		0xaa, 0x80, // bitmap
+		0xff, 0x00, // mask
+	}
+	if err := conn.send(data); err != nil {
+		t.Fatal(err)
+	}
+	rect := &Rectangle{X: 4, Y: 0, Width: 9, Height: 1}
+	enc, err := (&XCursorPseudoEncoding{}).Read(conn, rect)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mockConn.b.Len() != 0 {
+		t.Errorf("%d bytes left unread", mockConn.b.Len())
+	}
+
+	cur := enc.(*XCursorPseudoEncoding).Cursor
+	primary, secondary := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	for x, want := range []color.RGBA{
+		primary, secondary, primary, secondary, primary, secondary, primary, secondary, primary,
+	} {
+		if got := cur.Image.RGBAAt(x, 0); got != want {
+			t.Errorf("incorrect pixel at %d; got = %v, want = %v", x, got, want)
+		}
+	}
+	if got, want := cur.Mask.AlphaAt(7, 0).A, uint8(0xff); got != want {
+		t.Errorf("incorrect mask at 7; got = %v, want = %v", got, want)
+	}
+	if got, want := cur.Mask.AlphaAt(8, 0).A, uint8(0); got != want {
+		t.Errorf("incorrect mask at 8; got = %v, want = %v", got, want)
+	}
+}
+
+func TestCursor_Draw(t *testing.T) {
+	cur := &Cursor{
+		Image:   image.NewRGBA(image.Rect(0, 0, 2, 1)),
+		Mask:    image.NewAlpha(image.Rect(0, 0, 2, 1)),
+		Hotspot: image.Pt(1, 0),
+	}
+	red := color.RGBA{255, 0, 0, 255}
+	cur.Image.SetRGBA(0, 0, red)
+	cur.Image.SetRGBA(1, 0, red)
+	cur.Mask.SetAlpha(0, 0, color.Alpha{0xff})
+
+	dst := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	cur.Draw(dst, image.Pt(2, 0))
+	for x, want := range []color.RGBA{{}, red, {}, {}} {
+		if got := dst.RGBAAt(x, 0); got != want {
+			t.Errorf("incorrect pixel at %d; got = %v, want = %v", x, got, want)
+		}
+	}
+}
+
+func TestCursorWithAlphaPseudoEncoding_Type(t *testing.T) {
+	e := &CursorWithAlphaPseudoEncoding{}
+	if got, want := e.Type(), encodings.CursorWithAlphaPseudo; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+}
+
+func TestCursorWithAlphaPseudoEncoding_Read(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	// A 2x1 cursor with an opaque red and a half transparent blue pixel.
+	if err := conn.send(encodings.Raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send([]byte{255, 0, 0, 255, 0, 0, 128, 128}); err != nil {
+		t.Fatal(err)
+	}
+	rect := &Rectangle{X: 0, Y: 1, Width: 2, Height: 1}
+	enc, err := (&CursorWithAlphaPseudoEncoding{}).Read(conn, rect)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mockConn.b.Len() != 0 {
+		t.Errorf("%d bytes left unread", mockConn.b.Len())
+	}
+
+	cur := enc.(*CursorWithAlphaPseudoEncoding).Cursor
+	if got, want := conn.Cursor(), cur; got != want {
+		t.Errorf("incorrect connection cursor; got = %v, want = %v", got, want)
+	}
+	if cur.Mask != nil {
+		t.Errorf("unexpected mask: %v", cur.Mask)
+	}
+	if got, want := cur.Hotspot, image.Pt(0, 1); got != want {
+		t.Errorf("incorrect hotspot; got = %v, want = %v", got, want)
+	}
+
+	// Compositing onto white blends the transparent pixel.
+	dst := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	fillRGBA(dst, dst.Rect, color.RGBA{255, 255, 255, 255})
+	cur.Draw(dst, image.Pt(0, 1))
+	for x, want := range []color.RGBA{{255, 0, 0, 255}, {127, 127, 255, 255}} {
+		if got := dst.RGBAAt(x, 0); got != want {
+			t.Errorf("incorrect pixel at %d; got = %v, want = %v", x, got, want)
+		}
+	}
+
+	// Encodings the client does not support cannot be read.
+	if err := conn.send(encodings.ZRLE); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&CursorWithAlphaPseudoEncoding{}).Read(conn, rect); err == nil {
+		t.Error("expected error for unsupported encoding")
+	}
+}
+
+func TestCursorWithAlphaPseudoEncoding_ReadEncoded(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.encodings = Encodings{&ZRLEEncoding{}, &RawEncoding{}}
+	conn.fb = NewFramebuffer(4, 4)
+	pf := conn.pixelFormat
+
+	// The same 2x1 cursor as a raw ZRLE tile, with four byte CPIXELs.
+	var z zlibTestStream
+	data := z.compress(t, []byte{0, 255, 0, 0, 255, 0, 0, 128, 128})
+	if err := conn.send(encodings.ZRLE); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send(uint32(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send(data); err != nil {
+		t.Fatal(err)
+	}
+	rect := &Rectangle{X: 1, Y: 0, Width: 2, Height: 1}
+	enc, err := (&CursorWithAlphaPseudoEncoding{}).Read(conn, rect)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mockConn.b.Len() != 0 {
+		t.Errorf("%d bytes left unread", mockConn.b.Len())
+	}
+
+	cur := enc.(*CursorWithAlphaPseudoEncoding).Cursor
+	for x, want := range []color.RGBA{{255, 0, 0, 255}, {0, 0, 128, 128}} {
+		if got := cur.Image.RGBAAt(x, 0); got != want {
+			t.Errorf("incorrect cursor pixel at %d; got = %v, want = %v", x, got, want)
+		}
+	}
+	if got, want := cur.Hotspot, image.Pt(1, 0); got != want {
+		t.Errorf("incorrect hotspot; got = %v, want = %v", got, want)
+	}
+
+	// The connection state is left as it was.
+	if got := conn.Framebuffer().At(1, 0); got != (color.RGBA{0, 0, 0, 255}) {
+		t.Errorf("framebuffer drawn into; got = %v", got)
+	}
+	if conn.pixelFormat != pf || conn.alphaPixels || conn.target != nil {
+		t.Error("connection state not restored")
+	}
+}
+
+func TestPointerPosPseudoEncoding_Type(t *testing.T) {
+	e := &PointerPosPseudoEncoding{}
+	if got, want := e.Type(), encodings.PointerPosPseudo; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+}
+
+func TestPointerPosPseudoEncoding_Read(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	rect := &Rectangle{X: 12, Y: 34}
+	enc, err := (&PointerPosPseudoEncoding{}).Read(conn, rect)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := *enc.(*PointerPosPseudoEncoding), (PointerPosPseudoEncoding{12, 34}); got != want {
+		t.Errorf("incorrect encoding; got = %v, want = %v", got, want)
+	}
+	if got, want := conn.PointerPos(), image.Pt(12, 34); got != want {
+		t.Errorf("incorrect pointer position; got = %v, want = %v", got, want)
+	}
+
+	// The position also follows pointer events sent by the client.
+	if err := conn.PointerEvent(0, 56, 78); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conn.PointerPos(), image.Pt(56, 78); got != want {
+		t.Errorf("incorrect pointer position; got = %v, want = %v", got, want)
+	}
+}
+
+func TestClientConn_ListenAndHandleCursorUpdate(t *testing.T) {
+	mockConn := &MockConn{}
+	ch := make(chan ServerMessage, 2)
+	cfg := NewClientConfig("")
+	cfg.ServerMessageCh = ch
+	conn := NewClientConn(mockConn, cfg)
+	conn.encodings = Encodings{&RawEncoding{}, &XCursorPseudoEncoding{}}
+
+	// A FramebufferUpdate with a single, empty XCursor rectangle.
+	if err := conn.send([]byte{byte(messages.FramebufferUpdate), 0, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send(rectangleMessage{E: encodings.XCursorPseudo}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.ListenAndHandle(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, want := range []messages.ServerMessage{messages.FramebufferUpdate, messages.CursorUpdate} {
+		select {
+		case msg := <-ch:
+			if got := msg.Type(); got != want {
+				t.Errorf("incorrect message; got = %v, want = %v", got, want)
+			}
+		default:
+			t.Fatalf("missing %v message", want)
+		}
+	}
+}
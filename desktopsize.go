@@ -0,0 +1,179 @@
+/*
+Implementation of the ExtendedDesktopSize pseudo-encoding and the
+SetDesktopSize client message.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#extendeddesktopsize-pseudo-encoding
+*/
+package vnc
+
+import (
+	"fmt"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/messages"
+)
+
+// Screen describes one screen of the framebuffer layout.
+type Screen struct {
+	ID            uint32 // id
+	X, Y          uint16 // x-, y-position
+	Width, Height uint16 // width, height
+	Flags         uint32 // flags
+}
+
+// DesktopSizeReason is the reason for an ExtendedDesktopSize update.
+type DesktopSizeReason uint16
+
+// ExtendedDesktopSize reasons.
+const (
+	// The size was changed by the server.
+	DesktopSizeServer DesktopSizeReason = iota
+	// The size was changed at the request of this client.
+	DesktopSizeClient
+	// The size was changed at the request of another client.
+	DesktopSizeOtherClient
+)
+
+// DesktopSizeStatus is the result of a SetDesktopSize request.
+type DesktopSizeStatus uint16
+
+// ExtendedDesktopSize status codes.
+const (
+	DesktopSizeOK DesktopSizeStatus = iota
+	DesktopSizeProhibited
+	DesktopSizeOutOfResources
+	DesktopSizeInvalidLayout
+)
+
+// Screens returns the screen layout most recently sent by the server with the
+// ExtendedDesktopSize pseudo-encoding.
+func (c *ClientConn) Screens() []Screen {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Screen(nil), c.screens...)
+}
+
+//-----------------------------------------------------------------------------
+// ExtendedDesktopSize Pseudo-Encoding
+//
+// The ExtendedDesktopSize pseudo-encoding extends DesktopSize with the screen
+// layout of the framebuffer, and the result of SetDesktopSize requests. The
+// rectangle position gives the reason and status, and its size the
+// framebuffer size.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#extendeddesktopsize-pseudo-encoding
+
+// ExtendedDesktopSizePseudoEncoding represents a desktop size and screen
+// layout message from the server.
+type ExtendedDesktopSizePseudoEncoding struct {
+	Reason  DesktopSizeReason
+	Status  DesktopSizeStatus
+	Screens []Screen
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*ExtendedDesktopSizePseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (e *ExtendedDesktopSizePseudoEncoding) Marshal() ([]byte, error) {
+	buf := NewBuffer(nil)
+	if err := buf.Write([4]uint8{uint8(len(e.Screens))}); err != nil {
+		return nil, err
+	}
+	if err := buf.Write(e.Screens); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Read implements the Encoding interface.
+func (*ExtendedDesktopSizePseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	var hdr [4]uint8 // number-of-screens, padding
+	if err := c.receive(&hdr); err != nil {
+		return nil, fmt.Errorf("unable to read screen layout: %s", err)
+	}
+	screens := make([]Screen, hdr[0])
+	if err := c.receive(&screens); err != nil {
+		return nil, fmt.Errorf("unable to read screen layout: %s", err)
+	}
+
+	e := &ExtendedDesktopSizePseudoEncoding{
+		Reason:  DesktopSizeReason(rect.X),
+		Status:  DesktopSizeStatus(rect.Y),
+		Screens: screens,
+	}
+	if e.Status != DesktopSizeOK {
+		return e, nil
+	}
+
+	c.mu.Lock()
+	c.screens = screens
+	c.mu.Unlock()
+	if rect.Width != c.fbWidth || rect.Height != c.fbHeight {
+		c.fbWidth = rect.Width
+		c.fbHeight = rect.Height
+		c.fb.resize(int(rect.Width), int(rect.Height))
+	}
+
+	return e, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (e *ExtendedDesktopSizePseudoEncoding) String() string {
+	return fmt.Sprintf("ExtendedDesktopSizePseudoEncoding{reason: %d, status: %d, screens: %v}",
+		e.Reason, e.Status, e.Screens)
+}
+
+// Type implements the Encoding interface.
+func (*ExtendedDesktopSizePseudoEncoding) Type() encodings.Encoding {
+	return encodings.ExtendedDesktopSizePseudo
+}
+
+//-----------------------------------------------------------------------------
+// SetDesktopSize asks the server to change the framebuffer size and screen
+// layout. It requires the server to support the ExtendedDesktopSize
+// pseudo-encoding.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#setdesktopsize
+
+// SetDesktopSizeMessage holds the wire format message, sans the screens.
+type SetDesktopSizeMessage struct {
+	Msg           messages.ClientMessage // message-type
+	_             [1]byte                // padding
+	Width, Height uint16                 // width, height
+	NumScreens    uint8                  // number-of-screens
+	_             [1]byte                // padding
+}
+
+// SetDesktopSize requests that the server change the framebuffer size and
+// screen layout. If screens is empty, a single screen covering the whole
+// framebuffer is requested, keeping the ID of the current first screen.
+//
+// The result is sent by the server as an ExtendedDesktopSize rectangle, with
+// a reason of DesktopSizeClient.
+func (c *ClientConn) SetDesktopSize(width, height uint16, screens []Screen) error {
+	if len(screens) == 0 {
+		var id uint32
+		if cur := c.Screens(); len(cur) > 0 {
+			id = cur[0].ID
+		}
+		screens = []Screen{{ID: id, Width: width, Height: height}}
+	}
+	if len(screens) > 255 {
+		return fmt.Errorf("too many screens: %d", len(screens))
+	}
+
+	buf := NewBuffer(nil)
+	msg := SetDesktopSizeMessage{
+		Msg:        messages.SetDesktopSize,
+		Width:      width,
+		Height:     height,
+		NumScreens: uint8(len(screens)),
+	}
+	if err := buf.Write(msg); err != nil {
+		return err
+	}
+	if err := buf.Write(screens); err != nil {
+		return err
+	}
+	return c.send(buf.Bytes())
+}
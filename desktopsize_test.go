@@ -0,0 +1,119 @@
+package vnc
+
+import (
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/messages"
+)
+
+func TestExtendedDesktopSizePseudoEncoding_Type(t *testing.T) {
+	e := &ExtendedDesktopSizePseudoEncoding{}
+	if got, want := e.Type(), encodings.ExtendedDesktopSizePseudo; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+}
+
+func TestExtendedDesktopSizePseudoEncoding_Read(t *testing.T) {
+	screens := []Screen{
+		{ID: 1, X: 0, Y: 0, Width: 640, Height: 480},
+		{ID: 2, X: 640, Y: 0, Width: 160, Height: 480, Flags: 1},
+	}
+
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.fb = NewFramebuffer(10, 10)
+
+	for _, tt := range []struct {
+		desc    string
+		rect    Rectangle
+		screens []Screen
+		bounds  image.Rectangle
+		layout  []Screen
+	}{
+		{"server change",
+			Rectangle{X: uint16(DesktopSizeServer), Y: uint16(DesktopSizeOK), Width: 800, Height: 480},
+			screens, image.Rect(0, 0, 800, 480), screens},
+		{"rejected client request",
+			Rectangle{X: uint16(DesktopSizeClient), Y: uint16(DesktopSizeInvalidLayout), Width: 800, Height: 480},
+			screens[:1], image.Rect(0, 0, 800, 480), screens},
+	} {
+		e := &ExtendedDesktopSizePseudoEncoding{Screens: tt.screens}
+		data, err := e.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.send(data); err != nil {
+			t.Fatal(err)
+		}
+
+		enc, err := (&ExtendedDesktopSizePseudoEncoding{}).Read(conn, &tt.rect)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%s: %d bytes left unread", tt.desc, mockConn.b.Len())
+		}
+		got := enc.(*ExtendedDesktopSizePseudoEncoding)
+		want := &ExtendedDesktopSizePseudoEncoding{
+			DesktopSizeReason(tt.rect.X), DesktopSizeStatus(tt.rect.Y), tt.screens,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: incorrect encoding; got = %v, want = %v", tt.desc, got, want)
+		}
+		if got, want := conn.Framebuffer().Bounds(), tt.bounds; got != want {
+			t.Errorf("%s: incorrect framebuffer bounds; got = %v, want = %v", tt.desc, got, want)
+		}
+		if got, want := conn.Screens(), tt.layout; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: incorrect screens; got = %v, want = %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestSetDesktopSize(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.screens = []Screen{{ID: 7, Width: 640, Height: 480}}
+
+	for _, tt := range []struct {
+		screens []Screen
+		want    []Screen
+	}{
+		{nil, []Screen{{ID: 7, Width: 1024, Height: 768}}},
+		{
+			[]Screen{{ID: 1, Width: 512, Height: 768}, {ID: 2, X: 512, Width: 512, Height: 768}},
+			[]Screen{{ID: 1, Width: 512, Height: 768}, {ID: 2, X: 512, Width: 512, Height: 768}},
+		},
+	} {
+		mockConn.Reset()
+		if err := conn.SetDesktopSize(1024, 768, tt.screens); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var msg SetDesktopSizeMessage
+		if err := conn.receive(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := msg.Msg, messages.SetDesktopSize; got != want {
+			t.Errorf("incorrect message-type; got = %v, want = %v", got, want)
+		}
+		if msg.Width != 1024 || msg.Height != 768 {
+			t.Errorf("incorrect size; got = %dx%d, want = 1024x768", msg.Width, msg.Height)
+		}
+		if got, want := int(msg.NumScreens), len(tt.want); got != want {
+			t.Fatalf("incorrect number-of-screens; got = %d, want = %d", got, want)
+		}
+		screens := make([]Screen, msg.NumScreens)
+		if err := conn.receive(&screens); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(screens, tt.want) {
+			t.Errorf("incorrect screens; got = %v, want = %v", screens, tt.want)
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%d bytes left unread", mockConn.b.Len())
+		}
+	}
+}
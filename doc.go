@@ -1,5 +1,5 @@
 /*
-Package vnc provides VNC client implementation.
+Package vnc provides VNC client and server implementations.
 
 This package implements The Remote Framebuffer Protocol as documented in
 [RFC 6143](http://tools.ietf.org/html/rfc6143).
@@ -63,5 +63,25 @@ with something more appropriate for your setup.
 This example will connect to a VNC server running on the localhost. It will
 periodically request updates from the server, and listen for and handle
 incoming FramebufferUpdate messages coming from the server.
+
+Rather than polling, servers that support continuous updates can send updates
+as soon as the framebuffer changes. Include ContinuousUpdatesPseudoEncoding in
+ClientConfig.Encodings, and once an EndOfContinuousUpdates message has been
+received, call EnableContinuousUpdates instead of FramebufferUpdateRequest.
+
+A server is created with a ServerConfig, whose Handler is called with each
+message read from a client. Serve accepts connections from a net.Listener,
+negotiates each of them, and passes their messages to the Handler, which
+replies with the methods of the ServerConn, such as FramebufferUpdate.
+
+Rather than answering FramebufferUpdateRequest messages itself, a server can
+set the Source of its ServerConfig to a FrameSource, such as a Canvas, and
+updates are then sent with only the areas that changed. Updates are encoded
+with whichever of the Encoders of the ServerConfig the client prefers.
+
+Clients are authenticated with the Auth of the ServerConfig, such as
+ServerAuthVNC or ServerAuthVeNCrypt, and no authentication by default. Once a
+client has authenticated, the Authorizer of the ServerConfig, if set, decides
+whether it may use the server.
 */
 package vnc
@@ -0,0 +1,703 @@
+// Server-side encoding of framebuffer updates.
+
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/rfbflags"
+)
+
+// A ServerEncoder encodes areas of an image as the pixel data of rectangles
+// sent to a client. An encoder may keep state between rectangles, such as
+// zlib streams, so each ServerConn creates its own encoders.
+type ServerEncoder interface {
+	// Type returns the encoding type implemented by the encoder.
+	Type() encodings.Encoding
+
+	// Encode returns the pixel data of the area r of img, as sent after the
+	// rectangle header.
+	Encode(img image.Image, r image.Rectangle, opts *EncodeOptions) ([]byte, error)
+}
+
+// EncodeOptions holds the parameters of an update, as negotiated with the
+// client.
+type EncodeOptions struct {
+	// PixelFormat is the pixel format requested by the client.
+	PixelFormat PixelFormat
+
+	// CompressLevel is the zlib compression level requested by the client,
+	// from 0 to 9, or -1 if it did not request one.
+	CompressLevel int
+
+	// QualityLevel is the JPEG quality level requested by the client, from 0
+	// to 9, or -1 if it did not request one, and JPEG must not be used.
+	QualityLevel int
+}
+
+// DefaultServerEncoders are the encoders offered to clients, if a
+// ServerConfig does not set Encoders.
+var DefaultServerEncoders = []func() ServerEncoder{
+	NewRawEncoder,
+	NewHextileEncoder,
+	NewZRLEEncoder,
+	NewTightEncoder,
+}
+
+// rectSplitter is implemented by encoders that limit the size of the
+// rectangles they encode.
+type rectSplitter interface {
+	// split divides r into rectangles that can each be encoded.
+	split(r image.Rectangle) []image.Rectangle
+}
+
+// pixelData holds the pixel values of an area of an image, in a client's
+// pixel format.
+type pixelData struct {
+	pf   *PixelFormat
+	rect image.Rectangle
+	pix  []uint32 // Pixel values in row-major order.
+}
+
+// newPixelData converts the area r of img to the pixel format pf.
+func newPixelData(img image.Image, r image.Rectangle, pf *PixelFormat) *pixelData {
+	t := translatorFor(*pf)
+	p := &pixelData{pf: pf, rect: r, pix: make([]uint32, 0, r.Dx()*r.Dy())}
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			i := rgba.PixOffset(r.Min.X, y)
+			for x := r.Min.X; x < r.Max.X; x++ {
+				p.pix = append(p.pix, t.pixel(rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2]))
+				i += 4
+			}
+		}
+		return p
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			p.pix = append(p.pix, t.pixel(c.R, c.G, c.B))
+		}
+	}
+	return p
+}
+
+// at returns the pixel value at (x, y).
+func (p *pixelData) at(x, y int) uint32 {
+	return p.pix[(y-p.rect.Min.Y)*p.rect.Dx()+x-p.rect.Min.X]
+}
+
+// appendPixels appends the pixel values of the area t, each taking size
+// bytes.
+func (p *pixelData) appendPixels(b []byte, t image.Rectangle, size int) []byte {
+	for y := t.Min.Y; y < t.Max.Y; y++ {
+		for x := t.Min.X; x < t.Max.X; x++ {
+			b = p.pf.appendPixel(b, p.at(x, y), size)
+		}
+	}
+	return b
+}
+
+// appendPixel appends a pixel value of size bytes, in the byte order of the
+// pixel format. It is the inverse of pixel.
+func (pf PixelFormat) appendPixel(b []byte, pixel uint32, size int) []byte {
+	order := pf.order()
+	var data [4]byte
+	switch size {
+	case 1:
+		return append(b, uint8(pixel))
+	case 2:
+		order.PutUint16(data[:2], uint16(pixel))
+		return append(b, data[:2]...)
+	case 3:
+		// Drop the unused byte, as in pixel.
+		order.PutUint32(data[:], pixel)
+		if (pf.colorMask()&0xff000000 == 0) == rfbflags.IsBigEndian(pf.BigEndian) {
+			return append(b, data[1:]...)
+		}
+		return append(b, data[:3]...)
+	}
+	order.PutUint32(data[:], pixel)
+	return append(b, data[:]...)
+}
+
+// zlibWriter deflates data into a zlib stream that the server sends in
+// chunks across many rectangles. The compression state is kept for the
+// lifetime of the connection, unless the stream is reset.
+type zlibWriter struct {
+	buf   bytes.Buffer
+	w     *zlib.Writer // deflater, created with the first chunk
+	level int
+}
+
+// compress deflates data as the next chunk of the stream, and returns the
+// chunk. The compression level is set when the stream starts.
+func (z *zlibWriter) compress(data []byte, level int) ([]byte, error) {
+	if z.w == nil {
+		zl := zlib.DefaultCompression
+		if level >= 0 {
+			zl = level
+		}
+		w, err := zlib.NewWriterLevel(&z.buf, zl)
+		if err != nil {
+			return nil, err
+		}
+		z.w, z.level = w, level
+	}
+	if _, err := z.w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := z.w.Flush(); err != nil {
+		return nil, err
+	}
+	chunk := append([]byte(nil), z.buf.Bytes()...)
+	z.buf.Reset()
+	return chunk, nil
+}
+
+// reset discards the stream so that the next chunk starts a new one.
+func (z *zlibWriter) reset() {
+	z.buf.Reset()
+	z.w = nil
+}
+
+//-----------------------------------------------------------------------------
+// Raw Encoder
+//
+// See RFC 6143 §7.7.1.
+
+type rawEncoder struct{}
+
+// NewRawEncoder returns a ServerEncoder for Raw encoding, which every client
+// supports.
+func NewRawEncoder() ServerEncoder { return &rawEncoder{} }
+
+// Type implements the ServerEncoder interface.
+func (*rawEncoder) Type() encodings.Encoding { return encodings.Raw }
+
+// Encode implements the ServerEncoder interface.
+func (*rawEncoder) Encode(img image.Image, r image.Rectangle, opts *EncodeOptions) ([]byte, error) {
+	p := newPixelData(img, r, &opts.PixelFormat)
+	size := opts.PixelFormat.bytesPerPixel()
+	return p.appendPixels(make([]byte, 0, len(p.pix)*size), r, size), nil
+}
+
+//-----------------------------------------------------------------------------
+// Hextile Encoder
+//
+// Each tile is sent as a background color with subrectangles of other
+// colors, or as raw pixel data when that is smaller.
+//
+// See RFC 6143 §7.7.4.
+
+type hextileEncoder struct{}
+
+// NewHextileEncoder returns a ServerEncoder for Hextile encoding.
+func NewHextileEncoder() ServerEncoder { return &hextileEncoder{} }
+
+// Type implements the ServerEncoder interface.
+func (*hextileEncoder) Type() encodings.Encoding { return encodings.Hextile }
+
+// hextileSubrect is a subrectangle of a Hextile tile, relative to the tile.
+type hextileSubrect struct {
+	pixel      uint32
+	x, y, w, h int
+}
+
+// Encode implements the ServerEncoder interface.
+func (*hextileEncoder) Encode(img image.Image, r image.Rectangle, opts *EncodeOptions) ([]byte, error) {
+	p := newPixelData(img, r, &opts.PixelFormat)
+	size := opts.PixelFormat.bytesPerPixel()
+
+	var (
+		b                []byte
+		bg, fg           uint32
+		bgValid, fgValid bool // Whether bg and fg carry over to the next tile.
+	)
+	for _, t := range tiles(r, hextileTileSize) {
+		colors := tileColors(p, t, 3)
+		tbg := mostFrequent(p, t, colors)
+
+		var subrects []hextileSubrect
+		if len(colors) > 1 {
+			subrects = findSubrects(p, t, tbg)
+		}
+		coloured := len(colors) > 2
+
+		// Work out the size of the tile when encoded with subrectangles, and
+		// fall back to raw pixel data if that is not larger.
+		var mask uint8
+		n := 1
+		if !bgValid || tbg != bg {
+			mask |= hextileBackgroundSpecified
+			n += size
+		}
+		var tfg uint32
+		if len(subrects) > 0 {
+			mask |= hextileAnySubrects
+			n++
+			if coloured {
+				mask |= hextileSubrectsColoured
+				n += len(subrects) * (size + 2)
+			} else {
+				tfg = subrects[0].pixel
+				if !fgValid || tfg != fg {
+					mask |= hextileForegroundSpecified
+					n += size
+				}
+				n += len(subrects) * 2
+			}
+		}
+		if raw := 1 + t.Dx()*t.Dy()*size; len(subrects) > 255 || n >= raw {
+			b = append(b, hextileRaw)
+			b = p.appendPixels(b, t, size)
+			bgValid, fgValid = false, false
+			continue
+		}
+
+		b = append(b, mask)
+		if mask&hextileBackgroundSpecified != 0 {
+			b = opts.PixelFormat.appendPixel(b, tbg, size)
+		}
+		bg, bgValid = tbg, true
+		if mask&hextileForegroundSpecified != 0 {
+			b = opts.PixelFormat.appendPixel(b, tfg, size)
+			fg, fgValid = tfg, true
+		}
+		if mask&hextileAnySubrects == 0 {
+			continue
+		}
+		b = append(b, uint8(len(subrects)))
+		for _, s := range subrects {
+			if coloured {
+				b = opts.PixelFormat.appendPixel(b, s.pixel, size)
+			}
+			b = append(b, uint8(s.x<<4|s.y), uint8((s.w-1)<<4|(s.h-1)))
+		}
+		if coloured {
+			// Coloured subrectangles leave the foreground undefined.
+			fgValid = false
+		}
+	}
+	return b, nil
+}
+
+// tileColors returns the distinct pixel values of the area t, stopping once
+// max have been found.
+func tileColors(p *pixelData, t image.Rectangle, max int) []uint32 {
+	var (
+		colors []uint32
+		seen   = make(map[uint32]bool)
+	)
+	for y := t.Min.Y; y < t.Max.Y; y++ {
+		for x := t.Min.X; x < t.Max.X; x++ {
+			if v := p.at(x, y); !seen[v] {
+				seen[v] = true
+				if colors = append(colors, v); len(colors) == max {
+					return colors
+				}
+			}
+		}
+	}
+	return colors
+}
+
+// mostFrequent returns which of colors is used most in the area t.
+func mostFrequent(p *pixelData, t image.Rectangle, colors []uint32) uint32 {
+	counts := make([]int, len(colors))
+	for y := t.Min.Y; y < t.Max.Y; y++ {
+		for x := t.Min.X; x < t.Max.X; x++ {
+			v := p.at(x, y)
+			for i, c := range colors {
+				if c == v {
+					counts[i]++
+					break
+				}
+			}
+		}
+	}
+	best := 0
+	for i := range counts {
+		if counts[i] > counts[best] {
+			best = i
+		}
+	}
+	return colors[best]
+}
+
+// findSubrects covers the pixels of the tile t that are not the background
+// with solid subrectangles.
+func findSubrects(p *pixelData, t image.Rectangle, bg uint32) []hextileSubrect {
+	var (
+		subrects []hextileSubrect
+		covered  [hextileTileSize * hextileTileSize]bool
+	)
+	w, h := t.Dx(), t.Dy()
+	at := func(x, y int) uint32 { return p.at(t.Min.X+x, t.Min.Y+y) }
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := at(x, y)
+			if v == bg || covered[y*hextileTileSize+x] {
+				continue
+			}
+
+			// Extend the subrectangle right, and then down while whole rows
+			// match.
+			sw := 1
+			for x+sw < w && at(x+sw, y) == v && !covered[y*hextileTileSize+x+sw] {
+				sw++
+			}
+			sh := 1
+		rows:
+			for y+sh < h {
+				for i := x; i < x+sw; i++ {
+					if at(i, y+sh) != v || covered[(y+sh)*hextileTileSize+i] {
+						break rows
+					}
+				}
+				sh++
+			}
+
+			for j := y; j < y+sh; j++ {
+				for i := x; i < x+sw; i++ {
+					covered[j*hextileTileSize+i] = true
+				}
+			}
+			subrects = append(subrects, hextileSubrect{v, x, y, sw, sh})
+		}
+	}
+	return subrects
+}
+
+//-----------------------------------------------------------------------------
+// ZRLE Encoder
+//
+// Each tile is sent with whichever of the raw, solid, packed palette, plain
+// RLE and palette RLE subencodings is smallest, and all the tiles are
+// compressed with a single zlib stream that lasts for the whole connection.
+//
+// See RFC 6143 §7.7.6.
+
+type zrleEncoder struct {
+	z zlibWriter
+}
+
+// NewZRLEEncoder returns a ServerEncoder for ZRLE encoding.
+func NewZRLEEncoder() ServerEncoder { return &zrleEncoder{} }
+
+// Type implements the ServerEncoder interface.
+func (*zrleEncoder) Type() encodings.Encoding { return encodings.ZRLE }
+
+// Encode implements the ServerEncoder interface.
+func (e *zrleEncoder) Encode(img image.Image, r image.Rectangle, opts *EncodeOptions) ([]byte, error) {
+	p := newPixelData(img, r, &opts.PixelFormat)
+	size := opts.PixelFormat.cpixelSize()
+
+	var data []byte
+	for _, t := range tiles(r, zrleTileSize) {
+		data = encodeRLETile(data, p, t, size)
+	}
+	chunk, err := e.z.compress(data, opts.CompressLevel)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compress zrle data: %s", err)
+	}
+
+	b := make([]byte, 4, 4+len(chunk))
+	binary.BigEndian.PutUint32(b, uint32(len(chunk)))
+	return append(b, chunk...), nil
+}
+
+// rleRun is a run of pixels with the same value.
+type rleRun struct {
+	pixel uint32
+	n     int
+}
+
+// encodeRLETile appends the tile t with the smallest subencoding.
+func encodeRLETile(b []byte, p *pixelData, t image.Rectangle, size int) []byte {
+	palette := tileColors(p, t, 128)
+	index := make(map[uint32]uint8, len(palette))
+	for i, c := range palette {
+		index[c] = uint8(i)
+	}
+
+	var runs []rleRun
+	for y := t.Min.Y; y < t.Max.Y; y++ {
+		for x := t.Min.X; x < t.Max.X; x++ {
+			v := p.at(x, y)
+			if n := len(runs); n > 0 && runs[n-1].pixel == v {
+				runs[n-1].n++
+				continue
+			}
+			runs = append(runs, rleRun{v, 1})
+		}
+	}
+
+	if len(palette) == 1 {
+		b = append(b, 1)
+		return p.pf.appendPixel(b, palette[0], size)
+	}
+
+	// Find the smallest subencoding, starting with raw pixel data.
+	subenc, best := uint8(0), t.Dx()*t.Dy()*size
+	plain := 0
+	for _, run := range runs {
+		plain += size + runLengthSize(run.n)
+	}
+	if plain < best {
+		subenc, best = 128, plain
+	}
+	if len(palette) <= 127 {
+		n := len(palette) * size
+		for _, run := range runs {
+			n++
+			if run.n > 1 {
+				n += runLengthSize(run.n)
+			}
+		}
+		if n < best {
+			subenc, best = 128+uint8(len(palette)), n
+		}
+	}
+	if len(palette) <= 16 {
+		if n := len(palette)*size + t.Dy()*((t.Dx()*packedBits(len(palette))+7)/8); n < best {
+			subenc, best = uint8(len(palette)), n
+		}
+	}
+
+	b = append(b, subenc)
+	switch {
+	case subenc == 0:
+		return p.appendPixels(b, t, size)
+	case subenc == 128:
+		for _, run := range runs {
+			b = p.pf.appendPixel(b, run.pixel, size)
+			b = appendRunLength(b, run.n)
+		}
+		return b
+	}
+
+	for _, c := range palette {
+		b = p.pf.appendPixel(b, c, size)
+	}
+	if subenc > 128 {
+		for _, run := range runs {
+			if run.n == 1 {
+				b = append(b, index[run.pixel])
+				continue
+			}
+			b = append(b, index[run.pixel]|0x80)
+			b = appendRunLength(b, run.n)
+		}
+		return b
+	}
+
+	bits := uint(packedBits(len(palette)))
+	for y := t.Min.Y; y < t.Max.Y; y++ {
+		var (
+			cur  uint8
+			used uint
+		)
+		for x := t.Min.X; x < t.Max.X; x++ {
+			cur |= index[p.at(x, y)] << (8 - bits - used)
+			if used += bits; used == 8 {
+				b = append(b, cur)
+				cur, used = 0, 0
+			}
+		}
+		if used > 0 {
+			b = append(b, cur)
+		}
+	}
+	return b
+}
+
+// packedBits returns the number of bits used for each palette index of a
+// packed palette tile.
+func packedBits(n int) int {
+	switch {
+	case n <= 2:
+		return 1
+	case n <= 4:
+		return 2
+	}
+	return 4
+}
+
+// runLengthSize returns the number of bytes used to send a run length.
+func runLengthSize(n int) int { return (n-1)/255 + 1 }
+
+// appendRunLength appends a run length, as read by readRunLength.
+func appendRunLength(b []byte, n int) []byte {
+	for n--; n >= 255; n -= 255 {
+		b = append(b, 255)
+	}
+	return append(b, uint8(n))
+}
+
+//-----------------------------------------------------------------------------
+// Tight Encoder
+//
+// Rectangles are sent as a fill color, with the palette filter when they
+// have few colors, as JPEG data when the client asked for a quality level,
+// or otherwise with the copy filter. Filtered data is compressed with one of
+// four zlib streams that last for the whole connection.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#tight-encoding
+
+// The zlib streams used for each filter.
+const (
+	tightStreamCopy = iota
+	tightStreamPalette
+)
+
+// tightJPEGQuality maps quality levels to JPEG qualities.
+var tightJPEGQuality = [10]int{15, 29, 41, 42, 62, 77, 79, 86, 92, 100}
+
+type tightEncoder struct {
+	streams [4]zlibWriter
+}
+
+// NewTightEncoder returns a ServerEncoder for Tight encoding.
+func NewTightEncoder() ServerEncoder { return &tightEncoder{} }
+
+// Type implements the ServerEncoder interface.
+func (*tightEncoder) Type() encodings.Encoding { return encodings.Tight }
+
+// split implements the rectSplitter interface.
+func (*tightEncoder) split(r image.Rectangle) []image.Rectangle {
+	w := r.Dx()
+	if w > tightMaxRectWidth {
+		w = tightMaxRectWidth
+	}
+	h := tightMaxRectSize / w
+	var rs []image.Rectangle
+	for y := r.Min.Y; y < r.Max.Y; y += h {
+		for x := r.Min.X; x < r.Max.X; x += w {
+			rs = append(rs, image.Rect(x, y, x+w, y+h).Intersect(r))
+		}
+	}
+	return rs
+}
+
+// Encode implements the ServerEncoder interface.
+func (e *tightEncoder) Encode(img image.Image, r image.Rectangle, opts *EncodeOptions) ([]byte, error) {
+	pf := &opts.PixelFormat
+	p := newPixelData(img, r, pf)
+	size := pf.tpixelSize()
+
+	// Reset the streams if the compression level changed, as it can only be
+	// set when a stream starts.
+	var ctl uint8
+	for i := range e.streams {
+		if s := &e.streams[i]; s.w != nil && s.level != opts.CompressLevel {
+			s.reset()
+			ctl |= 1 << uint(i)
+		}
+	}
+
+	palette := tileColors(p, r, 257)
+	switch {
+	case len(palette) == 1:
+		b := []byte{ctl | tightFill<<4}
+		return e.appendTPixel(b, pf, palette[0], size), nil
+	case len(palette) <= 256:
+		return e.encodePalette(ctl, p, palette, size, opts.CompressLevel)
+	case opts.QualityLevel >= 0 && pf.BPP >= 16 && rfbflags.IsTrueColor(pf.TrueColor):
+		return e.encodeJPEG(ctl, img, r, opts.QualityLevel)
+	}
+
+	data := make([]byte, 0, len(p.pix)*size)
+	for _, v := range p.pix {
+		data = e.appendTPixel(data, pf, v, size)
+	}
+	return e.appendData([]byte{ctl | tightStreamCopy<<4}, tightStreamCopy, data, opts.CompressLevel)
+}
+
+// encodePalette encodes the pixels as indices into the palette.
+func (e *tightEncoder) encodePalette(ctl uint8, p *pixelData, palette []uint32, size, level int) ([]byte, error) {
+	b := []byte{ctl | (tightExplicitFilter|tightStreamPalette)<<4, tightFilterPalette, uint8(len(palette) - 1)}
+	index := make(map[uint32]uint8, len(palette))
+	for i, c := range palette {
+		b = e.appendTPixel(b, p.pf, c, size)
+		index[c] = uint8(i)
+	}
+
+	w := p.rect.Dx()
+	var data []byte
+	if len(palette) == 2 {
+		data = make([]byte, 0, (w+7)/8*p.rect.Dy())
+		for y := p.rect.Min.Y; y < p.rect.Max.Y; y++ {
+			row := make([]byte, (w+7)/8)
+			for x := 0; x < w; x++ {
+				row[x/8] |= index[p.at(p.rect.Min.X+x, y)] << uint(7-x%8)
+			}
+			data = append(data, row...)
+		}
+	} else {
+		data = make([]byte, len(p.pix))
+		for i, v := range p.pix {
+			data[i] = index[v]
+		}
+	}
+	return e.appendData(b, tightStreamPalette, data, level)
+}
+
+// encodeJPEG encodes the area r of img as JPEG data.
+func (e *tightEncoder) encodeJPEG(ctl uint8, img image.Image, r image.Rectangle, level int) ([]byte, error) {
+	sub := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sub.Set(x-r.Min.X, y-r.Min.Y, img.At(x, y))
+		}
+	}
+	if level > 9 {
+		level = 9
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, sub, &jpeg.Options{Quality: tightJPEGQuality[level]}); err != nil {
+		return nil, fmt.Errorf("unable to encode tight jpeg data: %s", err)
+	}
+	b := appendCompactLength([]byte{ctl | tightJPEG<<4}, buf.Len())
+	return append(b, buf.Bytes()...), nil
+}
+
+// appendData appends filtered data, which is compressed with the stream
+// unless it is small.
+func (e *tightEncoder) appendData(b []byte, stream int, data []byte, level int) ([]byte, error) {
+	if len(data) < tightMinToCompress {
+		return append(b, data...), nil
+	}
+	chunk, err := e.streams[stream].compress(data, level)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compress tight data: %s", err)
+	}
+	b = appendCompactLength(b, len(chunk))
+	return append(b, chunk...), nil
+}
+
+// appendTPixel appends a pixel value as a TPIXEL of size bytes.
+func (e *tightEncoder) appendTPixel(b []byte, pf *PixelFormat, pixel uint32, size int) []byte {
+	if size == 3 {
+		return append(b, uint8(pixel>>pf.RedShift), uint8(pixel>>pf.GreenShift), uint8(pixel>>pf.BlueShift))
+	}
+	return pf.appendPixel(b, pixel, size)
+}
+
+// appendCompactLength appends a length, as read by readCompactLength.
+func appendCompactLength(b []byte, n int) []byte {
+	switch {
+	case n < 1<<7:
+		return append(b, uint8(n))
+	case n < 1<<14:
+		return append(b, uint8(n)|0x80, uint8(n>>7))
+	}
+	return append(b, uint8(n)|0x80, uint8(n>>7)|0x80, uint8(n>>14))
+}
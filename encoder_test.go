@@ -0,0 +1,196 @@
+package vnc
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/rfbflags"
+)
+
+// testImages returns images with one, two, a few and many colors.
+func testImages() map[string]*image.RGBA {
+	r := image.Rect(0, 0, 100, 70)
+	imgs := map[string]*image.RGBA{}
+	for _, name := range []string{"solid", "two colors", "few colors", "many colors"} {
+		imgs[name] = image.NewRGBA(r)
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			imgs["solid"].Set(x, y, color.RGBA{0x20, 0x40, 0x80, 0xff})
+			if (x/3+y/5)%2 == 0 {
+				imgs["two colors"].Set(x, y, color.White)
+			} else {
+				imgs["two colors"].Set(x, y, color.Black)
+			}
+			few := []color.RGBA{{0xff, 0, 0, 0xff}, {0, 0xff, 0, 0xff}, {0, 0, 0xff, 0xff}, {0xff, 0xff, 0, 0xff}, {0x80, 0x80, 0x80, 0xff}}
+			imgs["few colors"].Set(x, y, few[(x/7+y/2)%len(few)])
+			imgs["many colors"].Set(x, y, color.RGBA{uint8(x * 5), uint8(y * 9), uint8(x * y), 0xff})
+		}
+	}
+	return imgs
+}
+
+func TestServerEncoders(t *testing.T) {
+	pfs := map[string]PixelFormat{
+		"32bpp little-endian": {BPP: 32, Depth: 24, BigEndian: rfbflags.RFBFalse, TrueColor: rfbflags.RFBTrue,
+			RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0},
+		"32bpp big-endian BGR": {BPP: 32, Depth: 24, BigEndian: rfbflags.RFBTrue, TrueColor: rfbflags.RFBTrue,
+			RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 0, GreenShift: 8, BlueShift: 16},
+		"16bpp 565": {BPP: 16, Depth: 16, BigEndian: rfbflags.RFBTrue, TrueColor: rfbflags.RFBTrue,
+			RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5, BlueShift: 0},
+		"8bpp BGR233": {BPP: 8, Depth: 8, TrueColor: rfbflags.RFBTrue,
+			RedMax: 7, GreenMax: 7, BlueMax: 3, RedShift: 0, GreenShift: 3, BlueShift: 6},
+	}
+	decoders := map[encodings.Encoding]Encoding{
+		encodings.Raw:     &RawEncoding{},
+		encodings.Hextile: &HextileEncoding{},
+		encodings.ZRLE:    &ZRLEEncoding{},
+		encodings.Tight:   &TightEncoding{},
+	}
+	imgs := testImages()
+	areas := []image.Rectangle{image.Rect(3, 5, 90, 70), image.Rect(0, 0, 100, 70)}
+
+	for _, newEncoder := range DefaultServerEncoders {
+		for pfName, pf := range pfs {
+			// Each encoder is used for a series of rectangles, as zlib streams
+			// carry over between them.
+			enc := newEncoder()
+			mockConn := &MockConn{}
+			conn := NewClientConn(mockConn, &ClientConfig{})
+			conn.pixelFormat = pf
+			conn.fb = NewFramebuffer(100, 70)
+
+			for imgName, img := range imgs {
+				for _, area := range areas {
+					desc := fmt.Sprintf("%v, %s, %s, %v", enc.Type(), pfName, imgName, area)
+					opts := &EncodeOptions{PixelFormat: pf, CompressLevel: -1, QualityLevel: -1}
+
+					rects := []image.Rectangle{area}
+					if s, ok := enc.(rectSplitter); ok {
+						rects = s.split(area)
+					}
+					for _, r := range rects {
+						data, err := enc.Encode(img, r, opts)
+						if err != nil {
+							t.Fatalf("%s: unexpected error: %v", desc, err)
+						}
+						mockConn.Reset()
+						if err := conn.send(data); err != nil {
+							t.Fatal(err)
+						}
+						rect := &Rectangle{X: uint16(r.Min.X), Y: uint16(r.Min.Y), Width: uint16(r.Dx()), Height: uint16(r.Dy())}
+						if _, err := decoders[enc.Type()].Read(conn, rect); err != nil {
+							t.Fatalf("%s: unable to decode: %v", desc, err)
+						}
+						if n := mockConn.b.Len(); n != 0 {
+							t.Errorf("%s: %d bytes left over", desc, n)
+						}
+					}
+
+					// Compare against the colors after conversion to the
+					// pixel format.
+					fb := conn.Framebuffer()
+				compare:
+					for y := area.Min.Y; y < area.Max.Y; y++ {
+						for x := area.Min.X; x < area.Max.X; x++ {
+							c := img.RGBAAt(x, y)
+							want := pf.rgba(translatorFor(pf).pixel(c.R, c.G, c.B))
+							if got := fb.At(x, y); got != want {
+								t.Errorf("%s: incorrect color at (%d, %d); got = %v, want = %v", desc, x, y, got, want)
+								break compare
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestTightEncoder_JPEG(t *testing.T) {
+	pf := NewServerConfig(1, 1).PixelFormat
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = pf
+	conn.fb = NewFramebuffer(100, 70)
+
+	// A smooth gradient, which JPEG preserves well.
+	img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 8), uint8(y * 8), 0x80, 0xff})
+		}
+	}
+	r := img.Rect
+	data, err := NewTightEncoder().Encode(img, r, &EncodeOptions{PixelFormat: pf, CompressLevel: 9, QualityLevel: 9})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := data[0]>>4, uint8(tightJPEG); got != want {
+		t.Fatalf("incorrect compression-control; got = %#x, want = %#x", got, want)
+	}
+	if err := conn.send(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&TightEncoding{}).Read(conn, &Rectangle{Width: 32, Height: 32}); err != nil {
+		t.Fatalf("unable to decode: %v", err)
+	}
+
+	// JPEG is lossy, so only check that colors are close.
+	diff := func(a, b uint8) int {
+		if a > b {
+			return int(a - b)
+		}
+		return int(b - a)
+	}
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			got, want := conn.Framebuffer().At(x, y).(color.RGBA), img.RGBAAt(x, y)
+			if diff(got.R, want.R) > 32 || diff(got.G, want.G) > 32 || diff(got.B, want.B) > 32 {
+				t.Fatalf("incorrect color at (%d, %d); got = %v, want = %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestTightEncoder_Split(t *testing.T) {
+	e := NewTightEncoder().(*tightEncoder)
+	rs := e.split(image.Rect(0, 0, 3000, 100))
+	area := 0
+	for _, r := range rs {
+		if r.Dx() > tightMaxRectWidth || r.Dx()*r.Dy() > tightMaxRectSize {
+			t.Errorf("rectangle %v too large", r)
+		}
+		area += r.Dx() * r.Dy()
+	}
+	if got, want := area, 3000*100; got != want {
+		t.Errorf("incorrect area; got = %v, want = %v", got, want)
+	}
+}
+
+func TestServerConn_Encoder(t *testing.T) {
+	conn := NewServerConn(&MockConn{}, NewServerConfig(10, 10))
+	for _, tt := range []struct {
+		encs []encodings.Encoding
+		want encodings.Encoding
+	}{
+		{nil, encodings.Raw},
+		{[]encodings.Encoding{encodings.CopyRect, encodings.ZRLE, encodings.Hextile}, encodings.ZRLE},
+		{[]encodings.Encoding{encodings.Hextile, encodings.Tight}, encodings.Hextile},
+		{[]encodings.Encoding{encodings.RRE}, encodings.Raw},
+	} {
+		conn.setEncodings(tt.encs)
+		if got := conn.encoder().Type(); got != tt.want {
+			t.Errorf("%v: incorrect encoder; got = %v, want = %v", tt.encs, got, tt.want)
+		}
+	}
+
+	conn.setEncodings([]encodings.Encoding{encodings.Tight, encodings.CompressLevelPseudo + 3, encodings.QualityLevelPseudo + 7})
+	opts := conn.encodeOptions()
+	if opts.CompressLevel != 3 || opts.QualityLevel != 7 {
+		t.Errorf("incorrect levels; got = %v, %v, want = 3, 7", opts.CompressLevel, opts.QualityLevel)
+	}
+}
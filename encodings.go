@@ -6,9 +6,16 @@ package vnc
 
 import (
 	"bytes"
+	"compress/zlib"
+	"encoding/binary"
 	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
 
 	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/rfbflags"
 )
 
 //=============================================================================
@@ -46,6 +53,112 @@ func (e Encodings) Marshal() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// readColor reads a single pixel value in the connection's pixel format.
+func (c *ClientConn) readColor() (*Color, error) {
+	data := make([]byte, c.pixelFormat.BPP/8)
+	if err := c.receive(&data); err != nil {
+		return nil, err
+	}
+	color := NewColor(&c.pixelFormat, &c.colorMap)
+	if err := color.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return color, nil
+}
+
+// rgba converts a pixel value in the connection's pixel format to a color,
+// looking it up in the color map if the pixel format isn't true color.
+func (c *ClientConn) rgba(pixel uint32) color.RGBA {
+	if !rfbflags.IsTrueColor(c.pixelFormat.TrueColor) {
+		cm := &c.colorMap[uint8(pixel)]
+		return color.RGBA{uint8(cm.R >> 8), uint8(cm.G >> 8), uint8(cm.B >> 8), 0xff}
+	}
+	col := c.pixelFormat.rgba(pixel)
+	if c.alphaPixels {
+		col.A = uint8(pixel >> 24)
+	}
+	return col
+}
+
+// readPixel reads a single pixel of size bytes from r.
+func (c *ClientConn) readPixel(r io.Reader, size int) (color.RGBA, error) {
+	var data [4]byte
+	if _, err := io.ReadFull(r, data[:size]); err != nil {
+		return color.RGBA{}, err
+	}
+	return c.rgba(c.pixelFormat.pixel(data[:size])), nil
+}
+
+// readPixels reads the pixels covering rect from r into img, in row-major
+// order, with each pixel taking size bytes.
+func (c *ClientConn) readPixels(r io.Reader, img *image.RGBA, rect image.Rectangle, size int) error {
+	data := make([]byte, rect.Dx()*rect.Dy()*size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	i := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			img.SetRGBA(x, y, c.rgba(c.pixelFormat.pixel(data[i:i+size])))
+			i += size
+		}
+	}
+	return nil
+}
+
+// fillRGBA sets every pixel of r in img to c.
+func fillRGBA(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	draw.Draw(img, r, &image.Uniform{c}, image.Point{}, draw.Src)
+}
+
+// tiles splits r into tiles of at most size x size pixels, ordered
+// left-to-right, top-to-bottom.
+func tiles(r image.Rectangle, size int) []image.Rectangle {
+	var ts []image.Rectangle
+	for y := r.Min.Y; y < r.Max.Y; y += size {
+		for x := r.Min.X; x < r.Max.X; x += size {
+			ts = append(ts, image.Rect(x, y, x+size, y+size).Intersect(r))
+		}
+	}
+	return ts
+}
+
+// zlibStream inflates a zlib stream that the server sends in chunks across
+// many rectangles. The compression state is kept for the lifetime of the
+// connection, unless the stream is reset.
+type zlibStream struct {
+	buf bytes.Buffer  // compressed data not yet consumed
+	r   io.ReadCloser // inflater, created with the first chunk
+}
+
+// read receives n bytes of compressed data from the connection, and returns
+// a reader for the decompressed data. The length comes from the server, so
+// the data is copied as it arrives rather than into a buffer of that size.
+func (z *zlibStream) read(c *ClientConn, n int) (io.Reader, error) {
+	if _, err := io.CopyN(&z.buf, c.Conn, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	c.metrics["bytes-received"].Adjust(int64(n))
+
+	if z.r == nil {
+		r, err := zlib.NewReader(&z.buf)
+		if err != nil {
+			return nil, err
+		}
+		z.r = r
+	}
+	return z.r, nil
+}
+
+// reset discards the stream so that the next chunk starts a new one.
+func (z *zlibStream) reset() {
+	z.buf.Reset()
+	z.r = nil
+}
+
 //-----------------------------------------------------------------------------
 // Raw Encoding
 //
@@ -98,6 +211,7 @@ func (*RawEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
 			colors[int(y)*int(rect.Width)+int(x)] = *color
 		}
 	}
+	c.drawTarget().setColors(rect, colors)
 
 	return &RawEncoding{colors}, nil
 }
@@ -108,6 +222,616 @@ func (*RawEncoding) String() string { return "RawEncoding" }
 // Type implements the Encoding interface.
 func (*RawEncoding) Type() encodings.Encoding { return encodings.Raw }
 
+//-----------------------------------------------------------------------------
+// CopyRect Encoding
+//
+// CopyRect encoding instructs the client to copy a rectangle of pixel data
+// from elsewhere in its framebuffer. This is efficient for window moves and
+// scrolling.
+//
+// See RFC 6143 §7.7.2.
+// https://tools.ietf.org/html/rfc6143#section-7.7.2
+
+// CopyRectEncoding holds the source position of a copied rectangle.
+type CopyRectEncoding struct {
+	SX, SY uint16 // src-x-, src-y-position
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*CopyRectEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (e *CopyRectEncoding) Marshal() ([]byte, error) {
+	buf := NewBuffer(nil)
+	if err := buf.Write(e); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Read implements the Encoding interface.
+func (*CopyRectEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	var e CopyRectEncoding
+	if err := c.receive(&e); err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with copyrect encoding: %s", err)
+	}
+	c.drawTarget().copyRect(rect.bounds(), image.Pt(int(e.SX), int(e.SY)))
+
+	return &e, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*CopyRectEncoding) String() string { return "CopyRectEncoding" }
+
+// Type implements the Encoding interface.
+func (*CopyRectEncoding) Type() encodings.Encoding { return encodings.CopyRect }
+
+//-----------------------------------------------------------------------------
+// RRE Encoding
+//
+// RRE (rise-and-run-length) encoding describes a rectangle as a background
+// color, overlaid with solid colored subrectangles.
+//
+// See RFC 6143 §7.7.3.
+// https://tools.ietf.org/html/rfc6143#section-7.7.3
+
+// RRESubrect describes a solid colored subrectangle, positioned relative to
+// the enclosing rectangle.
+type RRESubrect struct {
+	Color         Color
+	X, Y          uint16 // x-, y-position
+	Width, Height uint16 // width, height
+}
+
+// bounds returns the area of the framebuffer covered by the subrectangle.
+func (s *RRESubrect) bounds(rect *Rectangle) image.Rectangle {
+	return image.Rect(0, 0, int(s.Width), int(s.Height)).Add(image.Pt(int(rect.X)+int(s.X), int(rect.Y)+int(s.Y)))
+}
+
+// RREEncoding holds RRE encoded rectangle data.
+type RREEncoding struct {
+	Background Color
+	Subrects   []RRESubrect
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*RREEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (e *RREEncoding) Marshal() ([]byte, error) {
+	return marshalRRE(e.Background, e.Subrects, false)
+}
+
+// Read implements the Encoding interface.
+func (*RREEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	bg, subrects, err := readRRE(c, rect, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with rre encoding: %s", err)
+	}
+	return &RREEncoding{bg, subrects}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*RREEncoding) String() string { return "RREEncoding" }
+
+// Type implements the Encoding interface.
+func (*RREEncoding) Type() encodings.Encoding { return encodings.RRE }
+
+//-----------------------------------------------------------------------------
+// CoRRE Encoding
+//
+// CoRRE (compact RRE) encoding is a variant of RRE where the subrectangle
+// positions and sizes are sent as a single byte, which limits the enclosing
+// rectangle to 255x255 pixels.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#corre-encoding
+
+// CoRREEncoding holds CoRRE encoded rectangle data.
+type CoRREEncoding struct {
+	Background Color
+	Subrects   []RRESubrect
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*CoRREEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (e *CoRREEncoding) Marshal() ([]byte, error) {
+	return marshalRRE(e.Background, e.Subrects, true)
+}
+
+// Read implements the Encoding interface.
+func (*CoRREEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	bg, subrects, err := readRRE(c, rect, true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with corre encoding: %s", err)
+	}
+	return &CoRREEncoding{bg, subrects}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*CoRREEncoding) String() string { return "CoRREEncoding" }
+
+// Type implements the Encoding interface.
+func (*CoRREEncoding) Type() encodings.Encoding { return encodings.CoRRE }
+
+// maxRRESubrectsAlloc is the most subrectangles readRRE allocates room for
+// before reading them.
+const maxRRESubrectsAlloc = 4096
+
+// readRRE reads RRE or, if compact is true, CoRRE encoded data from the
+// connection and draws it into the framebuffer.
+func readRRE(c *ClientConn, rect *Rectangle, compact bool) (Color, []RRESubrect, error) {
+	var numSubrects uint32
+	if err := c.receive(&numSubrects); err != nil {
+		return Color{}, nil, err
+	}
+	bg, err := c.readColor()
+	if err != nil {
+		return Color{}, nil, err
+	}
+
+	// Each subrectangle covers at least one pixel, so there cannot be more of
+	// them than pixels. The slice grows as they are read, rather than
+	// trusting the count for its size.
+	if uint64(numSubrects) > uint64(rect.Width)*uint64(rect.Height) {
+		return Color{}, nil, fmt.Errorf("%d subrectangles exceed the area of a %dx%d rectangle", numSubrects, rect.Width, rect.Height)
+	}
+	n := int(numSubrects)
+	if n > maxRRESubrectsAlloc {
+		n = maxRRESubrectsAlloc
+	}
+	subrects := make([]RRESubrect, 0, n)
+	for i := 0; i < int(numSubrects); i++ {
+		color, err := c.readColor()
+		if err != nil {
+			return Color{}, nil, err
+		}
+		subrects = append(subrects, RRESubrect{Color: *color})
+		s := &subrects[i]
+		if compact {
+			var pos [4]uint8 // x, y, width, height
+			if err := c.receive(&pos); err != nil {
+				return Color{}, nil, err
+			}
+			s.X, s.Y, s.Width, s.Height = uint16(pos[0]), uint16(pos[1]), uint16(pos[2]), uint16(pos[3])
+		} else {
+			var pos [4]uint16 // x, y, width, height
+			if err := c.receive(&pos); err != nil {
+				return Color{}, nil, err
+			}
+			s.X, s.Y, s.Width, s.Height = pos[0], pos[1], pos[2], pos[3]
+		}
+	}
+
+	c.drawTarget().fill(rect.bounds(), bg)
+	for i := range subrects {
+		c.drawTarget().fill(subrects[i].bounds(rect), &subrects[i].Color)
+	}
+
+	return *bg, subrects, nil
+}
+
+// marshalRRE returns the wire format of RRE or, if compact is true, CoRRE
+// encoded data.
+func marshalRRE(bg Color, subrects []RRESubrect, compact bool) ([]byte, error) {
+	buf := NewBuffer(nil)
+	if err := buf.Write(uint32(len(subrects))); err != nil {
+		return nil, err
+	}
+	bytes, err := bg.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if err := buf.Write(bytes); err != nil {
+		return nil, err
+	}
+
+	for _, s := range subrects {
+		bytes, err := s.Color.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		if err := buf.Write(bytes); err != nil {
+			return nil, err
+		}
+		var pos interface{} = [4]uint16{s.X, s.Y, s.Width, s.Height}
+		if compact {
+			if s.X > 255 || s.Y > 255 || s.Width > 255 || s.Height > 255 {
+				return nil, fmt.Errorf("subrectangle (%d, %d, %d, %d) too large for corre encoding", s.X, s.Y, s.Width, s.Height)
+			}
+			pos = [4]uint8{uint8(s.X), uint8(s.Y), uint8(s.Width), uint8(s.Height)}
+		}
+		if err := buf.Write(pos); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+//-----------------------------------------------------------------------------
+// Hextile Encoding
+//
+// Hextile encoding splits rectangles into 16x16 tiles, which are sent in
+// left-to-right, top-to-bottom order. Each tile is either raw pixel data, or a
+// background color overlaid with subrectangles. The background and foreground
+// colors carry over from the previous tile when not specified.
+//
+// See RFC 6143 §7.7.4.
+// https://tools.ietf.org/html/rfc6143#section-7.7.4
+
+// Hextile subencoding mask bits.
+const (
+	hextileRaw uint8 = 1 << iota
+	hextileBackgroundSpecified
+	hextileForegroundSpecified
+	hextileAnySubrects
+	hextileSubrectsColoured
+)
+
+const hextileTileSize = 16
+
+// HextileEncoding holds Hextile encoded rectangle data.
+type HextileEncoding struct {
+	// Image holds the decoded pixel data, with bounds matching the rectangle.
+	Image *image.RGBA
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*HextileEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*HextileEncoding) Marshal() ([]byte, error) {
+	return nil, fmt.Errorf("Marshal() unimplemented")
+}
+
+// Read implements the Encoding interface.
+func (*HextileEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	r := &connReader{c}
+	d := newHextileDecoder(c, rect)
+	for _, tile := range tiles(rect.bounds(), hextileTileSize) {
+		var subenc uint8
+		if err := binary.Read(r, binary.BigEndian, &subenc); err != nil {
+			return nil, fmt.Errorf("unable to read rectangle with hextile encoding: %s", err)
+		}
+		if err := d.decodeTile(r, tile, subenc); err != nil {
+			return nil, fmt.Errorf("unable to read rectangle with hextile encoding: %s", err)
+		}
+	}
+	c.drawTarget().draw(d.img)
+
+	return &HextileEncoding{d.img}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*HextileEncoding) String() string { return "HextileEncoding" }
+
+// Type implements the Encoding interface.
+func (*HextileEncoding) Type() encodings.Encoding { return encodings.Hextile }
+
+// hextileDecoder decodes the tiles of a single rectangle, keeping track of the
+// background and foreground colors between tiles.
+type hextileDecoder struct {
+	c      *ClientConn
+	img    *image.RGBA
+	bg, fg color.RGBA
+}
+
+func newHextileDecoder(c *ClientConn, rect *Rectangle) *hextileDecoder {
+	black := color.RGBA{0, 0, 0, 0xff}
+	return &hextileDecoder{c: c, img: image.NewRGBA(rect.bounds()), bg: black, fg: black}
+}
+
+// decodeTile reads the tile data that follows the subencoding mask from r.
+func (d *hextileDecoder) decodeTile(r io.Reader, tile image.Rectangle, subenc uint8) error {
+	size := d.c.pixelFormat.bytesPerPixel()
+	if subenc&hextileRaw != 0 {
+		return d.c.readPixels(r, d.img, tile, size)
+	}
+
+	var err error
+	if subenc&hextileBackgroundSpecified != 0 {
+		if d.bg, err = d.c.readPixel(r, size); err != nil {
+			return err
+		}
+	}
+	fillRGBA(d.img, tile, d.bg)
+	if subenc&hextileForegroundSpecified != 0 {
+		if d.fg, err = d.c.readPixel(r, size); err != nil {
+			return err
+		}
+	}
+	if subenc&hextileAnySubrects == 0 {
+		return nil
+	}
+
+	var numSubrects uint8
+	if err := binary.Read(r, binary.BigEndian, &numSubrects); err != nil {
+		return err
+	}
+	for i := 0; i < int(numSubrects); i++ {
+		fg := d.fg
+		if subenc&hextileSubrectsColoured != 0 {
+			if fg, err = d.c.readPixel(r, size); err != nil {
+				return err
+			}
+		}
+		var pos [2]uint8 // x-and-y-position, width-and-height
+		if _, err := io.ReadFull(r, pos[:]); err != nil {
+			return err
+		}
+		x, y := int(pos[0]>>4), int(pos[0]&0x0f)
+		w, h := int(pos[1]>>4)+1, int(pos[1]&0x0f)+1
+		fillRGBA(d.img, image.Rect(x, y, x+w, y+h).Add(tile.Min).Intersect(tile), fg)
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// TRLE Encoding
+//
+// TRLE (tiled run-length encoding) splits rectangles into 16x16 tiles, which
+// are encoded as raw, solid, packed palette or run-length data. Unlike ZRLE,
+// the data is not compressed, and a tile may reuse the palette of the previous
+// tile.
+//
+// See RFC 6143 §7.7.5.
+// https://tools.ietf.org/html/rfc6143#section-7.7.5
+
+const trleTileSize = 16
+
+// TRLEEncoding holds TRLE encoded rectangle data.
+type TRLEEncoding struct {
+	// Image holds the decoded pixel data, with bounds matching the rectangle.
+	Image *image.RGBA
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*TRLEEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*TRLEEncoding) Marshal() ([]byte, error) {
+	return nil, fmt.Errorf("Marshal() unimplemented")
+}
+
+// Read implements the Encoding interface.
+func (*TRLEEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	r := &connReader{c}
+	d := newRLEDecoder(c, rect)
+	d.reuse = true
+	for _, tile := range tiles(rect.bounds(), trleTileSize) {
+		if err := d.decodeTile(r, tile); err != nil {
+			return nil, fmt.Errorf("unable to read rectangle with trle encoding: %s", err)
+		}
+	}
+	c.drawTarget().draw(d.img)
+
+	return &TRLEEncoding{d.img}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*TRLEEncoding) String() string { return "TRLEEncoding" }
+
+// Type implements the Encoding interface.
+func (*TRLEEncoding) Type() encodings.Encoding { return encodings.TRLE }
+
+//-----------------------------------------------------------------------------
+// ZRLE Encoding
+//
+// ZRLE (zlib run-length encoding) splits rectangles into 64x64 tiles, which
+// are encoded as raw, solid, packed palette or run-length data, and then
+// compressed with a single zlib stream that lasts for the whole connection.
+//
+// See RFC 6143 §7.7.6.
+// https://tools.ietf.org/html/rfc6143#section-7.7.6
+
+const zrleTileSize = 64
+
+// ZRLEEncoding holds ZRLE encoded rectangle data.
+type ZRLEEncoding struct {
+	// Image holds the decoded pixel data, with bounds matching the rectangle.
+	Image *image.RGBA
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*ZRLEEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*ZRLEEncoding) Marshal() ([]byte, error) {
+	return nil, fmt.Errorf("Marshal() unimplemented")
+}
+
+// Read implements the Encoding interface.
+func (*ZRLEEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	var length uint32
+	if err := c.receive(&length); err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with zrle encoding: %s", err)
+	}
+	r, err := c.zrleStream.read(c, int(length))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with zrle encoding: %s", err)
+	}
+
+	d := newRLEDecoder(c, rect)
+	for _, tile := range tiles(rect.bounds(), zrleTileSize) {
+		if err := d.decodeTile(r, tile); err != nil {
+			return nil, fmt.Errorf("unable to read rectangle with zrle encoding: %s", err)
+		}
+	}
+	c.drawTarget().draw(d.img)
+
+	return &ZRLEEncoding{d.img}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*ZRLEEncoding) String() string { return "ZRLEEncoding" }
+
+// Type implements the Encoding interface.
+func (*ZRLEEncoding) Type() encodings.Encoding { return encodings.ZRLE }
+
+// rleDecoder decodes the run-length encoded tiles of a single rectangle, as
+// used by the TRLE and ZRLE encodings.
+type rleDecoder struct {
+	c    *ClientConn
+	img  *image.RGBA
+	size int // CPIXEL size in bytes
+
+	// Whether tiles may reuse the palette of the previous tile (TRLE only).
+	reuse   bool
+	palette []color.RGBA
+}
+
+func newRLEDecoder(c *ClientConn, rect *Rectangle) *rleDecoder {
+	return &rleDecoder{c: c, img: image.NewRGBA(rect.bounds()), size: c.pixelFormat.cpixelSize()}
+}
+
+// decodeTile reads a single tile from r.
+func (d *rleDecoder) decodeTile(r io.Reader, tile image.Rectangle) error {
+	var subenc uint8
+	if err := binary.Read(r, binary.BigEndian, &subenc); err != nil {
+		return err
+	}
+
+	if (subenc == 127 || subenc == 129) && d.reuse && d.palette == nil {
+		return fmt.Errorf("no palette to reuse for tile subencoding %d", subenc)
+	}
+
+	switch {
+	case subenc == 0: // Raw
+		return d.c.readPixels(r, d.img, tile, d.size)
+	case subenc == 1: // Solid
+		c, err := d.c.readPixel(r, d.size)
+		if err != nil {
+			return err
+		}
+		fillRGBA(d.img, tile, c)
+		d.palette = []color.RGBA{c}
+		return nil
+	case subenc <= 16: // Packed palette
+		if err := d.readPalette(r, int(subenc)); err != nil {
+			return err
+		}
+		return d.decodePacked(r, tile, d.palette)
+	case subenc == 127 && d.reuse: // Packed palette, reusing the palette
+		return d.decodePacked(r, tile, d.palette)
+	case subenc == 128: // Plain RLE
+		return d.decodeRuns(r, tile, nil)
+	case subenc == 129 && d.reuse: // Palette RLE, reusing the palette
+		return d.decodeRuns(r, tile, d.palette)
+	case subenc >= 130: // Palette RLE
+		if err := d.readPalette(r, int(subenc-128)); err != nil {
+			return err
+		}
+		return d.decodeRuns(r, tile, d.palette)
+	}
+	return fmt.Errorf("invalid tile subencoding %d", subenc)
+}
+
+// readPalette reads a palette of n CPIXELs, which replaces the current one.
+func (d *rleDecoder) readPalette(r io.Reader, n int) error {
+	palette := make([]color.RGBA, n)
+	for i := range palette {
+		c, err := d.c.readPixel(r, d.size)
+		if err != nil {
+			return err
+		}
+		palette[i] = c
+	}
+	d.palette = palette
+	return nil
+}
+
+// decodePacked reads packed palette indices, with each row padded to a
+// whole number of bytes.
+func (d *rleDecoder) decodePacked(r io.Reader, tile image.Rectangle, palette []color.RGBA) error {
+	bits := 4
+	switch {
+	case len(palette) <= 2:
+		bits = 1
+	case len(palette) <= 4:
+		bits = 2
+	}
+
+	row := make([]byte, (tile.Dx()*bits+7)/8)
+	for y := tile.Min.Y; y < tile.Max.Y; y++ {
+		if _, err := io.ReadFull(r, row); err != nil {
+			return err
+		}
+		for i := 0; i < tile.Dx(); i++ {
+			bit := i * bits
+			index := int(row[bit/8]>>uint(8-bits-bit%8)) & (1<<uint(bits) - 1)
+			if index >= len(palette) {
+				return fmt.Errorf("invalid palette index %d", index)
+			}
+			d.img.SetRGBA(tile.Min.X+i, y, palette[index])
+		}
+	}
+	return nil
+}
+
+// decodeRuns reads runs of pixels, which are CPIXELs for plain RLE or
+// palette indices if a palette is given.
+func (d *rleDecoder) decodeRuns(r io.Reader, tile image.Rectangle, palette []color.RGBA) error {
+	area := tile.Dx() * tile.Dy()
+	for i := 0; i < area; {
+		var (
+			c   color.RGBA
+			run = 1
+		)
+		if palette == nil {
+			var err error
+			if c, err = d.c.readPixel(r, d.size); err != nil {
+				return err
+			}
+			if run, err = readRunLength(r); err != nil {
+				return err
+			}
+		} else {
+			var index uint8
+			if err := binary.Read(r, binary.BigEndian, &index); err != nil {
+				return err
+			}
+			if index&0x80 != 0 {
+				var err error
+				if run, err = readRunLength(r); err != nil {
+					return err
+				}
+			}
+			if int(index&0x7f) >= len(palette) {
+				return fmt.Errorf("invalid palette index %d", index&0x7f)
+			}
+			c = palette[index&0x7f]
+		}
+
+		if i+run > area {
+			return fmt.Errorf("run of length %d exceeds tile", run)
+		}
+		for ; run > 0; run-- {
+			d.img.SetRGBA(tile.Min.X+i%tile.Dx(), tile.Min.Y+i/tile.Dx(), c)
+			i++
+		}
+	}
+	return nil
+}
+
+// readRunLength reads a run length, which is sent as a sequence of bytes that
+// are summed, with all but the last byte being 255, and then one added.
+func readRunLength(r io.Reader) (int, error) {
+	run := 1
+	for {
+		var b uint8
+		if err := binary.Read(r, binary.BigEndian, &b); err != nil {
+			return 0, err
+		}
+		run += int(b)
+		if b != 255 {
+			return run, nil
+		}
+	}
+}
+
 //=============================================================================
 // Pseudo-Encodings
 //
@@ -143,6 +867,7 @@ func (e *DesktopSizePseudoEncoding) Marshal() ([]byte, error) {
 func (*DesktopSizePseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
 	c.fbWidth = rect.Width
 	c.fbHeight = rect.Height
+	c.fb.resize(int(rect.Width), int(rect.Height))
 
 	return &DesktopSizePseudoEncoding{}, nil
 }
@@ -10,12 +10,36 @@ type Encoding int32
 //go:generate stringer -type=Encoding
 
 const (
-	Raw               Encoding = 0
-	CopyRect          Encoding = 1
-	RRE               Encoding = 2
-	Hextile           Encoding = 5
-	TRLE              Encoding = 15
-	ZRLE              Encoding = 16
-	ColorPseudo       Encoding = -239
-	DesktopSizePseudo Encoding = -223
+	Raw                        Encoding = 0
+	CopyRect                   Encoding = 1
+	RRE                        Encoding = 2
+	CoRRE                      Encoding = 4
+	Hextile                    Encoding = 5
+	Zlib                       Encoding = 6
+	Tight                      Encoding = 7
+	ZlibHex                    Encoding = 8
+	TRLE                       Encoding = 15
+	ZRLE                       Encoding = 16
+	VMwareLEDStatePseudo       Encoding = 0x574d5668
+	ExtendedClipboardPseudo    Encoding = -1063131698 // 0xC0A1E5CE
+	ExtendedMouseButtonsPseudo Encoding = -316
+	CursorWithAlphaPseudo      Encoding = -314
+	ContinuousUpdatesPseudo    Encoding = -313
+	FencePseudo                Encoding = -312
+	XVPPseudo                  Encoding = -309
+	ExtendedDesktopSizePseudo  Encoding = -308
+	DesktopNamePseudo          Encoding = -307
+	QEMULEDStatePseudo         Encoding = -261
+	QEMUAudioPseudo            Encoding = -259
+	QEMUExtendedKeyEventPseudo Encoding = -258
+	CompressLevelPseudo        Encoding = -256 // Levels 0-9 are -256 to -247.
+	XCursorPseudo              Encoding = -240
+	CursorPseudo               Encoding = -239
+	PointerPosPseudo           Encoding = -232
+	LastRectPseudo             Encoding = -224
+	DesktopSizePseudo          Encoding = -223
+	QualityLevelPseudo         Encoding = -32 // Levels 0-9 are -32 to -23.
+
+	// Deprecated: use CursorPseudo.
+	ColorPseudo = CursorPseudo
 )
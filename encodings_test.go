@@ -3,6 +3,11 @@ package vnc
 // TODO(kward): Fully test the encodings.
 
 import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/color"
+	"strings"
 	"testing"
 
 	"github.com/phox/go-vnc/encodings"
@@ -58,7 +63,520 @@ func TestRawEncoding_Marshal(t *testing.T) {
 	}
 }
 
-func TestRawEncoding_Read(t *testing.T) {}
+func TestRawEncoding_Read(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat{BPP: 32, Depth: 24, BigEndian: RFBTrue, TrueColor: RFBTrue,
+		RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0}
+	conn.fb = NewFramebuffer(4, 4)
+
+	// A 2x1 rectangle with a red and a blue pixel.
+	if err := conn.send([]byte{0, 255, 0, 0, 0, 0, 0, 255}); err != nil {
+		t.Fatal(err)
+	}
+	rect := &Rectangle{X: 1, Y: 2, Width: 2, Height: 1}
+	enc, err := (&RawEncoding{}).Read(conn, rect)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := len(enc.(*RawEncoding).Colors), 2; got != want {
+		t.Errorf("incorrect number of colors; got = %v, want = %v", got, want)
+	}
+	for _, tt := range []struct {
+		x, y int
+		c    color.RGBA
+	}{
+		{0, 0, color.RGBA{0, 0, 0, 255}},
+		{1, 2, color.RGBA{255, 0, 0, 255}},
+		{2, 2, color.RGBA{0, 0, 255, 255}},
+		{3, 2, color.RGBA{0, 0, 0, 255}},
+	} {
+		if got, want := conn.Framebuffer().At(tt.x, tt.y), tt.c; got != want {
+			t.Errorf("incorrect framebuffer pixel at (%d, %d); got = %v, want = %v", tt.x, tt.y, got, want)
+		}
+	}
+}
+
+func TestCopyRectEncoding_Type(t *testing.T) {
+	e := &CopyRectEncoding{}
+	if got, want := e.Type(), encodings.CopyRect; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+}
+
+func TestCopyRectEncoding_Read(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.fb = NewFramebuffer(4, 4)
+	conn.fb.fill(image.Rect(0, 0, 2, 2), color.RGBA{255, 0, 0, 255})
+
+	data, err := (&CopyRectEncoding{0, 0}).Marshal()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := data, []byte{0, 0, 0, 0}; !operators.EqualSlicesOfByte(got, want) {
+		t.Errorf("incorrect marshaled data; got = %v, want = %v", got, want)
+	}
+	if err := conn.send(data); err != nil {
+		t.Fatal(err)
+	}
+
+	rect := &Rectangle{X: 2, Y: 2, Width: 2, Height: 2}
+	enc, err := (&CopyRectEncoding{}).Read(conn, rect)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := *enc.(*CopyRectEncoding), (CopyRectEncoding{0, 0}); got != want {
+		t.Errorf("incorrect encoding; got = %v, want = %v", got, want)
+	}
+	if got, want := conn.Framebuffer().At(3, 3), (color.RGBA{255, 0, 0, 255}); got != want {
+		t.Errorf("incorrect framebuffer pixel; got = %v, want = %v", got, want)
+	}
+	if got, want := conn.Framebuffer().At(2, 1), (color.RGBA{0, 0, 0, 255}); got != want {
+		t.Errorf("incorrect framebuffer pixel; got = %v, want = %v", got, want)
+	}
+}
+
+func TestRREEncoding_Type(t *testing.T) {
+	e := &RREEncoding{}
+	if got, want := e.Type(), encodings.RRE; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+}
+
+func TestCoRREEncoding_Type(t *testing.T) {
+	e := &CoRREEncoding{}
+	if got, want := e.Type(), encodings.CoRRE; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+}
+
+func TestRREEncoding_Marshal(t *testing.T) {
+	pf := &PixelFormat16bit
+	for _, tt := range []struct {
+		desc string
+		e    Encoding
+		data []byte
+	}{
+		{"rre without subrects",
+			&RREEncoding{Color{pf, &ColorMap{}, 0, 127, 7, 0}, nil},
+			[]byte{0, 0, 0, 0, 0, 127}},
+		{"rre with subrect",
+			&RREEncoding{Color{pf, &ColorMap{}, 0, 127, 7, 0}, []RRESubrect{
+				{Color{pf, &ColorMap{}, 0, 0, 0, 0}, 1, 2, 3, 4}}},
+			[]byte{0, 0, 0, 1, 0, 127, 0, 0, 0, 1, 0, 2, 0, 3, 0, 4}},
+		{"corre with subrect",
+			&CoRREEncoding{Color{pf, &ColorMap{}, 0, 127, 7, 0}, []RRESubrect{
+				{Color{pf, &ColorMap{}, 0, 0, 0, 0}, 1, 2, 3, 4}}},
+			[]byte{0, 0, 0, 1, 0, 127, 0, 0, 1, 2, 3, 4}},
+	} {
+		data, err := tt.e.Marshal()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if got, want := data, tt.data; !operators.EqualSlicesOfByte(got, want) {
+			t.Errorf("%s: incorrect result; got = %v, want = %v", tt.desc, got, want)
+		}
+	}
+
+	e := &CoRREEncoding{Color{pf, &ColorMap{}, 0, 0, 0, 0}, []RRESubrect{
+		{Color{pf, &ColorMap{}, 0, 0, 0, 0}, 0, 0, 256, 1}}}
+	if _, err := e.Marshal(); err == nil {
+		t.Error("expected error for oversized corre subrectangle")
+	}
+}
+
+func TestRREEncoding_Read(t *testing.T) {
+	pf := PixelFormat{BPP: 32, Depth: 24, BigEndian: RFBTrue, TrueColor: RFBTrue,
+		RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0}
+	red := Color{&pf, &ColorMap{}, 0, 255, 0, 0}
+	blue := Color{&pf, &ColorMap{}, 0, 0, 0, 255}
+
+	for _, e := range []Encoding{
+		&RREEncoding{red, []RRESubrect{{blue, 1, 1, 2, 1}}},
+		&CoRREEncoding{red, []RRESubrect{{blue, 1, 1, 2, 1}}},
+	} {
+		mockConn := &MockConn{}
+		conn := NewClientConn(mockConn, &ClientConfig{})
+		conn.pixelFormat = pf
+		conn.fb = NewFramebuffer(8, 8)
+
+		data, err := e.Marshal()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", e, err)
+		}
+		if err := conn.send(data); err != nil {
+			t.Fatal(err)
+		}
+
+		rect := &Rectangle{X: 2, Y: 2, Width: 4, Height: 3}
+		got, err := e.Read(conn, rect)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", e, err)
+		}
+		gotData, err := got.Marshal()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", e, err)
+		}
+		if !operators.EqualSlicesOfByte(gotData, data) {
+			t.Errorf("%s: round trip mismatch; got = %v, want = %v", e, gotData, data)
+		}
+
+		for _, tt := range []struct {
+			x, y int
+			c    color.RGBA
+		}{
+			{1, 2, color.RGBA{0, 0, 0, 255}},
+			{2, 2, color.RGBA{255, 0, 0, 255}},
+			{3, 3, color.RGBA{0, 0, 255, 255}},
+			{4, 3, color.RGBA{0, 0, 255, 255}},
+			{5, 3, color.RGBA{255, 0, 0, 255}},
+			{5, 4, color.RGBA{255, 0, 0, 255}},
+			{5, 5, color.RGBA{0, 0, 0, 255}},
+		} {
+			if got, want := conn.Framebuffer().At(tt.x, tt.y), tt.c; got != want {
+				t.Errorf("%s: incorrect framebuffer pixel at (%d, %d); got = %v, want = %v", e, tt.x, tt.y, got, want)
+			}
+		}
+	}
+}
+
+func TestRREEncoding_ReadTooManySubrects(t *testing.T) {
+	for _, e := range []Encoding{&RREEncoding{}, &CoRREEncoding{}} {
+		mockConn := &MockConn{}
+		conn := NewClientConn(mockConn, &ClientConfig{})
+		conn.fb = NewFramebuffer(8, 8)
+
+		// A 2x2 rectangle cannot have more than four subrectangles.
+		if err := conn.send(uint32(5)); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.send([]byte{0, 0, 0, 0}); err != nil {
+			t.Fatal(err)
+		}
+		_, err := e.Read(conn, &Rectangle{Width: 2, Height: 2})
+		if err == nil || !strings.Contains(err.Error(), "exceed") {
+			t.Errorf("%s: expected error for too many subrectangles; got = %v", e, err)
+		}
+	}
+}
+
+func TestHextileEncoding_Type(t *testing.T) {
+	e := &HextileEncoding{}
+	if got, want := e.Type(), encodings.Hextile; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+}
+
+func TestHextileEncoding_Read(t *testing.T) {
+	var (
+		red   = []byte{0, 255, 0, 0}
+		green = []byte{0, 0, 255, 0}
+		blue  = []byte{0, 0, 0, 255}
+
+		cRed   = color.RGBA{255, 0, 0, 255}
+		cGreen = color.RGBA{0, 255, 0, 255}
+		cBlue  = color.RGBA{0, 0, 255, 255}
+	)
+	join := func(bs ...[]byte) []byte {
+		var data []byte
+		for _, b := range bs {
+			data = append(data, b...)
+		}
+		return data
+	}
+
+	for _, tt := range []struct {
+		desc   string
+		rect   Rectangle
+		data   []byte
+		pixels map[image.Point]color.RGBA
+	}{
+		{"background and foreground carried over",
+			Rectangle{X: 1, Y: 1, Width: 18, Height: 2},
+			join(
+				// Tile 1: background, foreground and a single subrect.
+				[]byte{hextileBackgroundSpecified | hextileForegroundSpecified | hextileAnySubrects},
+				red, blue, []byte{1, 0x10, 0x10},
+				// Tile 2: reuses the background.
+				[]byte{0}),
+			map[image.Point]color.RGBA{
+				{0, 0}: {0, 0, 0, 255}, {1, 1}: cRed, {2, 1}: cBlue, {3, 1}: cBlue, {4, 1}: cRed,
+				{2, 2}: cRed, {17, 2}: cRed, {18, 2}: cRed, {19, 2}: {0, 0, 0, 255},
+			}},
+		{"colored subrects and raw tile",
+			Rectangle{X: 0, Y: 0, Width: 17, Height: 1},
+			join(
+				// Tile 1: colored subrect over the default background.
+				[]byte{hextileAnySubrects | hextileSubrectsColoured, 1}, green, []byte{0x20, 0x10},
+				// Tile 2: raw.
+				[]byte{hextileRaw}, blue),
+			map[image.Point]color.RGBA{
+				{0, 0}: {0, 0, 0, 255}, {2, 0}: cGreen, {3, 0}: cGreen, {4, 0}: {0, 0, 0, 255}, {16, 0}: cBlue,
+			}},
+	} {
+		mockConn := &MockConn{}
+		conn := NewClientConn(mockConn, &ClientConfig{})
+		conn.pixelFormat = PixelFormat{BPP: 32, Depth: 24, BigEndian: RFBTrue, TrueColor: RFBTrue,
+			RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0}
+		conn.fb = NewFramebuffer(20, 4)
+		if err := conn.send(tt.data); err != nil {
+			t.Fatal(err)
+		}
+
+		enc, err := (&HextileEncoding{}).Read(conn, &tt.rect)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		if got, want := enc.(*HextileEncoding).Image.Bounds(), tt.rect.bounds(); got != want {
+			t.Errorf("%s: incorrect image bounds; got = %v, want = %v", tt.desc, got, want)
+		}
+		for p, c := range tt.pixels {
+			if got, want := conn.Framebuffer().At(p.X, p.Y), c; got != want {
+				t.Errorf("%s: incorrect framebuffer pixel at %v; got = %v, want = %v", tt.desc, p, got, want)
+			}
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%s: %d bytes left unread", tt.desc, mockConn.b.Len())
+		}
+	}
+}
+
+func TestTRLEEncoding_Type(t *testing.T) {
+	e := &TRLEEncoding{}
+	if got, want := e.Type(), encodings.TRLE; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+}
+
+func TestTRLEEncoding_Read(t *testing.T) {
+	var (
+		// CPIXELs for a big-endian RGB565 pixel format.
+		red   = []byte{0xf8, 0x00}
+		green = []byte{0x07, 0xe0}
+		blue  = []byte{0x00, 0x1f}
+
+		cRed   = color.RGBA{255, 0, 0, 255}
+		cGreen = color.RGBA{0, 255, 0, 255}
+		cBlue  = color.RGBA{0, 0, 255, 255}
+	)
+	join := func(bs ...[]byte) []byte {
+		var data []byte
+		for _, b := range bs {
+			data = append(data, b...)
+		}
+		return data
+	}
+
+	for _, tt := range []struct {
+		desc   string
+		rect   Rectangle
+		data   []byte
+		ok     bool
+		pixels map[image.Point]color.RGBA
+	}{
+		{"packed palette reuse",
+			Rectangle{X: 1, Y: 1, Width: 18, Height: 1},
+			join(
+				// Tile 1: packed palette of two colors, one bit per pixel.
+				[]byte{2}, red, blue, []byte{0x80, 0x01},
+				// Tile 2: packed palette, reusing the palette.
+				[]byte{127, 0x40}),
+			true,
+			map[image.Point]color.RGBA{
+				{1, 1}: cBlue, {2, 1}: cRed, {16, 1}: cBlue, {17, 1}: cRed, {18, 1}: cBlue, {19, 1}: {0, 0, 0, 255},
+			}},
+		{"palette rle",
+			Rectangle{X: 0, Y: 0, Width: 16, Height: 2},
+			join([]byte{131}, red, green, blue, []byte{0x80 | 2, 29, 0, 1}),
+			true,
+			map[image.Point]color.RGBA{
+				{0, 0}: cBlue, {15, 0}: cBlue, {13, 1}: cBlue, {14, 1}: cRed, {15, 1}: cGreen,
+			}},
+		{"solid and palette rle reuse",
+			Rectangle{X: 0, Y: 2, Width: 17, Height: 2},
+			join(
+				// Tile 1: solid.
+				[]byte{1}, green,
+				// Tile 2: palette RLE, reusing the solid color as the palette.
+				[]byte{129, 0x80, 1}),
+			true,
+			map[image.Point]color.RGBA{
+				{0, 2}: cGreen, {15, 3}: cGreen, {16, 2}: cGreen, {16, 3}: cGreen, {17, 2}: {0, 0, 0, 255},
+			}},
+		{"palette reuse without palette",
+			Rectangle{X: 0, Y: 0, Width: 4, Height: 4},
+			[]byte{129, 0},
+			false, nil},
+	} {
+		mockConn := &MockConn{}
+		conn := NewClientConn(mockConn, &ClientConfig{})
+		conn.pixelFormat = PixelFormat{BPP: 16, Depth: 16, BigEndian: RFBTrue, TrueColor: RFBTrue,
+			RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5, BlueShift: 0}
+		conn.fb = NewFramebuffer(20, 4)
+		if err := conn.send(tt.data); err != nil {
+			t.Fatal(err)
+		}
+
+		enc, err := (&TRLEEncoding{}).Read(conn, &tt.rect)
+		if err == nil && !tt.ok {
+			t.Errorf("%s: expected error", tt.desc)
+			continue
+		}
+		if err != nil {
+			if tt.ok {
+				t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			}
+			continue
+		}
+		if got, want := enc.(*TRLEEncoding).Image.Bounds(), tt.rect.bounds(); got != want {
+			t.Errorf("%s: incorrect image bounds; got = %v, want = %v", tt.desc, got, want)
+		}
+		for p, c := range tt.pixels {
+			if got, want := conn.Framebuffer().At(p.X, p.Y), c; got != want {
+				t.Errorf("%s: incorrect framebuffer pixel at %v; got = %v, want = %v", tt.desc, p, got, want)
+			}
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%s: %d bytes left unread", tt.desc, mockConn.b.Len())
+		}
+	}
+}
+
+func TestZRLEEncoding_Type(t *testing.T) {
+	e := &ZRLEEncoding{}
+	if got, want := e.Type(), encodings.ZRLE; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+}
+
+func TestZRLEEncoding_Read(t *testing.T) {
+	var (
+		// CPIXELs for a little-endian 32bpp depth 24 pixel format.
+		red   = []byte{0, 0, 255}
+		green = []byte{0, 255, 0}
+		blue  = []byte{255, 0, 0}
+
+		cRed   = color.RGBA{255, 0, 0, 255}
+		cGreen = color.RGBA{0, 255, 0, 255}
+		cBlue  = color.RGBA{0, 0, 255, 255}
+	)
+	join := func(bs ...[]byte) []byte {
+		var data []byte
+		for _, b := range bs {
+			data = append(data, b...)
+		}
+		return data
+	}
+
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat{BPP: 32, Depth: 24, BigEndian: RFBFalse, TrueColor: RFBTrue,
+		RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0}
+	conn.fb = NewFramebuffer(70, 4)
+
+	// All rectangles share a single zlib stream, which is flushed after each.
+	var zbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	for _, tt := range []struct {
+		desc   string
+		rect   Rectangle
+		data   []byte
+		pixels map[image.Point]color.RGBA
+	}{
+		{"packed palette and plain rle",
+			Rectangle{X: 0, Y: 0, Width: 66, Height: 1},
+			join(
+				// Tile 1: packed palette of two colors, one bit per pixel.
+				[]byte{2}, red, blue, []byte{0x80, 0, 0, 0, 0, 0, 0, 0x01},
+				// Tile 2: plain RLE, a single run of two pixels.
+				[]byte{128}, green, []byte{1}),
+			map[image.Point]color.RGBA{
+				{0, 0}: cBlue, {1, 0}: cRed, {62, 0}: cRed, {63, 0}: cBlue, {64, 0}: cGreen, {65, 0}: cGreen, {66, 0}: {0, 0, 0, 255},
+			}},
+		{"palette rle",
+			Rectangle{X: 0, Y: 1, Width: 3, Height: 2},
+			join([]byte{130}, red, green, []byte{0x80, 3, 1, 0}),
+			map[image.Point]color.RGBA{
+				{0, 1}: cRed, {2, 1}: cRed, {0, 2}: cRed, {1, 2}: cGreen, {2, 2}: cRed,
+			}},
+		{"raw and solid",
+			Rectangle{X: 4, Y: 1, Width: 65, Height: 1},
+			join([]byte{0}, bytes.Repeat(green, 64), []byte{1}, blue),
+			map[image.Point]color.RGBA{
+				{4, 1}: cGreen, {67, 1}: cGreen, {68, 1}: cBlue,
+			}},
+	} {
+		zbuf.Reset()
+		if _, err := zw.Write(tt.data); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.send(uint32(zbuf.Len())); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.send(zbuf.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+
+		enc, err := (&ZRLEEncoding{}).Read(conn, &tt.rect)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if got, want := enc.(*ZRLEEncoding).Image.Bounds(), tt.rect.bounds(); got != want {
+			t.Errorf("%s: incorrect image bounds; got = %v, want = %v", tt.desc, got, want)
+		}
+		for p, c := range tt.pixels {
+			if got, want := conn.Framebuffer().At(p.X, p.Y), c; got != want {
+				t.Errorf("%s: incorrect framebuffer pixel at %v; got = %v, want = %v", tt.desc, p, got, want)
+			}
+		}
+	}
+}
+
+func TestReadRunLength(t *testing.T) {
+	for _, tt := range []struct {
+		data []byte
+		run  int
+	}{
+		{[]byte{0}, 1},
+		{[]byte{254}, 255},
+		{[]byte{255, 0}, 256},
+		{[]byte{255, 255, 4}, 515},
+	} {
+		run, err := readRunLength(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", tt.data, err)
+			continue
+		}
+		if got, want := run, tt.run; got != want {
+			t.Errorf("%v: incorrect run length; got = %v, want = %v", tt.data, got, want)
+		}
+	}
+}
+
+func TestDesktopSizePseudoEncoding_Read(t *testing.T) {
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	conn.fb = NewFramebuffer(4, 4)
+
+	rect := &Rectangle{Width: 640, Height: 480}
+	if _, err := (&DesktopSizePseudoEncoding{}).Read(conn, rect); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := conn.FramebufferWidth(), uint16(640); got != want {
+		t.Errorf("incorrect width; got = %v, want = %v", got, want)
+	}
+	if got, want := conn.FramebufferHeight(), uint16(480); got != want {
+		t.Errorf("incorrect height; got = %v, want = %v", got, want)
+	}
+	if got, want := conn.Framebuffer().Bounds(), image.Rect(0, 0, 640, 480); got != want {
+		t.Errorf("incorrect framebuffer bounds; got = %v, want = %v", got, want)
+	}
+}
 
 func TestDesktopSizePseudoEncoding_Type(t *testing.T) {
 	e := &DesktopSizePseudoEncoding{}
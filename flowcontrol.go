@@ -0,0 +1,234 @@
+/*
+Implementation of the ContinuousUpdates and Fence extensions, which let a
+client receive framebuffer updates without requesting each one, and
+synchronize with the server.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#enablecontinuousupdates
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#fence
+*/
+package vnc
+
+import (
+	"fmt"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/messages"
+	"github.com/phox/go-vnc/rfbflags"
+)
+
+// ContinuousUpdatesSupported returns true once the server has indicated that
+// it supports continuous updates, by sending EndOfContinuousUpdates in reply
+// to the ContinuousUpdates pseudo-encoding.
+func (c *ClientConn) ContinuousUpdatesSupported() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.continuousUpdates
+}
+
+//-----------------------------------------------------------------------------
+// EnableContinuousUpdates asks the server to send framebuffer updates for an
+// area as soon as it changes, without waiting for FramebufferUpdateRequest
+// messages. It requires the server to support the ContinuousUpdates
+// pseudo-encoding.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#enablecontinuousupdates
+
+// EnableContinuousUpdatesMessage holds the wire format message.
+type EnableContinuousUpdatesMessage struct {
+	Msg           messages.ClientMessage // message-type
+	Enable        rfbflags.RFBFlag       // enable-flag
+	X, Y          uint16                 // x-, y-position
+	Width, Height uint16                 // width, height
+}
+
+// EnableContinuousUpdates enables or disables continuous updates of the given
+// area. When updates are disabled, the server replies with an
+// EndOfContinuousUpdates message.
+func (c *ClientConn) EnableContinuousUpdates(enable bool, x, y, w, h uint16) error {
+	msg := EnableContinuousUpdatesMessage{
+		messages.EnableContinuousUpdates, rfbflags.BoolToRFBFlag(enable), x, y, w, h,
+	}
+	return c.send(&msg)
+}
+
+// ContinuousUpdatesPseudoEncoding tells the server that the client supports
+// continuous updates.
+type ContinuousUpdatesPseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*ContinuousUpdatesPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*ContinuousUpdatesPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (*ContinuousUpdatesPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	return nil, fmt.Errorf("ContinuousUpdatesPseudoEncoding is never sent by the server")
+}
+
+// String implements the fmt.Stringer interface.
+func (*ContinuousUpdatesPseudoEncoding) String() string { return "ContinuousUpdatesPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*ContinuousUpdatesPseudoEncoding) Type() encodings.Encoding {
+	return encodings.ContinuousUpdatesPseudo
+}
+
+//-----------------------------------------------------------------------------
+// EndOfContinuousUpdates is sent by the server when continuous updates are
+// disabled, and once in reply to the ContinuousUpdates pseudo-encoding to
+// indicate that the server supports them.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#endofcontinuousupdates
+
+// EndOfContinuousUpdates represents the wire format message, sans
+// message-type.
+type EndOfContinuousUpdates struct{}
+
+// Verify that interfaces are honored.
+var _ ServerMessage = (*EndOfContinuousUpdates)(nil)
+
+// Type implements the ServerMessage interface.
+func (*EndOfContinuousUpdates) Type() messages.ServerMessage {
+	return messages.EndOfContinuousUpdates
+}
+
+// Read implements the ServerMessage interface.
+func (*EndOfContinuousUpdates) Read(c *ClientConn) (ServerMessage, error) {
+	c.mu.Lock()
+	c.continuousUpdates = true
+	c.mu.Unlock()
+	return &EndOfContinuousUpdates{}, nil
+}
+
+//-----------------------------------------------------------------------------
+// Fence messages are sent in both directions to synchronize the client and
+// server. A fence request must be answered with a fence response carrying
+// the same payload.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#fence
+
+// FenceFlags is a bitwise mask of fence flags.
+type FenceFlags uint32
+
+// Fence flags.
+const (
+	// FenceBlockBefore requires all messages sent before the fence to be
+	// processed before the fence is handled.
+	FenceBlockBefore FenceFlags = 1 << iota
+	// FenceBlockAfter requires the fence to be handled before any messages
+	// sent after it are processed.
+	FenceBlockAfter
+	// FenceSyncNext requires the message following the fence response to be
+	// processed at the same point as the response.
+	FenceSyncNext
+
+	// FenceRequest marks a fence that requires a response.
+	FenceRequest FenceFlags = 1 << 31
+
+	// fenceSupported holds the flags that are supported in fence responses.
+	fenceSupported = FenceBlockBefore | FenceBlockAfter | FenceSyncNext
+)
+
+// maxFencePayload is the maximum length of a fence payload.
+const maxFencePayload = 64
+
+// fenceMessage holds the wire format message, sans message-type and payload.
+type fenceMessage struct {
+	_      [3]byte    // padding
+	Flags  FenceFlags // flags
+	Length uint8      // length
+}
+
+// ClientFenceMessage holds the wire format message, sans the payload.
+type ClientFenceMessage struct {
+	Msg    messages.ClientMessage // message-type
+	_      [3]byte                // padding
+	Flags  FenceFlags             // flags
+	Length uint8                  // length
+}
+
+// Fence sends a fence message to the server. If flags includes FenceRequest,
+// the server replies with a ServerFence carrying the same payload. The
+// payload may be at most 64 bytes long.
+//
+// It requires the server to support the Fence pseudo-encoding.
+func (c *ClientConn) Fence(flags FenceFlags, payload []byte) error {
+	if len(payload) > maxFencePayload {
+		return fmt.Errorf("fence payload too long: %d bytes", len(payload))
+	}
+
+	buf := NewBuffer(nil)
+	msg := ClientFenceMessage{Msg: messages.ClientFence, Flags: flags, Length: uint8(len(payload))}
+	if err := buf.Write(msg); err != nil {
+		return err
+	}
+	if err := buf.Write(payload); err != nil {
+		return err
+	}
+	return c.send(buf.Bytes())
+}
+
+// FencePseudoEncoding tells the server that the client supports fences.
+type FencePseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*FencePseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*FencePseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (*FencePseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	return nil, fmt.Errorf("FencePseudoEncoding is never sent by the server")
+}
+
+// String implements the fmt.Stringer interface.
+func (*FencePseudoEncoding) String() string { return "FencePseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*FencePseudoEncoding) Type() encodings.Encoding { return encodings.FencePseudo }
+
+// ServerFence represents the wire format message, sans message-type and
+// padding.
+//
+// Fence requests from the server are answered automatically when the message
+// is read. Since messages are read and handled in order, and the response is
+// sent before reading any further messages, all flags are honored.
+type ServerFence struct {
+	Flags   FenceFlags
+	Payload []byte
+}
+
+// Verify that interfaces are honored.
+var _ ServerMessage = (*ServerFence)(nil)
+
+// Type implements the ServerMessage interface.
+func (*ServerFence) Type() messages.ServerMessage { return messages.ServerFence }
+
+// Read implements the ServerMessage interface.
+func (*ServerFence) Read(c *ClientConn) (ServerMessage, error) {
+	var msg fenceMessage
+	if err := c.receive(&msg); err != nil {
+		return nil, err
+	}
+	if msg.Length > maxFencePayload {
+		return nil, fmt.Errorf("fence payload too long: %d bytes", msg.Length)
+	}
+	payload := make([]byte, msg.Length)
+	if err := c.receive(&payload); err != nil {
+		return nil, err
+	}
+
+	c.fenceReceived(msg.Flags, payload)
+	if msg.Flags&FenceRequest != 0 {
+		if err := c.Fence(msg.Flags&fenceSupported, payload); err != nil {
+			return nil, fmt.Errorf("unable to send fence response: %s", err)
+		}
+	}
+
+	return &ServerFence{msg.Flags, payload}, nil
+}
@@ -0,0 +1,147 @@
+package vnc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/messages"
+	"github.com/phox/go-vnc/rfbflags"
+)
+
+func TestEnableContinuousUpdates(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	for _, tt := range []struct {
+		enable     bool
+		x, y, w, h uint16
+	}{
+		{true, 0, 0, 1024, 768},
+		{false, 10, 20, 30, 40},
+	} {
+		mockConn.Reset()
+		if err := conn.EnableContinuousUpdates(tt.enable, tt.x, tt.y, tt.w, tt.h); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var msg EnableContinuousUpdatesMessage
+		if err := conn.receive(&msg); err != nil {
+			t.Fatal(err)
+		}
+		want := EnableContinuousUpdatesMessage{
+			messages.EnableContinuousUpdates, rfbflags.BoolToRFBFlag(tt.enable), tt.x, tt.y, tt.w, tt.h,
+		}
+		if msg != want {
+			t.Errorf("incorrect message; got = %v, want = %v", msg, want)
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%d bytes left unread", mockConn.b.Len())
+		}
+	}
+}
+
+func TestEndOfContinuousUpdates(t *testing.T) {
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	if conn.ContinuousUpdatesSupported() {
+		t.Error("continuous updates supported before EndOfContinuousUpdates")
+	}
+	if _, err := (&EndOfContinuousUpdates{}).Read(conn); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !conn.ContinuousUpdatesSupported() {
+		t.Error("continuous updates not supported after EndOfContinuousUpdates")
+	}
+}
+
+func TestFlowControlPseudoEncoding_Type(t *testing.T) {
+	for _, tt := range []struct {
+		enc  Encoding
+		want encodings.Encoding
+	}{
+		{&ContinuousUpdatesPseudoEncoding{}, encodings.ContinuousUpdatesPseudo},
+		{&FencePseudoEncoding{}, encodings.FencePseudo},
+	} {
+		if got := tt.enc.Type(); got != tt.want {
+			t.Errorf("incorrect encoding; got = %s, want = %s", got, tt.want)
+		}
+	}
+}
+
+func TestFence(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	if err := conn.Fence(FenceRequest|FenceBlockBefore, []byte("sync")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var msg ClientFenceMessage
+	if err := conn.receive(&msg); err != nil {
+		t.Fatal(err)
+	}
+	want := ClientFenceMessage{Msg: messages.ClientFence, Flags: FenceRequest | FenceBlockBefore, Length: 4}
+	if msg != want {
+		t.Errorf("incorrect message; got = %v, want = %v", msg, want)
+	}
+	if got, want := mockConn.b.String(), "sync"; got != want {
+		t.Errorf("incorrect payload; got = %q, want = %q", got, want)
+	}
+
+	if err := conn.Fence(0, make([]byte, maxFencePayload+1)); err == nil {
+		t.Error("expected error for long payload")
+	}
+}
+
+func TestServerFence(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	for _, tt := range []struct {
+		desc  string
+		flags FenceFlags
+		reply bool
+	}{
+		{"request", FenceRequest | FenceBlockAfter | FenceSyncNext | 1<<8, true},
+		{"response", FenceBlockBefore, false},
+	} {
+		mockConn.Reset()
+		payload := []byte{1, 2, 3}
+		if err := conn.send(fenceMessage{Flags: tt.flags, Length: uint8(len(payload))}); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.send(payload); err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := (&ServerFence{}).Read(conn)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.desc, err)
+		}
+		fence := msg.(*ServerFence)
+		if got, want := fence.Flags, tt.flags; got != want {
+			t.Errorf("%s: incorrect flags; got = %v, want = %v", tt.desc, got, want)
+		}
+		if !bytes.Equal(fence.Payload, payload) {
+			t.Errorf("%s: incorrect payload; got = %v, want = %v", tt.desc, fence.Payload, payload)
+		}
+
+		// Requests are answered with the supported flags and the same payload.
+		if !tt.reply {
+			if mockConn.b.Len() != 0 {
+				t.Errorf("%s: unexpected reply of %d bytes", tt.desc, mockConn.b.Len())
+			}
+			continue
+		}
+		var reply ClientFenceMessage
+		if err := conn.receive(&reply); err != nil {
+			t.Fatal(err)
+		}
+		want := ClientFenceMessage{Msg: messages.ClientFence, Flags: FenceBlockAfter | FenceSyncNext, Length: 3}
+		if reply != want {
+			t.Errorf("%s: incorrect reply; got = %v, want = %v", tt.desc, reply, want)
+		}
+		if got := mockConn.b.Bytes(); !bytes.Equal(got, payload) {
+			t.Errorf("%s: incorrect reply payload; got = %v, want = %v", tt.desc, got, payload)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Client-side framebuffer that FramebufferUpdate rectangles are applied to.
+
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+)
+
+// Framebuffer holds the client's copy of the remote framebuffer. Decoded
+// rectangles are drawn into it as FramebufferUpdate messages are read, and it
+// is resized when the server changes the desktop size.
+//
+// A Framebuffer is safe for concurrent use, so it may be read from other
+// goroutines while ListenAndHandle is running.
+type Framebuffer struct {
+	mu  sync.RWMutex
+	img *image.RGBA
+}
+
+// Verify that interfaces are honored.
+var _ image.Image = (*Framebuffer)(nil)
+
+// NewFramebuffer returns a black Framebuffer of the given size.
+func NewFramebuffer(width, height int) *Framebuffer {
+	return &Framebuffer{img: newBlackRGBA(width, height)}
+}
+
+// newBlackRGBA returns an opaque black image of the given size.
+func newBlackRGBA(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Rect, image.NewUniform(color.Black), image.Point{}, draw.Src)
+	return img
+}
+
+// At implements the image.Image interface.
+func (fb *Framebuffer) At(x, y int) color.Color {
+	fb.mu.RLock()
+	defer fb.mu.RUnlock()
+	return fb.img.RGBAAt(x, y)
+}
+
+// Bounds implements the image.Image interface.
+func (fb *Framebuffer) Bounds() image.Rectangle {
+	fb.mu.RLock()
+	defer fb.mu.RUnlock()
+	return fb.img.Rect
+}
+
+// ColorModel implements the image.Image interface.
+func (fb *Framebuffer) ColorModel() color.Model { return color.RGBAModel }
+
+// Snapshot returns a copy of the current framebuffer contents. The returned
+// image is not modified by later updates.
+func (fb *Framebuffer) Snapshot() *image.RGBA {
+	fb.mu.RLock()
+	defer fb.mu.RUnlock()
+	img := image.NewRGBA(fb.img.Rect)
+	copy(img.Pix, fb.img.Pix)
+	return img
+}
+
+// resize discards the framebuffer contents and sets a new size, leaving it
+// black.
+func (fb *Framebuffer) resize(width, height int) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	fb.img = newBlackRGBA(width, height)
+}
+
+// draw copies src into the framebuffer, with src.Bounds() giving the
+// destination. Anything outside of the framebuffer is clipped.
+func (fb *Framebuffer) draw(src image.Image) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	draw.Draw(fb.img, src.Bounds(), src, src.Bounds().Min, draw.Src)
+}
+
+// copyRect copies the area of size r.Size() at sp to r. The source and
+// destination may overlap.
+func (fb *Framebuffer) copyRect(r image.Rectangle, sp image.Point) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	// Clip both the destination and source to the framebuffer.
+	b := fb.img.Rect
+	delta := sp.Sub(r.Min)
+	sr := r.Intersect(b).Add(delta).Intersect(b)
+	r = sr.Sub(delta)
+	if r.Empty() {
+		return
+	}
+
+	n := r.Dx() * 4
+	rowCopy := func(y int) {
+		d := fb.img.PixOffset(r.Min.X, r.Min.Y+y)
+		s := fb.img.PixOffset(sr.Min.X, sr.Min.Y+y)
+		copy(fb.img.Pix[d:d+n], fb.img.Pix[s:s+n])
+	}
+	// Copy rows bottom up when moving down so that overlapping source rows
+	// are read before they are overwritten.
+	if sr.Min.Y < r.Min.Y {
+		for y := r.Dy() - 1; y >= 0; y-- {
+			rowCopy(y)
+		}
+		return
+	}
+	for y := 0; y < r.Dy(); y++ {
+		rowCopy(y)
+	}
+}
+
+// fill sets every pixel of r to c.
+func (fb *Framebuffer) fill(r image.Rectangle, c color.Color) {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+	draw.Draw(fb.img, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+// setColors draws a rectangle of row-major colors, as sent with the Raw
+// encoding, into the framebuffer.
+func (fb *Framebuffer) setColors(rect *Rectangle, colors []Color) {
+	img := image.NewRGBA(rect.bounds())
+	for i := range colors {
+		r, g, b, _ := colors[i].RGBA()
+		img.Pix[4*i+0] = uint8(r >> 8)
+		img.Pix[4*i+1] = uint8(g >> 8)
+		img.Pix[4*i+2] = uint8(b >> 8)
+		img.Pix[4*i+3] = 0xff
+	}
+	fb.draw(img)
+}
@@ -0,0 +1,123 @@
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"sync"
+	"testing"
+)
+
+func TestNewFramebuffer(t *testing.T) {
+	fb := NewFramebuffer(2, 2)
+	if got, want := fb.At(1, 1), (color.RGBA{0, 0, 0, 255}); got != want {
+		t.Errorf("incorrect pixel; got = %v, want = %v", got, want)
+	}
+}
+
+func TestFramebuffer_Resize(t *testing.T) {
+	fb := NewFramebuffer(2, 2)
+	fb.fill(fb.Bounds(), color.RGBA{1, 2, 3, 255})
+
+	fb.resize(3, 1)
+	if got, want := fb.Bounds(), image.Rect(0, 0, 3, 1); got != want {
+		t.Errorf("incorrect bounds; got = %v, want = %v", got, want)
+	}
+	if got, want := fb.At(0, 0), (color.RGBA{0, 0, 0, 255}); got != want {
+		t.Errorf("contents not discarded; got = %v, want = %v", got, want)
+	}
+}
+
+func TestFramebuffer_Snapshot(t *testing.T) {
+	fb := NewFramebuffer(2, 2)
+	fb.fill(image.Rect(0, 0, 1, 1), color.RGBA{10, 20, 30, 255})
+
+	img := fb.Snapshot()
+	fb.fill(fb.Bounds(), color.RGBA{255, 255, 255, 255})
+
+	if got, want := img.RGBAAt(0, 0), (color.RGBA{10, 20, 30, 255}); got != want {
+		t.Errorf("incorrect pixel; got = %v, want = %v", got, want)
+	}
+	if got, want := img.RGBAAt(1, 1), (color.RGBA{0, 0, 0, 255}); got != want {
+		t.Errorf("snapshot modified by update; got = %v, want = %v", got, want)
+	}
+}
+
+func TestFramebuffer_Draw(t *testing.T) {
+	fb := NewFramebuffer(4, 4)
+
+	// The source extends past the framebuffer, and must be clipped.
+	src := image.NewRGBA(image.Rect(3, 3, 5, 5))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+	fb.draw(src)
+
+	if got, want := fb.At(3, 3), (color.RGBA{255, 255, 255, 255}); got != want {
+		t.Errorf("incorrect pixel; got = %v, want = %v", got, want)
+	}
+	if got, want := fb.At(2, 2), (color.RGBA{0, 0, 0, 255}); got != want {
+		t.Errorf("incorrect pixel; got = %v, want = %v", got, want)
+	}
+}
+
+func TestFramebuffer_Concurrent(t *testing.T) {
+	fb := NewFramebuffer(16, 16)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			fb.fill(image.Rect(0, 0, 8, 8), color.RGBA{uint8(i), 0, 0, 255})
+			fb.resize(16, 16)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			img := fb.Snapshot()
+			if got, want := img.Bounds(), image.Rect(0, 0, 16, 16); got != want {
+				t.Errorf("incorrect bounds; got = %v, want = %v", got, want)
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+func TestFramebuffer_CopyRect(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		r    image.Rectangle
+		sp   image.Point
+	}{
+		{"disjoint", image.Rect(5, 5, 8, 8), image.Pt(0, 0)},
+		{"overlap down", image.Rect(1, 2, 5, 6), image.Pt(1, 1)},
+		{"overlap up", image.Rect(1, 0, 5, 4), image.Pt(1, 1)},
+		{"overlap right", image.Rect(2, 1, 6, 5), image.Pt(1, 1)},
+		{"overlap left", image.Rect(0, 1, 4, 5), image.Pt(1, 1)},
+		{"overlap diagonal", image.Rect(2, 2, 6, 6), image.Pt(1, 1)},
+		{"clipped", image.Rect(6, 6, 10, 10), image.Pt(1, 1)},
+	} {
+		fb := NewFramebuffer(8, 8)
+		for i := range fb.img.Pix {
+			fb.img.Pix[i] = uint8(i)
+		}
+		want := fb.Snapshot()
+		orig := fb.Snapshot()
+		for y := tt.r.Min.Y; y < tt.r.Max.Y; y++ {
+			for x := tt.r.Min.X; x < tt.r.Max.X; x++ {
+				if image.Pt(x, y).In(want.Rect) {
+					want.SetRGBA(x, y, orig.RGBAAt(tt.sp.X+x-tt.r.Min.X, tt.sp.Y+y-tt.r.Min.Y))
+				}
+			}
+		}
+
+		fb.copyRect(tt.r, tt.sp)
+		for i := range want.Pix {
+			if got, want := fb.img.Pix[i], want.Pix[i]; got != want {
+				t.Errorf("%s: incorrect framebuffer contents at offset %d; got = %v, want = %v", tt.desc, i, got, want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,309 @@
+// Sources of the framebuffer contents served to clients.
+
+package vnc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+	"time"
+)
+
+// FrameSource provides the framebuffer contents served to clients, and
+// tracks which areas of it have changed.
+//
+// Every change to the contents increases the generation of the source, so
+// that a server can send only the areas that changed since the generation it
+// last sent to a client.
+type FrameSource interface {
+	// Frame returns the current contents and their generation. The image
+	// must not be modified after it is returned.
+	Frame() (image.Image, uint64)
+
+	// Dirty returns the areas that changed after the given generation. If
+	// they are no longer known, it returns false, and the whole image should
+	// be treated as changed.
+	Dirty(since uint64) ([]image.Rectangle, bool)
+}
+
+// maxDirtyEntries is the number of changes a dirtyLog remembers.
+const maxDirtyEntries = 256
+
+// dirtyEntry records an area changed at a generation.
+type dirtyEntry struct {
+	gen  uint64
+	rect image.Rectangle
+}
+
+// dirtyLog records the areas changed at each generation of a FrameSource.
+type dirtyLog struct {
+	gen     uint64
+	floor   uint64 // Changes after floor are all in entries.
+	entries []dirtyEntry
+}
+
+// add records the areas changed by a new generation, and returns it.
+func (l *dirtyLog) add(rects ...image.Rectangle) uint64 {
+	l.gen++
+	for _, r := range rects {
+		l.entries = append(l.entries, dirtyEntry{l.gen, r})
+	}
+	if n := len(l.entries) - maxDirtyEntries; n > 0 {
+		l.floor = l.entries[n-1].gen
+		l.entries = append(l.entries[:0], l.entries[n:]...)
+	}
+	return l.gen
+}
+
+// since returns the areas changed after the given generation.
+func (l *dirtyLog) since(gen uint64) ([]image.Rectangle, bool) {
+	if gen < l.floor {
+		return nil, false
+	}
+	var rects []image.Rectangle
+	for _, e := range l.entries {
+		if e.gen > gen {
+			rects = append(rects, e.rect)
+		}
+	}
+	return rects, true
+}
+
+// addDirty returns the changed areas with rects added, clipped to bounds.
+func addDirty(areas []image.Rectangle, bounds image.Rectangle, rects []image.Rectangle) []image.Rectangle {
+	for _, r := range rects {
+		if r = r.Intersect(bounds); !r.Empty() {
+			areas = append(areas, r)
+		}
+	}
+	return limitDirty(areas)
+}
+
+// subtractDirty returns the parts of the changed areas outside of r.
+func subtractDirty(areas []image.Rectangle, r image.Rectangle) []image.Rectangle {
+	var rest []image.Rectangle
+	for _, a := range areas {
+		in := a.Intersect(r)
+		if in.Empty() {
+			rest = append(rest, a)
+			continue
+		}
+		// The parts above and below r, and those to its left and right.
+		if a.Min.Y < in.Min.Y {
+			rest = append(rest, image.Rect(a.Min.X, a.Min.Y, a.Max.X, in.Min.Y))
+		}
+		if in.Max.Y < a.Max.Y {
+			rest = append(rest, image.Rect(a.Min.X, in.Max.Y, a.Max.X, a.Max.Y))
+		}
+		if a.Min.X < in.Min.X {
+			rest = append(rest, image.Rect(a.Min.X, in.Min.Y, in.Min.X, in.Max.Y))
+		}
+		if in.Max.X < a.Max.X {
+			rest = append(rest, image.Rect(in.Max.X, in.Min.Y, a.Max.X, in.Max.Y))
+		}
+	}
+	return limitDirty(rest)
+}
+
+// limitDirty merges the changed areas into one that covers all of them, if
+// there are more than maxDirtyEntries.
+func limitDirty(areas []image.Rectangle) []image.Rectangle {
+	if len(areas) <= maxDirtyEntries {
+		return areas
+	}
+	var u image.Rectangle
+	for _, a := range areas {
+		u = u.Union(a)
+	}
+	return []image.Rectangle{u}
+}
+
+//-----------------------------------------------------------------------------
+// StaticSource serves an image that never changes.
+
+// StaticSource is a FrameSource for an image that never changes.
+type StaticSource struct {
+	img image.Image
+}
+
+// Verify that interfaces are honored.
+var _ FrameSource = (*StaticSource)(nil)
+
+// NewStaticSource returns a StaticSource for the image, which must not be
+// modified afterwards.
+func NewStaticSource(img image.Image) *StaticSource {
+	return &StaticSource{img}
+}
+
+// Frame implements the FrameSource interface.
+func (s *StaticSource) Frame() (image.Image, uint64) { return s.img, 0 }
+
+// Dirty implements the FrameSource interface.
+func (s *StaticSource) Dirty(since uint64) ([]image.Rectangle, bool) { return nil, true }
+
+//-----------------------------------------------------------------------------
+// Canvas is drawn into by the program, which marks the areas it changes.
+
+// Canvas is a FrameSource for an in-memory image that the program draws
+// into. It is safe for concurrent use, so it may be drawn into while it is
+// being served.
+type Canvas struct {
+	mu   sync.Mutex
+	img  *image.RGBA
+	log  dirtyLog
+	snap *image.RGBA // A copy of img at the current generation, if any.
+}
+
+// Verify that interfaces are honored.
+var _ FrameSource = (*Canvas)(nil)
+
+// NewCanvas returns a black Canvas of the given size.
+func NewCanvas(width, height int) *Canvas {
+	return &Canvas{img: newBlackRGBA(width, height)}
+}
+
+// Bounds returns the bounds of the canvas.
+func (cv *Canvas) Bounds() image.Rectangle {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	return cv.img.Rect
+}
+
+// Update calls fn to draw into the area r of the canvas, and marks r as
+// changed. fn must not draw outside of r, or retain the image.
+func (cv *Canvas) Update(r image.Rectangle, fn func(img *image.RGBA)) {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	fn(cv.img)
+	if r = r.Intersect(cv.img.Rect); !r.Empty() {
+		cv.log.add(r)
+		cv.snap = nil
+	}
+}
+
+// Draw draws src into the area r of the canvas, as draw.Draw does.
+func (cv *Canvas) Draw(r image.Rectangle, src image.Image, sp image.Point, op draw.Op) {
+	cv.Update(r, func(img *image.RGBA) {
+		draw.Draw(img, r, src, sp, op)
+	})
+}
+
+// Fill sets every pixel of the area r of the canvas to c.
+func (cv *Canvas) Fill(r image.Rectangle, c color.Color) {
+	cv.Draw(r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+// Frame implements the FrameSource interface. The image is a copy of the
+// canvas, which is shared until the canvas changes.
+func (cv *Canvas) Frame() (image.Image, uint64) {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	if cv.snap == nil {
+		cv.snap = image.NewRGBA(cv.img.Rect)
+		copy(cv.snap.Pix, cv.img.Pix)
+	}
+	return cv.snap, cv.log.gen
+}
+
+// Dirty implements the FrameSource interface.
+func (cv *Canvas) Dirty(since uint64) ([]image.Rectangle, bool) {
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	return cv.log.since(since)
+}
+
+//-----------------------------------------------------------------------------
+// PollingSource captures frames from elsewhere, and finds the areas that
+// changed by comparing successive frames.
+
+// pollTileSize is the size of the tiles that PollingSource compares.
+const pollTileSize = 32
+
+// PollingSource is a FrameSource for images captured by a function, such as
+// screenshots of a display. Each capture is compared with the previous one
+// tile by tile, to find the areas that changed.
+type PollingSource struct {
+	capture  func() image.Image
+	interval time.Duration
+
+	mu       sync.Mutex
+	img      *image.RGBA
+	log      dirtyLog
+	captured time.Time
+}
+
+// Verify that interfaces are honored.
+var _ FrameSource = (*PollingSource)(nil)
+
+// NewPollingSource returns a PollingSource that calls capture for a new
+// frame when Frame is called, at most once per interval. The captured image
+// is copied, so capture may reuse it.
+func NewPollingSource(capture func() image.Image, interval time.Duration) *PollingSource {
+	return &PollingSource{capture: capture, interval: interval}
+}
+
+// Frame implements the FrameSource interface.
+func (s *PollingSource) Frame() (image.Image, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := timeNow()
+	if s.img != nil && now.Sub(s.captured) < s.interval {
+		return s.img, s.log.gen
+	}
+	s.captured = now
+
+	src := s.capture()
+	img := image.NewRGBA(src.Bounds())
+	draw.Draw(img, img.Rect, src, img.Rect.Min, draw.Src)
+
+	switch {
+	case s.img == nil:
+	case s.img.Rect != img.Rect:
+		s.log.add(img.Rect)
+	default:
+		if dirty := changedTiles(s.img, img); len(dirty) > 0 {
+			s.log.add(dirty...)
+		}
+	}
+	s.img = img
+	return s.img, s.log.gen
+}
+
+// Dirty implements the FrameSource interface.
+func (s *PollingSource) Dirty(since uint64) ([]image.Rectangle, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.log.since(since)
+}
+
+// changedTiles compares two images of the same bounds, and returns the tiles
+// that differ. Changed tiles that are next to each other in a row are merged.
+func changedTiles(a, b *image.RGBA) []image.Rectangle {
+	var dirty []image.Rectangle
+	for _, t := range tiles(a.Rect, pollTileSize) {
+		if tileEqual(a, b, t) {
+			continue
+		}
+		if n := len(dirty); n > 0 && dirty[n-1].Max.X == t.Min.X && dirty[n-1].Min.Y == t.Min.Y {
+			dirty[n-1].Max.X = t.Max.X
+			continue
+		}
+		dirty = append(dirty, t)
+	}
+	return dirty
+}
+
+// tileEqual returns whether the area t of two images is the same.
+func tileEqual(a, b *image.RGBA, t image.Rectangle) bool {
+	n := 4 * t.Dx()
+	for y := t.Min.Y; y < t.Max.Y; y++ {
+		i := a.PixOffset(t.Min.X, y)
+		if !bytes.Equal(a.Pix[i:i+n], b.Pix[i:i+n]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,172 @@
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDirtyLog(t *testing.T) {
+	var l dirtyLog
+	a, b := image.Rect(0, 0, 1, 1), image.Rect(1, 1, 2, 2)
+	if got, want := l.add(a), uint64(1); got != want {
+		t.Errorf("incorrect generation; got = %v, want = %v", got, want)
+	}
+	l.add(b)
+
+	for _, tt := range []struct {
+		since uint64
+		rects []image.Rectangle
+	}{
+		{0, []image.Rectangle{a, b}},
+		{1, []image.Rectangle{b}},
+		{2, nil},
+	} {
+		rects, ok := l.since(tt.since)
+		if !ok {
+			t.Fatalf("since(%v): changes unexpectedly unknown", tt.since)
+		}
+		if !reflect.DeepEqual(rects, tt.rects) {
+			t.Errorf("since(%v): incorrect rects; got = %v, want = %v", tt.since, rects, tt.rects)
+		}
+	}
+
+	// Old changes are forgotten.
+	for i := 0; i < maxDirtyEntries; i++ {
+		l.add(a)
+	}
+	if _, ok := l.since(1); ok {
+		t.Error("expected changes after generation 1 to be unknown")
+	}
+	if rects, ok := l.since(l.gen - 1); !ok || len(rects) != 1 {
+		t.Errorf("incorrect recent changes; got = %v, %v", rects, ok)
+	}
+}
+
+func TestSubtractDirty(t *testing.T) {
+	for _, tt := range []struct {
+		areas []image.Rectangle
+		r     image.Rectangle
+		rest  []image.Rectangle
+	}{
+		{[]image.Rectangle{image.Rect(0, 0, 4, 4)}, image.Rect(4, 0, 8, 4),
+			[]image.Rectangle{image.Rect(0, 0, 4, 4)}},
+		{[]image.Rectangle{image.Rect(0, 0, 4, 4)}, image.Rect(0, 0, 8, 8), nil},
+		{[]image.Rectangle{image.Rect(0, 0, 4, 4)}, image.Rect(1, 1, 3, 3),
+			[]image.Rectangle{
+				image.Rect(0, 0, 4, 1), image.Rect(0, 3, 4, 4),
+				image.Rect(0, 1, 1, 3), image.Rect(3, 1, 4, 3),
+			}},
+		{[]image.Rectangle{image.Rect(0, 0, 4, 4), image.Rect(6, 0, 8, 2)}, image.Rect(2, 0, 8, 4),
+			[]image.Rectangle{image.Rect(0, 0, 2, 4)}},
+	} {
+		if got := subtractDirty(tt.areas, tt.r); !reflect.DeepEqual(got, tt.rest) {
+			t.Errorf("subtractDirty(%v, %v) = %v, want %v", tt.areas, tt.r, got, tt.rest)
+		}
+	}
+
+	// Too many areas are merged.
+	var areas []image.Rectangle
+	for i := 0; i <= maxDirtyEntries; i++ {
+		areas = append(areas, image.Rect(i, 0, i+1, 1))
+	}
+	want := []image.Rectangle{image.Rect(0, 0, maxDirtyEntries+1, 1)}
+	if got := addDirty(nil, image.Rect(0, 0, 1000, 1000), areas); !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect merged areas; got = %v, want = %v", got, want)
+	}
+}
+
+func TestStaticSource(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	s := NewStaticSource(img)
+	if got, gen := s.Frame(); got != img || gen != 0 {
+		t.Errorf("incorrect frame; got = %v, %v", got, gen)
+	}
+	if rects, ok := s.Dirty(0); !ok || len(rects) != 0 {
+		t.Errorf("incorrect dirty areas; got = %v, %v", rects, ok)
+	}
+}
+
+func TestCanvas(t *testing.T) {
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	cv := NewCanvas(8, 8)
+
+	img, gen := cv.Frame()
+	if got, want := img.At(3, 3), (color.RGBA{0, 0, 0, 0xff}); got != want {
+		t.Errorf("incorrect initial color; got = %v, want = %v", got, want)
+	}
+
+	r := image.Rect(2, 2, 4, 4)
+	cv.Fill(r, red)
+	cv.Fill(image.Rect(6, 6, 10, 10), red) // Clipped to the canvas.
+
+	// The earlier frame is unchanged.
+	if got, want := img.At(3, 3), (color.RGBA{0, 0, 0, 0xff}); got != want {
+		t.Errorf("earlier frame changed; got = %v, want = %v", got, want)
+	}
+
+	img2, gen2 := cv.Frame()
+	if gen2 != gen+2 {
+		t.Errorf("incorrect generation; got = %v, want = %v", gen2, gen+2)
+	}
+	if got := img2.At(3, 3); got != red {
+		t.Errorf("incorrect color; got = %v, want = %v", got, red)
+	}
+	if img3, _ := cv.Frame(); img3 != img2 {
+		t.Error("expected unchanged canvas to return the same frame")
+	}
+
+	rects, ok := cv.Dirty(gen)
+	if want := []image.Rectangle{r, image.Rect(6, 6, 8, 8)}; !ok || !reflect.DeepEqual(rects, want) {
+		t.Errorf("incorrect dirty areas; got = %v, %v, want = %v", rects, ok, want)
+	}
+}
+
+func TestPollingSource(t *testing.T) {
+	now := time.Unix(0, 0)
+	defer fakeClock(&now, 0)()
+
+	screen := image.NewRGBA(image.Rect(0, 0, 100, 40))
+	captures := 0
+	s := NewPollingSource(func() image.Image {
+		captures++
+		return screen
+	}, time.Second)
+
+	_, gen := s.Frame()
+	if captures != 1 {
+		t.Fatalf("incorrect captures; got = %v, want = 1", captures)
+	}
+
+	// Changes in two adjacent tiles and one further away.
+	screen.Set(31, 0, color.White)
+	screen.Set(32, 0, color.White)
+	screen.Set(99, 39, color.White)
+
+	// Frames are not captured more often than the interval.
+	if img, g := s.Frame(); g != gen || img.At(31, 0) == color.White || captures != 1 {
+		t.Errorf("unexpected capture within the interval")
+	}
+
+	now = now.Add(time.Second)
+	img, gen2 := s.Frame()
+	if gen2 != gen+1 {
+		t.Errorf("incorrect generation; got = %v, want = %v", gen2, gen+1)
+	}
+	if r, g, b, _ := img.At(32, 0).RGBA(); r != 0xffff || g != 0xffff || b != 0xffff {
+		t.Errorf("incorrect color; got = %v", img.At(32, 0))
+	}
+	rects, ok := s.Dirty(gen)
+	want := []image.Rectangle{image.Rect(0, 0, 64, 32), image.Rect(96, 32, 100, 40)}
+	if !ok || !reflect.DeepEqual(rects, want) {
+		t.Errorf("incorrect dirty areas; got = %v, %v, want = %v", rects, ok, want)
+	}
+
+	// An unchanged capture is not a new generation.
+	now = now.Add(time.Second)
+	if _, g := s.Frame(); g != gen2 {
+		t.Errorf("incorrect generation; got = %v, want = %v", g, gen2)
+	}
+}
@@ -32,6 +32,7 @@ const (
 	// Client ProtocolVersions.
 	PROTO_VERS_UNSUP = "UNSUPPORTED"
 	PROTO_VERS_3_3   = "RFB 003.003\n"
+	PROTO_VERS_3_7   = "RFB 003.007\n"
 	PROTO_VERS_3_8   = "RFB 003.008\n"
 )
 
@@ -187,7 +188,8 @@ FindAuth:
 // securityResultHandshake implements §7.1.3 SecurityResult Handshake.
 func (c *ClientConn) securityResultHandshake() error {
 
-	if c.config.secType == secTypeNone {
+	// Version 3.3 omits the SecurityResult for the None security type.
+	if c.protocolVersion == PROTO_VERS_3_3 && c.config.secType == secTypeNone {
 		return nil
 	}
 
@@ -224,3 +226,161 @@ func (c *ClientConn) readErrorReason() (string, error) {
 
 	return string(reason), nil
 }
+
+//-----------------------------------------------------------------------------
+// Server side of the handshake.
+
+// protocolVersionHandshake implements the server side of §7.1.1
+// ProtocolVersion Handshake. The server offers version 3.8, and falls back to
+// 3.7, or to 3.3 for clients that ask for an earlier version.
+func (c *ServerConn) protocolVersionHandshake() error {
+	if err := c.send([]byte(PROTO_VERS_3_8)); err != nil {
+		return err
+	}
+
+	var protocolVersion [pvLen]byte
+	if err := c.receive(&protocolVersion); err != nil {
+		return err
+	}
+	if c.log != nil {
+		c.log.Printf("protocolVersion: %s", protocolVersion)
+	}
+
+	major, minor, err := parseProtocolVersion(protocolVersion[:])
+	if err != nil {
+		return err
+	}
+	pv := PROTO_VERS_UNSUP
+	if major == 3 {
+		if minor >= 8 {
+			pv = PROTO_VERS_3_8
+		} else if minor == 7 {
+			pv = PROTO_VERS_3_7
+		} else if minor >= 3 {
+			pv = PROTO_VERS_3_3
+		}
+	}
+	if pv == PROTO_VERS_UNSUP {
+		return NewVNCError(fmt.Sprintf("ProtocolVersion handshake failed; unsupported version '%v'", string(protocolVersion[:])))
+	}
+	c.protocolVersion = pv
+
+	return nil
+}
+
+// securityHandshake implements the server side of §7.1.2 Security Handshake,
+// choosing the security type. The authentication itself is performed by
+// authenticate.
+func (c *ServerConn) securityHandshake() error {
+	auths := c.config.Auth
+	if len(auths) == 0 {
+		auths = []ServerAuth{&ServerAuthNone{}}
+	}
+
+	switch c.protocolVersion {
+	case PROTO_VERS_3_3:
+		// The server chooses the security type, which can only be None or
+		// VNC authentication.
+		for _, a := range auths {
+			if t := a.SecurityType(); t == secTypeNone || t == secTypeVNCAuth {
+				c.auth = a
+				break
+			}
+		}
+		if c.auth == nil {
+			reason := "no security types supported by RFB 3.3"
+			if err := c.send(uint32(secTypeInvalid)); err != nil {
+				return err
+			}
+			if err := c.writeErrorReason(reason); err != nil {
+				return err
+			}
+			return NewVNCError(fmt.Sprintf("Security handshake failed; %s", reason))
+		}
+		c.secType = c.auth.SecurityType()
+		if err := c.send(uint32(c.secType)); err != nil {
+			return err
+		}
+
+	case PROTO_VERS_3_7, PROTO_VERS_3_8:
+		securityTypes := make([]uint8, len(auths))
+		for i, a := range auths {
+			securityTypes[i] = a.SecurityType()
+		}
+		if err := c.send(uint8(len(securityTypes))); err != nil {
+			return err
+		}
+		if err := c.send(securityTypes); err != nil {
+			return err
+		}
+		if err := c.receive(&c.secType); err != nil {
+			return err
+		}
+		for _, a := range auths {
+			if a.SecurityType() == c.secType {
+				c.auth = a
+				break
+			}
+		}
+		if c.auth == nil {
+			reason := fmt.Sprintf("unsupported security type: %v", c.secType)
+			if err := c.securityResultHandshake(NewVNCError(reason)); err != nil {
+				return err
+			}
+			return NewVNCError(fmt.Sprintf("Security handshake failed; %s", reason))
+		}
+
+	default:
+		return NewVNCError(fmt.Sprintf("Security handshake failed; unsupported protocol"))
+	}
+
+	return nil
+}
+
+// authenticate performs the authentication handshake of the chosen security
+// type, and then asks the Authorizer of the config, if set, whether the
+// client may connect. The returned error is reported to the client in the
+// SecurityResult.
+func (c *ServerConn) authenticate() error {
+	c.credentials = Credentials{SecurityType: c.secType}
+	defer func() { c.credentials.Password = "" }() // Not needed afterwards.
+	if err := c.auth.Handshake(c); err != nil {
+		return err
+	}
+	if c.config.Authorizer == nil {
+		return nil
+	}
+	return c.config.Authorizer(c.Conn.RemoteAddr(), &c.credentials)
+}
+
+// securityResultHandshake implements the server side of §7.1.3
+// SecurityResult Handshake, reporting authErr to the client if it is not nil.
+func (c *ServerConn) securityResultHandshake(authErr error) error {
+	// Versions 3.3 and 3.7 omit the SecurityResult for the None security
+	// type.
+	if c.protocolVersion != PROTO_VERS_3_8 && c.secType == secTypeNone {
+		return authErr
+	}
+
+	if authErr == nil {
+		return c.send(uint32(0))
+	}
+	if err := c.send(uint32(1)); err != nil {
+		return err
+	}
+	// Versions 3.3 and 3.7 have no failure reason.
+	if c.protocolVersion == PROTO_VERS_3_8 {
+		if err := c.writeErrorReason(authErr.Error()); err != nil {
+			return err
+		}
+	}
+	return authErr
+}
+
+// writeErrorReason sends a failure reason to the client.
+func (c *ServerConn) writeErrorReason(reason string) error {
+	if err := c.send(uint32(len(reason))); err != nil {
+		return err
+	}
+	return c.send([]byte(reason))
+}
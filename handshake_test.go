@@ -368,3 +368,28 @@ func TestSecurityResultHandshake(t *testing.T) {
 		}
 	}
 }
+
+func TestSecurityResultHandshake_None(t *testing.T) {
+	for _, tt := range []struct {
+		protocolVersion string
+		sent            bool
+	}{
+		{PROTO_VERS_3_3, false},
+		{PROTO_VERS_3_8, true},
+	} {
+		mockConn := &MockConn{}
+		conn := NewClientConn(mockConn, &ClientConfig{secType: secTypeNone})
+		conn.protocolVersion = tt.protocolVersion
+
+		// Send server message, which version 3.3 should leave unread.
+		if err := conn.send(uint32(0)); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.securityResultHandshake(); err != nil {
+			t.Fatalf("%v: unexpected error: %v", tt.protocolVersion, err)
+		}
+		if got, want := mockConn.b.Len() == 0, tt.sent; got != want {
+			t.Errorf("%v: SecurityResult read = %v, want %v", tt.protocolVersion, got, want)
+		}
+	}
+}
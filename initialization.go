@@ -54,6 +54,18 @@ func (m *ServerInit) Unmarshal(data []byte) error {
 	return nil
 }
 
+// Verify that interfaces are honored.
+var _ Marshaler = (*ServerInit)(nil)
+
+// Marshal implements the Marshaler interface.
+func (m *ServerInit) Marshal() ([]byte, error) {
+	buf := NewBuffer(nil)
+	if err := buf.Write(m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // serverInit implements §7.3.2 ServerInit.
 func (c *ClientConn) serverInit() error {
 	var msg ServerInit
@@ -63,8 +75,12 @@ func (c *ClientConn) serverInit() error {
 
 	c.setFramebufferWidth(msg.FBWidth)
 	c.setFramebufferHeight(msg.FBHeight)
+	c.fb.resize(int(msg.FBWidth), int(msg.FBHeight))
 	c.pixelFormat = msg.PixelFormat
 
+	if msg.NameLength > maxDesktopNameSize {
+		return Errorf("desktop name length %d exceeds %d", msg.NameLength, maxDesktopNameSize)
+	}
 	name := make([]uint8, msg.NameLength)
 	if err := c.receive(&name); err != nil {
 		return err
@@ -73,3 +89,31 @@ func (c *ClientConn) serverInit() error {
 
 	return nil
 }
+
+// clientInit implements the server side of §7.3.1 ClientInit.
+func (c *ServerConn) clientInit() error {
+	var sharedFlag rfbflags.RFBFlag
+	if err := c.receive(&sharedFlag); err != nil {
+		return err
+	}
+	c.shared = rfbflags.ToBool(sharedFlag)
+	return nil
+}
+
+// serverInit implements the server side of §7.3.2 ServerInit.
+func (c *ServerConn) serverInit() error {
+	msg := ServerInit{
+		FBWidth:     c.config.Width,
+		FBHeight:    c.config.Height,
+		PixelFormat: c.config.PixelFormat,
+		NameLength:  uint32(len(c.config.DesktopName)),
+	}
+	bytes, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := c.send(bytes); err != nil {
+		return err
+	}
+	return c.send([]byte(c.config.DesktopName))
+}
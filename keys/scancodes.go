@@ -0,0 +1,244 @@
+package keys
+
+// Scancode represents an XT (set 1) keyboard scancode. Extended scancodes
+// have the 0xE0 prefix in the high byte, e.g. 0xE048 for the Up key.
+type Scancode uint16
+
+// QEMU returns the scancode as a QEMU extended key event keycode, where
+// extended scancodes have the high bit of the low byte set instead of a
+// prefix.
+func (s Scancode) QEMU() uint32 {
+	if s&0xff00 == 0xe000 {
+		return uint32(s&0x7f | 0x80)
+	}
+	return uint32(s)
+}
+
+// USScancode returns the scancode of the key that produces k on a US
+// keyboard layout. Keys that require Shift, such as Exclaim or A, return the
+// scancode of the unshifted key; USShifted reports whether Shift must be held
+// with it.
+func USScancode(k Key) (Scancode, bool) {
+	s, ok := usScancodes[k]
+	return s, ok
+}
+
+// USShifted returns true if k is produced by holding Shift with the key of
+// its scancode on a US keyboard layout, as Exclaim is with Digit1.
+func USShifted(k Key) bool {
+	return usShifted[k]
+}
+
+var usScancodes = map[Key]Scancode{
+	Escape:         0x01,
+	Digit1:         0x02,
+	Exclaim:        0x02,
+	Digit2:         0x03,
+	At:             0x03,
+	Digit3:         0x04,
+	NumberSign:     0x04,
+	Digit4:         0x05,
+	Dollar:         0x05,
+	Digit5:         0x06,
+	Percent:        0x06,
+	Digit6:         0x07,
+	AsciiCircum:    0x07,
+	Digit7:         0x08,
+	Ampersand:      0x08,
+	Digit8:         0x09,
+	Asterisk:       0x09,
+	Digit9:         0x0a,
+	ParenLeft:      0x0a,
+	Digit0:         0x0b,
+	ParenRight:     0x0b,
+	Minus:          0x0c,
+	Underscore:     0x0c,
+	Equal:          0x0d,
+	Plus:           0x0d,
+	BackSpace:      0x0e,
+	Tab:            0x0f,
+	SmallQ:         0x10,
+	Q:              0x10,
+	SmallW:         0x11,
+	W:              0x11,
+	SmallE:         0x12,
+	E:              0x12,
+	SmallR:         0x13,
+	R:              0x13,
+	SmallT:         0x14,
+	T:              0x14,
+	SmallY:         0x15,
+	Y:              0x15,
+	SmallU:         0x16,
+	U:              0x16,
+	SmallI:         0x17,
+	I:              0x17,
+	SmallO:         0x18,
+	O:              0x18,
+	SmallP:         0x19,
+	P:              0x19,
+	BracketLeft:    0x1a,
+	BraceLeft:      0x1a,
+	BracketRight:   0x1b,
+	BraceRight:     0x1b,
+	Return:         0x1c,
+	ControlLeft:    0x1d,
+	SmallA:         0x1e,
+	A:              0x1e,
+	SmallS:         0x1f,
+	S:              0x1f,
+	SmallD:         0x20,
+	D:              0x20,
+	SmallF:         0x21,
+	F:              0x21,
+	SmallG:         0x22,
+	G:              0x22,
+	SmallH:         0x23,
+	H:              0x23,
+	SmallJ:         0x24,
+	J:              0x24,
+	SmallK:         0x25,
+	K:              0x25,
+	SmallL:         0x26,
+	L:              0x26,
+	Semicolon:      0x27,
+	Colon:          0x27,
+	Apostrophe:     0x28,
+	QuoteDbl:       0x28,
+	Grave:          0x29,
+	AsciiTilde:     0x29,
+	ShiftLeft:      0x2a,
+	Backslash:      0x2b,
+	Bar:            0x2b,
+	SmallZ:         0x2c,
+	Z:              0x2c,
+	SmallX:         0x2d,
+	X:              0x2d,
+	SmallC:         0x2e,
+	C:              0x2e,
+	SmallV:         0x2f,
+	V:              0x2f,
+	SmallB:         0x30,
+	B:              0x30,
+	SmallN:         0x31,
+	N:              0x31,
+	SmallM:         0x32,
+	M:              0x32,
+	Comma:          0x33,
+	Less:           0x33,
+	Period:         0x34,
+	Greater:        0x34,
+	Slash:          0x35,
+	Question:       0x35,
+	ShiftRight:     0x36,
+	KeypadMultiply: 0x37,
+	AltLeft:        0x38,
+	Space:          0x39,
+	CapsLock:       0x3a,
+	F1:             0x3b,
+	F2:             0x3c,
+	F3:             0x3d,
+	F4:             0x3e,
+	F5:             0x3f,
+	F6:             0x40,
+	F7:             0x41,
+	F8:             0x42,
+	F9:             0x43,
+	F10:            0x44,
+	NumLock:        0x45,
+	ScrollLock:     0x46,
+	Keypad7:        0x47,
+	KeypadHome:     0x47,
+	Keypad8:        0x48,
+	KeypadUp:       0x48,
+	Keypad9:        0x49,
+	KeypadPrior:    0x49,
+	KeypadSubtract: 0x4a,
+	Keypad4:        0x4b,
+	KeypadLeft:     0x4b,
+	Keypad5:        0x4c,
+	KeypadBegin:    0x4c,
+	Keypad6:        0x4d,
+	KeypadRight:    0x4d,
+	KeypadAdd:      0x4e,
+	Keypad1:        0x4f,
+	KeypadEnd:      0x4f,
+	Keypad2:        0x50,
+	KeypadDown:     0x50,
+	Keypad3:        0x51,
+	KeypadNext:     0x51,
+	Keypad0:        0x52,
+	KeypadInsert:   0x52,
+	KeypadDecimal:  0x53,
+	KeypadDelete:   0x53,
+	F11:            0x57,
+	F12:            0x58,
+	KeypadEnter:    0xe01c,
+	ControlRight:   0xe01d,
+	KeypadDivide:   0xe035,
+	Print:          0xe037,
+	AltRight:       0xe038,
+	Home:           0xe047,
+	Up:             0xe048,
+	PageUp:         0xe049,
+	Left:           0xe04b,
+	Right:          0xe04d,
+	End:            0xe04f,
+	Down:           0xe050,
+	PageDown:       0xe051,
+	Insert:         0xe052,
+	Delete:         0xe053,
+	SuperLeft:      0xe05b,
+	SuperRight:     0xe05c,
+	Menu:           0xe05d,
+}
+
+var usShifted = map[Key]bool{
+	Exclaim:     true,
+	At:          true,
+	NumberSign:  true,
+	Dollar:      true,
+	Percent:     true,
+	AsciiCircum: true,
+	Ampersand:   true,
+	Asterisk:    true,
+	ParenLeft:   true,
+	ParenRight:  true,
+	Underscore:  true,
+	Plus:        true,
+	Q:           true,
+	W:           true,
+	E:           true,
+	R:           true,
+	T:           true,
+	Y:           true,
+	U:           true,
+	I:           true,
+	O:           true,
+	P:           true,
+	BraceLeft:   true,
+	BraceRight:  true,
+	A:           true,
+	S:           true,
+	D:           true,
+	F:           true,
+	G:           true,
+	H:           true,
+	J:           true,
+	K:           true,
+	L:           true,
+	Colon:       true,
+	QuoteDbl:    true,
+	AsciiTilde:  true,
+	Bar:         true,
+	Z:           true,
+	X:           true,
+	C:           true,
+	V:           true,
+	B:           true,
+	N:           true,
+	M:           true,
+	Less:        true,
+	Greater:     true,
+	Question:    true,
+}
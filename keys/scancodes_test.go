@@ -0,0 +1,89 @@
+package keys
+
+import "testing"
+
+func TestUSScancode(t *testing.T) {
+	for _, tt := range []struct {
+		key      Key
+		scancode Scancode
+		ok       bool
+	}{
+		{SmallA, 0x1e, true},
+		{A, 0x1e, true},
+		{Exclaim, 0x02, true},
+		{Return, 0x1c, true},
+		{Insert, 0xe052, true},
+		{KeypadMultiply, 0x37, true},
+		{Keypad0, 0x52, true},
+		{Pause, 0, false},
+	} {
+		scancode, ok := USScancode(tt.key)
+		if ok != tt.ok {
+			t.Errorf("USScancode(%v) ok = %v, want %v", tt.key, ok, tt.ok)
+			continue
+		}
+		if scancode != tt.scancode {
+			t.Errorf("USScancode(%v) = %#x, want %#x", tt.key, scancode, tt.scancode)
+		}
+	}
+}
+
+func TestUSShifted(t *testing.T) {
+	for _, tt := range []struct {
+		key     Key
+		shifted bool
+	}{
+		{SmallA, false},
+		{A, true},
+		{Digit1, false},
+		{Exclaim, true},
+		{At, true},
+		{Question, true},
+		{Slash, false},
+		{Return, false},
+	} {
+		if got := USShifted(tt.key); got != tt.shifted {
+			t.Errorf("USShifted(%v) = %v, want %v", tt.key, got, tt.shifted)
+		}
+	}
+	for k := range usShifted {
+		if _, ok := USScancode(k); !ok {
+			t.Errorf("USShifted(%v) has no scancode", k)
+		}
+	}
+}
+
+func TestScancode_QEMU(t *testing.T) {
+	for _, tt := range []struct {
+		scancode Scancode
+		keycode  uint32
+	}{
+		{0x1e, 0x1e},
+		{0xe048, 0xc8},
+		{0xe01d, 0x9d},
+	} {
+		if got, want := tt.scancode.QEMU(), tt.keycode; got != want {
+			t.Errorf("Scancode(%#x).QEMU() = %#x, want %#x", tt.scancode, got, want)
+		}
+	}
+}
+
+func TestKeysyms(t *testing.T) {
+	for _, tt := range []struct {
+		key  Key
+		want uint32
+	}{
+		{Select, 0xff60},
+		{Insert, 0xff63},
+		{Undo, 0xff65},
+		{Break, 0xff6b},
+		{KeypadPageUp, 0xff9a},
+		{KeypadDelete, 0xff9f},
+		{KeypadMultiply, 0xffaa},
+		{Keypad9, 0xffb9},
+	} {
+		if got := uint32(tt.key); got != tt.want {
+			t.Errorf("%v = %#x, want %#x", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Implementation of the QEMU and VMware LED State pseudo-encodings.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#qemu-led-state-pseudo-encoding
+*/
+package vnc
+
+import (
+	"fmt"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/keys"
+	"github.com/phox/go-vnc/messages"
+)
+
+// LEDState is a bitwise mask of the keyboard locks that are on.
+type LEDState uint8
+
+// Keyboard lock LEDs.
+const (
+	ScrollLockLED LEDState = 1 << iota
+	NumLockLED
+	CapsLockLED
+)
+
+// ledKeys maps the LEDs to the keys that toggle them.
+var ledKeys = []struct {
+	led LEDState
+	key keys.Key
+}{
+	{ScrollLockLED, keys.ScrollLock},
+	{NumLockLED, keys.NumLock},
+	{CapsLockLED, keys.CapsLock},
+}
+
+// LEDState returns the keyboard lock state of the server, and whether it is
+// known. It is known once the server has sent a QEMU or VMware LED State
+// pseudo-encoded rectangle.
+func (c *ClientConn) LEDState() (LEDState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.leds, c.ledsKnown
+}
+
+// setLEDState stores the keyboard lock state, and queues an LEDStateChange
+// message.
+func (c *ClientConn) setLEDState(s LEDState) {
+	c.mu.Lock()
+	c.leds, c.ledsKnown = s, true
+	c.mu.Unlock()
+	c.queueMessage(&LEDStateChange{s})
+}
+
+// SetLockState presses and releases the lock keys needed for the locks in mask
+// to match state. It requires the keyboard lock state to be known.
+//
+// The state returned by LEDState is only updated once the server reports the
+// new state.
+func (c *ClientConn) SetLockState(state, mask LEDState) error {
+	current, ok := c.LEDState()
+	if !ok {
+		return fmt.Errorf("keyboard lock state is not known")
+	}
+
+	for _, lk := range ledKeys {
+		if mask&lk.led == 0 || (current^state)&lk.led == 0 {
+			continue
+		}
+		if err := c.ScancodeKeyEvent(lk.key, true); err != nil {
+			return err
+		}
+		if err := c.ScancodeKeyEvent(lk.key, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// QEMU LED State Pseudo-Encoding
+//
+// The QEMU LED State rectangle carries a single byte holding the keyboard lock
+// state.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#qemu-led-state-pseudo-encoding
+
+// QEMULEDStatePseudoEncoding represents the keyboard lock state.
+type QEMULEDStatePseudoEncoding struct {
+	State LEDState
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*QEMULEDStatePseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (e *QEMULEDStatePseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{uint8(e.State)}, nil
+}
+
+// Read implements the Encoding interface.
+func (*QEMULEDStatePseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	var state LEDState
+	if err := c.receive(&state); err != nil {
+		return nil, fmt.Errorf("unable to read LED state: %s", err)
+	}
+	state &= ScrollLockLED | NumLockLED | CapsLockLED
+
+	c.setLEDState(state)
+	return &QEMULEDStatePseudoEncoding{state}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*QEMULEDStatePseudoEncoding) String() string { return "QEMULEDStatePseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*QEMULEDStatePseudoEncoding) Type() encodings.Encoding { return encodings.QEMULEDStatePseudo }
+
+//-----------------------------------------------------------------------------
+// VMware LED State Pseudo-Encoding
+//
+// The VMware LED State rectangle carries the keyboard lock state as a U32,
+// with the same bits as the QEMU LED State.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#vmware-led-state-pseudo-encoding
+
+// VMwareLEDStatePseudoEncoding represents the keyboard lock state.
+type VMwareLEDStatePseudoEncoding struct {
+	State LEDState
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*VMwareLEDStatePseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (e *VMwareLEDStatePseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{0, 0, 0, uint8(e.State)}, nil
+}
+
+// Read implements the Encoding interface.
+func (*VMwareLEDStatePseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	var state uint32
+	if err := c.receive(&state); err != nil {
+		return nil, fmt.Errorf("unable to read LED state: %s", err)
+	}
+	leds := LEDState(state) & (ScrollLockLED | NumLockLED | CapsLockLED)
+
+	c.setLEDState(leds)
+	return &VMwareLEDStatePseudoEncoding{leds}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*VMwareLEDStatePseudoEncoding) String() string { return "VMwareLEDStatePseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*VMwareLEDStatePseudoEncoding) Type() encodings.Encoding {
+	return encodings.VMwareLEDStatePseudo
+}
+
+//-----------------------------------------------------------------------------
+// LEDStateChange is synthesized by the client when a FramebufferUpdate
+// contains a QEMU or VMware LED State pseudo-encoded rectangle. It is sent on
+// the ServerMessage channel after the FramebufferUpdate that contained it.
+
+// LEDStateChange holds the new keyboard lock state.
+type LEDStateChange struct {
+	State LEDState
+}
+
+// Verify that interfaces are honored.
+var _ ServerMessage = (*LEDStateChange)(nil)
+
+// Type implements the ServerMessage interface.
+func (*LEDStateChange) Type() messages.ServerMessage { return messages.LEDStateChange }
+
+// Read implements the ServerMessage interface. LEDStateChange is never sent on
+// the wire, so it can not be read.
+func (*LEDStateChange) Read(c *ClientConn) (ServerMessage, error) {
+	return nil, fmt.Errorf("LEDStateChange is not a wire message")
+}
@@ -0,0 +1,88 @@
+package vnc
+
+import (
+	"testing"
+
+	"github.com/phox/go-vnc/keys"
+	"github.com/phox/go-vnc/messages"
+)
+
+func TestLEDStatePseudoEncodings(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	if _, ok := conn.LEDState(); ok {
+		t.Error("LED state known before pseudo-encoding was received")
+	}
+
+	for _, tt := range []struct {
+		enc   Encoding
+		data  []byte
+		state LEDState
+	}{
+		{&QEMULEDStatePseudoEncoding{}, []byte{0x04}, CapsLockLED},
+		{&QEMULEDStatePseudoEncoding{}, []byte{0xfb}, ScrollLockLED | NumLockLED},
+		{&VMwareLEDStatePseudoEncoding{}, []byte{0, 0, 0, 0x07}, ScrollLockLED | NumLockLED | CapsLockLED},
+		{&VMwareLEDStatePseudoEncoding{}, []byte{0, 0, 1, 0x00}, 0},
+	} {
+		mockConn.Reset()
+		if err := conn.send(tt.data); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tt.enc.Read(conn, &Rectangle{}); err != nil {
+			t.Fatalf("%v: unexpected error: %v", tt.data, err)
+		}
+		if state, ok := conn.LEDState(); !ok || state != tt.state {
+			t.Errorf("%v: incorrect LED state; got = %v, %v, want = %v", tt.data, state, ok, tt.state)
+		}
+		pending := conn.takePending()
+		if len(pending) != 1 || pending[0].Type() != messages.LEDStateChange {
+			t.Fatalf("incorrect pending messages; got = %v", pending)
+		}
+		if got, want := pending[0].(*LEDStateChange).State, tt.state; got != want {
+			t.Errorf("incorrect change message state; got = %v, want = %v", got, want)
+		}
+	}
+}
+
+func TestSetLockState(t *testing.T) {
+	defer SetSettle(Settle())
+	SetSettle(0)
+
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	if err := conn.SetLockState(0, CapsLockLED); err == nil {
+		t.Error("expected error when LED state is not known")
+	}
+
+	conn.setLEDState(CapsLockLED | ScrollLockLED)
+	for _, tt := range []struct {
+		state, mask LEDState
+		keys        []keys.Key
+	}{
+		{0, CapsLockLED, []keys.Key{keys.CapsLock}},
+		{CapsLockLED, CapsLockLED, nil},
+		{NumLockLED, NumLockLED | CapsLockLED, []keys.Key{keys.NumLock, keys.CapsLock}},
+		{0, ScrollLockLED | NumLockLED | CapsLockLED, []keys.Key{keys.ScrollLock, keys.CapsLock}},
+	} {
+		mockConn.Reset()
+		if err := conn.SetLockState(tt.state, tt.mask); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, key := range tt.keys {
+			for _, down := range []bool{true, false} {
+				var msg KeyEventMessage
+				if err := conn.receive(&msg); err != nil {
+					t.Fatal(err)
+				}
+				if msg.Key != key || (msg.DownFlag != 0) != down {
+					t.Errorf("incorrect key event; got = %v, want key %v down %v", msg, key, down)
+				}
+			}
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%v/%v: %d unexpected bytes sent", tt.state, tt.mask, mockConn.b.Len())
+		}
+	}
+}
@@ -24,6 +24,16 @@ const (
 	ClientCutText
 )
 
+// Client-to-Server message types of protocol extensions.
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#client-to-server-messages
+const (
+	EnableContinuousUpdates ClientMessage = 150
+	ClientFence             ClientMessage = 248
+	ClientXVP               ClientMessage = 250
+	SetDesktopSize          ClientMessage = 251
+	ClientQEMU              ClientMessage = 255
+)
+
 //-----------------------------------------------------------------------------
 // Server messages
 //
@@ -41,3 +51,21 @@ const (
 	Bell
 	ServerCutText
 )
+
+// Server-to-Client message types of protocol extensions.
+// https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#server-to-client-messages
+const (
+	EndOfContinuousUpdates ServerMessage = 150
+	ServerFence            ServerMessage = 248
+	ServerXVP              ServerMessage = 250
+	ServerQEMU             ServerMessage = 255
+)
+
+// Pseudo server message types. These are never sent on the wire; the client
+// uses them for messages it synthesizes from the data of other messages, such
+// as the cursor shape carried by a FramebufferUpdate rectangle.
+const (
+	CursorUpdate ServerMessage = 200 + iota
+	DesktopNameChange
+	LEDStateChange
+)
@@ -0,0 +1,136 @@
+/*
+Implementation of the ExtendedMouseButtons pseudo-encoding, and scrolling.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#extendedmousebuttons-pseudo-encoding
+*/
+package vnc
+
+import (
+	"fmt"
+
+	"github.com/phox/go-vnc/buttons"
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/messages"
+)
+
+//-----------------------------------------------------------------------------
+// ExtendedMouseButtons Pseudo-Encoding
+//
+// The ExtendedMouseButtons pseudo-encoding extends PointerEvent with a second
+// button mask, for the back and forward buttons. The highest bit of the
+// button-mask, which would otherwise be button 8, marks the extended form.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#extendedmousebuttons-pseudo-encoding
+
+// extendedButtonsMarker is the button-mask bit that marks an extended
+// PointerEvent.
+const extendedButtonsMarker = 0x80
+
+// ExtendedMouseButtonsSupported returns true once the server has indicated
+// that it supports extended mouse buttons, by sending an ExtendedMouseButtons
+// pseudo-encoded rectangle.
+func (c *ClientConn) ExtendedMouseButtonsSupported() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.extendedMouseButtons
+}
+
+// ExtendedMouseButtonsPseudoEncoding tells the server that the client supports
+// extended mouse buttons, and is sent back by servers that support them.
+type ExtendedMouseButtonsPseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*ExtendedMouseButtonsPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*ExtendedMouseButtonsPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (*ExtendedMouseButtonsPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	c.mu.Lock()
+	c.extendedMouseButtons = true
+	c.mu.Unlock()
+	return &ExtendedMouseButtonsPseudoEncoding{}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*ExtendedMouseButtonsPseudoEncoding) String() string {
+	return "ExtendedMouseButtonsPseudoEncoding"
+}
+
+// Type implements the Encoding interface.
+func (*ExtendedMouseButtonsPseudoEncoding) Type() encodings.Encoding {
+	return encodings.ExtendedMouseButtonsPseudo
+}
+
+// ExtendedPointerEventMessage holds the wire format message.
+type ExtendedPointerEventMessage struct {
+	Msg          messages.ClientMessage // message-type
+	Mask         uint8                  // button-mask
+	X, Y         uint16                 // x-, y-position
+	ExtendedMask uint8                  // extended-button-mask
+}
+
+// pointerEventMessage returns the PointerEvent message for the buttons. The
+// extended form is used when buttons beyond Seven are pressed and the server
+// supports it.
+func (c *ClientConn) pointerEventMessage(button buttons.Button, x, y uint16) (interface{}, error) {
+	if button&^(buttons.Seven<<1-1) != 0 && c.ExtendedMouseButtonsSupported() {
+		return ExtendedPointerEventMessage{
+			Msg:          messages.PointerEvent,
+			Mask:         buttons.Mask(button) | extendedButtonsMarker,
+			X:            x,
+			Y:            y,
+			ExtendedMask: uint8(button >> 7),
+		}, nil
+	}
+	if button&^(buttons.Eight<<1-1) != 0 {
+		return nil, fmt.Errorf("button %v requires extended mouse buttons", button)
+	}
+	return PointerEventMessage{messages.PointerEvent, buttons.Mask(button), x, y}, nil
+}
+
+//-----------------------------------------------------------------------------
+// Scrolling
+//
+// Scroll wheels are reported as buttons, with a press and release for each
+// notch: Four and Five scroll up and down, and Six and Seven scroll left and
+// right.
+
+// scroll sends n notches of wheel scrolling at the current pointer position,
+// with the buttons currently pressed held down.
+func (c *ClientConn) scroll(wheel buttons.Button, n int) error {
+	c.mu.Lock()
+	pos, held := c.pointerPos, c.pointerButtons
+	c.mu.Unlock()
+
+	held &^= buttons.WheelUp | buttons.WheelDown | buttons.WheelLeft | buttons.WheelRight
+	for i := 0; i < n; i++ {
+		if err := c.PointerEvent(held|wheel, uint16(pos.X), uint16(pos.Y)); err != nil {
+			return err
+		}
+		if err := c.PointerEvent(held, uint16(pos.X), uint16(pos.Y)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScrollVertical scrolls the wheel down by n notches, or up if n is negative,
+// at the current pointer position.
+func (c *ClientConn) ScrollVertical(n int) error {
+	if n < 0 {
+		return c.scroll(buttons.WheelUp, -n)
+	}
+	return c.scroll(buttons.WheelDown, n)
+}
+
+// ScrollHorizontal scrolls the wheel right by n notches, or left if n is
+// negative, at the current pointer position.
+func (c *ClientConn) ScrollHorizontal(n int) error {
+	if n < 0 {
+		return c.scroll(buttons.WheelLeft, -n)
+	}
+	return c.scroll(buttons.WheelRight, n)
+}
@@ -0,0 +1,140 @@
+package vnc
+
+import (
+	"image"
+	"testing"
+
+	"github.com/phox/go-vnc/buttons"
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/messages"
+)
+
+func TestExtendedMouseButtonsPseudoEncoding(t *testing.T) {
+	e := &ExtendedMouseButtonsPseudoEncoding{}
+	if got, want := e.Type(), encodings.ExtendedMouseButtonsPseudo; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	if conn.ExtendedMouseButtonsSupported() {
+		t.Error("extended mouse buttons supported before pseudo-encoding was received")
+	}
+	if _, err := e.Read(conn, &Rectangle{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !conn.ExtendedMouseButtonsSupported() {
+		t.Error("extended mouse buttons not supported after pseudo-encoding was received")
+	}
+}
+
+func TestExtendedPointerEvent(t *testing.T) {
+	defer SetSettle(Settle())
+	SetSettle(0)
+
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	// Without extended mouse buttons, only buttons up to Eight can be sent.
+	if err := conn.PointerEvent(buttons.Eight|buttons.Left, 1, 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var msg PointerEventMessage
+	if err := conn.receive(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if want := (PointerEventMessage{messages.PointerEvent, 0x81, 1, 2}); msg != want {
+		t.Errorf("incorrect message; got = %v, want = %v", msg, want)
+	}
+	if err := conn.PointerEvent(buttons.Forward, 1, 2); err == nil {
+		t.Error("expected error for Forward without extended mouse buttons")
+	}
+
+	conn.extendedMouseButtons = true
+	for _, tt := range []struct {
+		button buttons.Button
+		ext    bool
+		msg    ExtendedPointerEventMessage
+	}{
+		{buttons.Left | buttons.Seven, false,
+			ExtendedPointerEventMessage{messages.PointerEvent, 0x41, 3, 4, 0}},
+		{buttons.Back, true,
+			ExtendedPointerEventMessage{messages.PointerEvent, 0x80, 3, 4, 0x01}},
+		{buttons.Forward | buttons.Right, true,
+			ExtendedPointerEventMessage{messages.PointerEvent, 0x84, 3, 4, 0x02}},
+	} {
+		mockConn.Reset()
+		if err := conn.PointerEvent(tt.button, 3, 4); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got ExtendedPointerEventMessage
+		if tt.ext {
+			if err := conn.receive(&got); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			var msg PointerEventMessage
+			if err := conn.receive(&msg); err != nil {
+				t.Fatal(err)
+			}
+			got = ExtendedPointerEventMessage{msg.Msg, msg.Mask, msg.X, msg.Y, 0}
+		}
+		if got != tt.msg {
+			t.Errorf("%v: incorrect message; got = %v, want = %v", tt.button, got, tt.msg)
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%v: %d unexpected bytes sent", tt.button, mockConn.b.Len())
+		}
+	}
+}
+
+func TestScroll(t *testing.T) {
+	defer SetSettle(Settle())
+	SetSettle(0)
+
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	for _, tt := range []struct {
+		scroll func(int) error
+		n      int
+		wheel  buttons.Button
+	}{
+		{conn.ScrollVertical, 2, buttons.WheelDown},
+		{conn.ScrollVertical, -3, buttons.WheelUp},
+		{conn.ScrollHorizontal, 1, buttons.WheelRight},
+		{conn.ScrollHorizontal, -2, buttons.WheelLeft},
+		{conn.ScrollVertical, 0, buttons.WheelDown},
+	} {
+		if err := conn.PointerEvent(buttons.Left, 10, 20); err != nil {
+			t.Fatal(err)
+		}
+		mockConn.Reset()
+
+		if err := tt.scroll(tt.n); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		n := tt.n
+		if n < 0 {
+			n = -n
+		}
+		for i := 0; i < 2*n; i++ {
+			var msg PointerEventMessage
+			if err := conn.receive(&msg); err != nil {
+				t.Fatal(err)
+			}
+			want := PointerEventMessage{messages.PointerEvent, buttons.Mask(buttons.Left), 10, 20}
+			if i%2 == 0 {
+				want.Mask |= buttons.Mask(tt.wheel)
+			}
+			if msg != want {
+				t.Errorf("%v x %d: event %d incorrect; got = %v, want = %v", tt.wheel, tt.n, i, msg, want)
+			}
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%v x %d: %d unexpected bytes sent", tt.wheel, tt.n, mockConn.b.Len())
+		}
+		if got, want := conn.PointerPos(), image.Pt(10, 20); got != want {
+			t.Errorf("incorrect pointer position; got = %v, want = %v", got, want)
+		}
+	}
+}
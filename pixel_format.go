@@ -5,6 +5,7 @@ package vnc
 import (
 	"encoding/binary"
 	"fmt"
+	"image/color"
 	"io"
 	"math"
 
@@ -118,3 +119,64 @@ func (pf PixelFormat) order() binary.ByteOrder {
 	}
 	return binary.LittleEndian
 }
+
+// bytesPerPixel returns the number of bytes used to send a pixel.
+func (pf PixelFormat) bytesPerPixel() int {
+	return int(pf.BPP / 8)
+}
+
+// cpixelSize returns the number of bytes used to send a compressed pixel
+// (CPIXEL), as used by the TRLE and ZRLE encodings. A CPIXEL is three bytes
+// when all the color bits of a 32 bit true color pixel fit into either the
+// least or most significant three bytes.
+//
+// See RFC 6143 §7.7.5.
+// https://tools.ietf.org/html/rfc6143#section-7.7.5
+func (pf PixelFormat) cpixelSize() int {
+	if pf.BPP == 32 && pf.Depth <= 24 && rfbflags.IsTrueColor(pf.TrueColor) {
+		if mask := pf.colorMask(); mask&0xff000000 == 0 || mask&0x000000ff == 0 {
+			return 3
+		}
+	}
+	return pf.bytesPerPixel()
+}
+
+// colorMask returns the bits of a pixel value used for color.
+func (pf PixelFormat) colorMask() uint32 {
+	return uint32(pf.RedMax)<<pf.RedShift | uint32(pf.GreenMax)<<pf.GreenShift | uint32(pf.BlueMax)<<pf.BlueShift
+}
+
+// pixel returns the pixel value held in data, which is in the byte order of
+// the pixel format. A three byte CPIXEL is expanded to a full pixel value.
+func (pf PixelFormat) pixel(data []byte) uint32 {
+	order := pf.order()
+	switch len(data) {
+	case 1:
+		return uint32(data[0])
+	case 2:
+		return uint32(order.Uint16(data))
+	case 3:
+		// Pad the unused byte, which is the most significant byte when the
+		// color bits fit into the least significant three bytes.
+		var b [4]byte
+		if (pf.colorMask()&0xff000000 == 0) == rfbflags.IsBigEndian(pf.BigEndian) {
+			copy(b[1:], data)
+		} else {
+			copy(b[:3], data)
+		}
+		return order.Uint32(b[:])
+	case 4:
+		return order.Uint32(data)
+	}
+	return 0
+}
+
+// rgba converts a true color pixel value to a color.
+func (pf PixelFormat) rgba(pixel uint32) color.RGBA {
+	return color.RGBA{
+		R: uint8(scaleColor(uint16((pixel>>pf.RedShift)&uint32(pf.RedMax)), pf.RedMax) >> 8),
+		G: uint8(scaleColor(uint16((pixel>>pf.GreenShift)&uint32(pf.GreenMax)), pf.GreenMax) >> 8),
+		B: uint8(scaleColor(uint16((pixel>>pf.BlueShift)&uint32(pf.BlueMax)), pf.BlueMax) >> 8),
+		A: 0xff,
+	}
+}
@@ -132,6 +132,52 @@ func TestPixelFormat_String(t *testing.T) {
 	}
 }
 
+func TestPixelFormat_CPixel(t *testing.T) {
+	for _, tt := range []struct {
+		desc  string
+		pf    PixelFormat
+		data  []byte
+		size  int
+		pixel uint32
+	}{
+		{"16bpp",
+			PixelFormat{BPP: 16, Depth: 16, BigEndian: RFBTrue, TrueColor: RFBTrue,
+				RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5, BlueShift: 0},
+			[]byte{0x12, 0x34}, 2, 0x1234},
+		{"32bpp depth 32",
+			PixelFormat{BPP: 32, Depth: 32, BigEndian: RFBTrue, TrueColor: RFBTrue,
+				RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0},
+			[]byte{0x12, 0x34, 0x56, 0x78}, 4, 0x12345678},
+		{"32bpp color mapped",
+			PixelFormat{BPP: 32, Depth: 24, BigEndian: RFBTrue, TrueColor: RFBFalse},
+			[]byte{0, 0, 0, 7}, 4, 7},
+		{"least significant bytes, big endian",
+			PixelFormat{BPP: 32, Depth: 24, BigEndian: RFBTrue, TrueColor: RFBTrue,
+				RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0},
+			[]byte{0x12, 0x34, 0x56}, 3, 0x123456},
+		{"least significant bytes, little endian",
+			PixelFormat{BPP: 32, Depth: 24, BigEndian: RFBFalse, TrueColor: RFBTrue,
+				RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0},
+			[]byte{0x56, 0x34, 0x12}, 3, 0x123456},
+		{"most significant bytes, big endian",
+			PixelFormat{BPP: 32, Depth: 24, BigEndian: RFBTrue, TrueColor: RFBTrue,
+				RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 24, GreenShift: 16, BlueShift: 8},
+			[]byte{0x12, 0x34, 0x56}, 3, 0x12345600},
+		{"most significant bytes, little endian",
+			PixelFormat{BPP: 32, Depth: 24, BigEndian: RFBFalse, TrueColor: RFBTrue,
+				RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 24, GreenShift: 16, BlueShift: 8},
+			[]byte{0x56, 0x34, 0x12}, 3, 0x12345600},
+	} {
+		if got, want := tt.pf.cpixelSize(), tt.size; got != want {
+			t.Errorf("%s: cpixelSize() = %d, want = %d", tt.desc, got, want)
+			continue
+		}
+		if got, want := tt.pf.pixel(tt.data), tt.pixel; got != want {
+			t.Errorf("%s: pixel() = %#x, want = %#x", tt.desc, got, want)
+		}
+	}
+}
+
 func equalPixelFormat(g, w PixelFormat) bool {
 	got, err := g.Marshal()
 	if err != nil {
@@ -0,0 +1,131 @@
+/*
+Implementation of the LastRect and DesktopName pseudo-encodings.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#pseudo-encodings
+*/
+package vnc
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/messages"
+)
+
+// lastRectMarker is the number-of-rectangles value a server sends when it
+// terminates a FramebufferUpdate with a LastRect rectangle instead.
+const lastRectMarker = 0xffff
+
+//-----------------------------------------------------------------------------
+// LastRect Pseudo-Encoding
+//
+// A LastRect rectangle marks the end of a FramebufferUpdate, which allows
+// servers to send updates without knowing the number of rectangles in
+// advance. Such updates have a number-of-rectangles of 0xFFFF.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#lastrect-pseudo-encoding
+
+// LastRectPseudoEncoding represents the end of a FramebufferUpdate.
+type LastRectPseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*LastRectPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*LastRectPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (*LastRectPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	return &LastRectPseudoEncoding{}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*LastRectPseudoEncoding) String() string { return "LastRectPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*LastRectPseudoEncoding) Type() encodings.Encoding { return encodings.LastRectPseudo }
+
+//-----------------------------------------------------------------------------
+// DesktopName Pseudo-Encoding
+//
+// The DesktopName pseudo-encoding tells the client that the desktop has been
+// renamed. The rectangle is followed by the new name, in UTF-8.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#desktopname-pseudo-encoding
+
+// maxDesktopNameSize is the maximum length of a desktop name, whether sent
+// with ServerInit or the DesktopName pseudo-encoding.
+const maxDesktopNameSize = 4 << 10
+
+// DesktopNamePseudoEncoding represents a desktop name change.
+type DesktopNamePseudoEncoding struct {
+	Name string
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*DesktopNamePseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (e *DesktopNamePseudoEncoding) Marshal() ([]byte, error) {
+	buf := NewBuffer(nil)
+	if err := buf.Write(uint32(len(e.Name))); err != nil {
+		return nil, err
+	}
+	if err := buf.Write([]byte(e.Name)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Read implements the Encoding interface.
+func (*DesktopNamePseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	var length uint32
+	if err := c.receive(&length); err != nil {
+		return nil, fmt.Errorf("unable to read desktop name: %s", err)
+	}
+	if length > maxDesktopNameSize {
+		return nil, fmt.Errorf("desktop name length %d exceeds %d", length, maxDesktopNameSize)
+	}
+	name := make([]byte, length)
+	if err := c.receive(&name); err != nil {
+		return nil, fmt.Errorf("unable to read desktop name: %s", err)
+	}
+	if !utf8.Valid(name) {
+		return nil, fmt.Errorf("desktop name is not valid UTF-8")
+	}
+
+	c.setDesktopName(string(name))
+	c.queueMessage(&DesktopNameChange{string(name)})
+
+	return &DesktopNamePseudoEncoding{string(name)}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*DesktopNamePseudoEncoding) String() string { return "DesktopNamePseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*DesktopNamePseudoEncoding) Type() encodings.Encoding { return encodings.DesktopNamePseudo }
+
+//-----------------------------------------------------------------------------
+// DesktopNameChange is synthesized by the client when a FramebufferUpdate
+// contains a DesktopName pseudo-encoded rectangle. It is sent on the
+// ServerMessage channel after the FramebufferUpdate that contained it.
+
+// DesktopNameChange holds the new desktop name.
+type DesktopNameChange struct {
+	Name string
+}
+
+// Verify that interfaces are honored.
+var _ ServerMessage = (*DesktopNameChange)(nil)
+
+// Type implements the ServerMessage interface.
+func (*DesktopNameChange) Type() messages.ServerMessage { return messages.DesktopNameChange }
+
+// Read implements the ServerMessage interface. DesktopNameChange is never
+// sent on the wire, so it can not be read.
+func (*DesktopNameChange) Read(c *ClientConn) (ServerMessage, error) {
+	return nil, fmt.Errorf("DesktopNameChange is not a wire message")
+}
@@ -0,0 +1,157 @@
+package vnc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/messages"
+)
+
+func TestLastRectPseudoEncoding_Type(t *testing.T) {
+	e := &LastRectPseudoEncoding{}
+	if got, want := e.Type(), encodings.LastRectPseudo; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+}
+
+func TestFramebufferUpdate_LastRect(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.encodings = Encodings{&RawEncoding{}, &DesktopNamePseudoEncoding{}, &LastRectPseudoEncoding{}}
+
+	for _, tt := range []struct {
+		desc    string
+		numRect uint16
+		rects   []Rectangle
+		want    int
+	}{
+		{"unknown number of rectangles", lastRectMarker,
+			[]Rectangle{
+				{Enc: &DesktopNamePseudoEncoding{"a"}},
+				{Enc: &DesktopNamePseudoEncoding{"b"}},
+				{Enc: &LastRectPseudoEncoding{}},
+			}, 2},
+		{"early last rectangle", 3,
+			[]Rectangle{
+				{Enc: &DesktopNamePseudoEncoding{"a"}},
+				{Enc: &LastRectPseudoEncoding{}},
+			}, 1},
+	} {
+		mockConn.Reset()
+		msg := &FramebufferUpdate{NumRect: tt.numRect, Rects: tt.rects}
+		data, err := msg.Marshal()
+		if err != nil {
+			t.Fatalf("%s: failed to marshal; %s", tt.desc, err)
+		}
+		if err := conn.send(data[1:]); err != nil { // Skip the message-type.
+			t.Fatal(err)
+		}
+
+		parsed, err := (&FramebufferUpdate{}).Read(conn)
+		if err != nil {
+			t.Fatalf("%s: failed to read; %s", tt.desc, err)
+		}
+		fu := parsed.(*FramebufferUpdate)
+		if got, want := len(fu.Rects), tt.want; got != want {
+			t.Errorf("%s: incorrect number of rectangles; got = %d, want = %d", tt.desc, got, want)
+		}
+		if got, want := int(fu.NumRect), tt.want; got != want {
+			t.Errorf("%s: incorrect number-of-rectangles; got = %d, want = %d", tt.desc, got, want)
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%s: %d bytes left unread", tt.desc, mockConn.b.Len())
+		}
+		conn.takePending()
+	}
+}
+
+func TestFramebufferUpdate_LastRectNotSupported(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.encodings = Encodings{&RawEncoding{}, &DesktopNamePseudoEncoding{}}
+
+	// Without LastRect, a count of 0xFFFF is the number of rectangles.
+	rect := &Rectangle{Enc: &DesktopNamePseudoEncoding{"a"}}
+	data, err := rect.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send([]byte{0}); err != nil { // padding
+		t.Fatal(err)
+	}
+	if err := conn.send(uint16(lastRectMarker)); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send(bytes.Repeat(data, int(lastRectMarker))); err != nil {
+		t.Fatal(err)
+	}
+	next := []byte{1, 2, 3, 4} // The next message.
+	if err := conn.send(next); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := (&FramebufferUpdate{}).Read(conn)
+	if err != nil {
+		t.Fatalf("failed to read; %s", err)
+	}
+	if got, want := len(parsed.(*FramebufferUpdate).Rects), int(lastRectMarker); got != want {
+		t.Errorf("incorrect number of rectangles; got = %d, want = %d", got, want)
+	}
+	if got := mockConn.b.Bytes(); !bytes.Equal(got, next) {
+		t.Errorf("incorrect data left unread; got = %v, want = %v", got, next)
+	}
+	conn.takePending()
+}
+
+func TestDesktopNamePseudoEncoding_Type(t *testing.T) {
+	e := &DesktopNamePseudoEncoding{}
+	if got, want := e.Type(), encodings.DesktopNamePseudo; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+}
+
+func TestDesktopNamePseudoEncoding_Read(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.setDesktopName("old")
+
+	for _, tt := range []struct {
+		data []byte
+		name string
+		ok   bool
+	}{
+		{[]byte{0, 0, 0, 6, 'r', 'e', 'n', 'a', 'm', 'e'}, "rename", true},
+		{[]byte{0, 0, 0, 5, 'd', 0xc3, 0xa9, 'j', 0xc3}, "", false},
+		{[]byte{0, 0, 0, 4, 'd', 0xc3, 0xa9, 'j'}, "déj", true},
+		{[]byte{0xff, 0xff, 0xff, 0xff}, "", false},
+	} {
+		mockConn.Reset()
+		if err := conn.send(tt.data); err != nil {
+			t.Fatal(err)
+		}
+		enc, err := (&DesktopNamePseudoEncoding{}).Read(conn, &Rectangle{})
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%v: expected error", tt.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tt.data, err)
+		}
+		if got, want := enc.(*DesktopNamePseudoEncoding).Name, tt.name; got != want {
+			t.Errorf("incorrect encoding name; got = %q, want = %q", got, want)
+		}
+		if got, want := conn.DesktopName(), tt.name; got != want {
+			t.Errorf("incorrect desktop name; got = %q, want = %q", got, want)
+		}
+		pending := conn.takePending()
+		if len(pending) != 1 || pending[0].Type() != messages.DesktopNameChange {
+			t.Fatalf("incorrect pending messages; got = %v", pending)
+		}
+		if got, want := pending[0].(*DesktopNameChange).Name, tt.name; got != want {
+			t.Errorf("incorrect change message name; got = %q, want = %q", got, want)
+		}
+	}
+}
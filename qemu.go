@@ -0,0 +1,331 @@
+/*
+Implementation of the QEMU extensions: extended key events and audio.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#qemu-client-message
+*/
+package vnc
+
+import (
+	"fmt"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/keys"
+	"github.com/phox/go-vnc/messages"
+)
+
+// QEMU message submessage types.
+const (
+	qemuExtendedKeyEvent uint8 = iota
+	qemuAudio
+)
+
+//-----------------------------------------------------------------------------
+// QEMU Extended Key Event
+//
+// The QEMU Extended Key Event message sends a raw XT scancode along with the
+// keysym, so that the server does not need to translate the keysym back to a
+// key with its own keyboard layout.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#qemu-extended-key-event-message
+
+// ExtendedKeyEventsSupported returns true once the server has indicated that
+// it supports QEMU extended key events, by sending a QEMU Extended Key Event
+// pseudo-encoded rectangle.
+func (c *ClientConn) ExtendedKeyEventsSupported() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.extendedKeyEvents
+}
+
+// QEMUExtendedKeyEventPseudoEncoding tells the server that the client supports
+// QEMU extended key events, and is sent back by servers that support them.
+type QEMUExtendedKeyEventPseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*QEMUExtendedKeyEventPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*QEMUExtendedKeyEventPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (*QEMUExtendedKeyEventPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	c.mu.Lock()
+	c.extendedKeyEvents = true
+	c.mu.Unlock()
+	return &QEMUExtendedKeyEventPseudoEncoding{}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*QEMUExtendedKeyEventPseudoEncoding) String() string {
+	return "QEMUExtendedKeyEventPseudoEncoding"
+}
+
+// Type implements the Encoding interface.
+func (*QEMUExtendedKeyEventPseudoEncoding) Type() encodings.Encoding {
+	return encodings.QEMUExtendedKeyEventPseudo
+}
+
+// QEMUExtendedKeyEventMessage holds the wire format message.
+type QEMUExtendedKeyEventMessage struct {
+	Msg      messages.ClientMessage // message-type
+	SubType  uint8                  // submessage-type
+	DownFlag uint16                 // down-flag
+	Key      keys.Key               // keysym
+	Keycode  uint32                 // keycode
+}
+
+// ExtendedKeyEvent indicates a key press or release, with both the keysym and
+// the XT scancode of the key, and sends it to the server. It requires the
+// server to support the QEMU Extended Key Event pseudo-encoding.
+//
+// The keysym may be zero if it is not known, in which case the server only
+// uses the scancode.
+func (c *ClientConn) ExtendedKeyEvent(key keys.Key, scancode keys.Scancode, down bool) error {
+	var downFlag uint16
+	if down {
+		downFlag = 1
+	}
+	msg := QEMUExtendedKeyEventMessage{messages.ClientQEMU, qemuExtendedKeyEvent, downFlag, key, scancode.QEMU()}
+	if err := c.send(msg); err != nil {
+		return err
+	}
+
+	settleUI()
+	return nil
+}
+
+// ScancodeKeyEvent indicates a key press or release. If the server supports
+// QEMU extended key events, then the key is sent with its scancode on a US
+// keyboard layout. Otherwise, or if the key has no scancode, it is sent with
+// KeyEvent.
+//
+// Scancodes do not say whether Shift is held, so keys that need it, such as
+// Exclaim or A, are pressed with the left Shift key, which is released again
+// straight after the press. A key held down therefore repeats unshifted. Shift
+// is left alone if the caller is holding either Shift key with
+// ScancodeKeyEvent.
+func (c *ClientConn) ScancodeKeyEvent(key keys.Key, down bool) error {
+	if !c.ExtendedKeyEventsSupported() {
+		return c.KeyEvent(key, down)
+	}
+	scancode, ok := keys.USScancode(key)
+	if !ok {
+		return c.KeyEvent(key, down)
+	}
+
+	c.mu.Lock()
+	switch key {
+	case keys.ShiftLeft, keys.ShiftRight:
+		if down {
+			if c.shiftKeys == nil {
+				c.shiftKeys = make(map[keys.Key]bool)
+			}
+			c.shiftKeys[key] = true
+		} else {
+			delete(c.shiftKeys, key)
+		}
+	}
+	shift := down && keys.USShifted(key) && len(c.shiftKeys) == 0
+	c.mu.Unlock()
+
+	if !shift {
+		return c.ExtendedKeyEvent(key, scancode, down)
+	}
+	shiftScancode, _ := keys.USScancode(keys.ShiftLeft)
+	if err := c.ExtendedKeyEvent(keys.ShiftLeft, shiftScancode, true); err != nil {
+		return err
+	}
+	if err := c.ExtendedKeyEvent(key, scancode, true); err != nil {
+		return err
+	}
+	return c.ExtendedKeyEvent(keys.ShiftLeft, shiftScancode, false)
+}
+
+//-----------------------------------------------------------------------------
+// QEMU Audio
+//
+// The QEMU Audio messages stream audio from the guest to the client as raw
+// PCM samples, in a format chosen by the client.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#qemu-audio-client-message
+
+// AudioSupported returns true once the server has indicated that it supports
+// QEMU audio, by sending a QEMU Audio pseudo-encoded rectangle.
+func (c *ClientConn) AudioSupported() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.audio
+}
+
+// QEMUAudioPseudoEncoding tells the server that the client supports QEMU
+// audio, and is sent back by servers that support it.
+type QEMUAudioPseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*QEMUAudioPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*QEMUAudioPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (*QEMUAudioPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	c.mu.Lock()
+	c.audio = true
+	c.mu.Unlock()
+	return &QEMUAudioPseudoEncoding{}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*QEMUAudioPseudoEncoding) String() string { return "QEMUAudioPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*QEMUAudioPseudoEncoding) Type() encodings.Encoding { return encodings.QEMUAudioPseudo }
+
+// SampleFormat is the format of a single audio sample.
+type SampleFormat uint8
+
+// Audio sample formats.
+const (
+	SampleU8 SampleFormat = iota
+	SampleS8
+	SampleU16
+	SampleS16
+	SampleU32
+	SampleS32
+)
+
+// Size returns the size of a sample in bytes.
+func (f SampleFormat) Size() int {
+	switch f {
+	case SampleU8, SampleS8:
+		return 1
+	case SampleU16, SampleS16:
+		return 2
+	default:
+		return 4
+	}
+}
+
+// AudioFormat describes a stream of interleaved PCM audio samples.
+type AudioFormat struct {
+	Sample    SampleFormat // sample-format
+	Channels  uint8        // nchannels
+	Frequency uint32       // frequency, in Hz
+}
+
+// QEMU audio operations sent by the client.
+const (
+	audioEnable uint16 = iota
+	audioDisable
+	audioSetFormat
+)
+
+// QEMUAudioMessage holds the wire format message, sans the audio format.
+type QEMUAudioMessage struct {
+	Msg       messages.ClientMessage // message-type
+	SubType   uint8                  // submessage-type
+	Operation uint16                 // operation
+}
+
+// EnableAudio asks the server to start or stop sending audio. It requires the
+// server to support the QEMU Audio pseudo-encoding. The audio format should be
+// set with SetAudioFormat before audio is enabled.
+func (c *ClientConn) EnableAudio(enable bool) error {
+	op := audioDisable
+	if enable {
+		op = audioEnable
+	}
+	return c.send(QEMUAudioMessage{messages.ClientQEMU, qemuAudio, op})
+}
+
+// SetAudioFormat sets the format of the audio sent by the server.
+func (c *ClientConn) SetAudioFormat(f AudioFormat) error {
+	if f.Sample > SampleS32 {
+		return fmt.Errorf("invalid sample format: %d", f.Sample)
+	}
+	if f.Channels != 1 && f.Channels != 2 {
+		return fmt.Errorf("invalid number of channels: %d", f.Channels)
+	}
+
+	buf := NewBuffer(nil)
+	if err := buf.Write(QEMUAudioMessage{messages.ClientQEMU, qemuAudio, audioSetFormat}); err != nil {
+		return err
+	}
+	if err := buf.Write(f); err != nil {
+		return err
+	}
+	return c.send(buf.Bytes())
+}
+
+// AudioOp is the operation of a QEMUAudio server message.
+type AudioOp uint16
+
+// QEMU audio operations sent by the server.
+const (
+	AudioEnd AudioOp = iota
+	AudioBegin
+	AudioData
+)
+
+// maxAudioDataSize is the maximum size of the samples of an AudioData
+// message. Servers send audio in small chunks, well within this.
+const maxAudioDataSize = 1 << 20
+
+// QEMUAudio represents the wire format message, sans message-type and
+// submessage-type.
+//
+// The samples of AudioData messages are also written to the AudioSink of the
+// ClientConfig, if it is set.
+type QEMUAudio struct {
+	Op   AudioOp
+	Data []byte // PCM samples, for AudioData.
+}
+
+// Verify that interfaces are honored.
+var _ ServerMessage = (*QEMUAudio)(nil)
+
+// Type implements the ServerMessage interface.
+func (*QEMUAudio) Type() messages.ServerMessage { return messages.ServerQEMU }
+
+// Read implements the ServerMessage interface.
+func (*QEMUAudio) Read(c *ClientConn) (ServerMessage, error) {
+	var subType uint8
+	if err := c.receive(&subType); err != nil {
+		return nil, err
+	}
+	if subType != qemuAudio {
+		return nil, fmt.Errorf("unsupported QEMU server message submessage-type: %d", subType)
+	}
+
+	var msg QEMUAudio
+	if err := c.receive(&msg.Op); err != nil {
+		return nil, err
+	}
+	switch msg.Op {
+	case AudioEnd, AudioBegin:
+	case AudioData:
+		var length uint32
+		if err := c.receive(&length); err != nil {
+			return nil, err
+		}
+		if length > maxAudioDataSize {
+			return nil, fmt.Errorf("audio data length %d exceeds %d", length, maxAudioDataSize)
+		}
+		msg.Data = make([]byte, length)
+		if err := c.receive(&msg.Data); err != nil {
+			return nil, err
+		}
+		if c.config.AudioSink != nil {
+			if _, err := c.config.AudioSink.Write(msg.Data); err != nil {
+				return nil, fmt.Errorf("unable to write audio: %s", err)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported audio operation: %d", msg.Op)
+	}
+
+	return &msg, nil
+}
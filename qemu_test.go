@@ -0,0 +1,237 @@
+package vnc
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/keys"
+	"github.com/phox/go-vnc/messages"
+)
+
+func TestQEMUExtendedKeyEventPseudoEncoding(t *testing.T) {
+	e := &QEMUExtendedKeyEventPseudoEncoding{}
+	if got, want := e.Type(), encodings.QEMUExtendedKeyEventPseudo; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	if conn.ExtendedKeyEventsSupported() {
+		t.Error("extended key events supported before pseudo-encoding was received")
+	}
+	if _, err := e.Read(conn, &Rectangle{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !conn.ExtendedKeyEventsSupported() {
+		t.Error("extended key events not supported after pseudo-encoding was received")
+	}
+}
+
+func TestExtendedKeyEvent(t *testing.T) {
+	defer SetSettle(Settle())
+	SetSettle(0)
+
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	for _, tt := range []struct {
+		key      keys.Key
+		scancode keys.Scancode
+		down     bool
+		msg      QEMUExtendedKeyEventMessage
+	}{
+		{keys.SmallA, 0x1e, true,
+			QEMUExtendedKeyEventMessage{messages.ClientQEMU, 0, 1, keys.SmallA, 0x1e}},
+		{keys.Up, 0xe048, false,
+			QEMUExtendedKeyEventMessage{messages.ClientQEMU, 0, 0, keys.Up, 0xc8}},
+	} {
+		mockConn.Reset()
+		if err := conn.ExtendedKeyEvent(tt.key, tt.scancode, tt.down); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var msg QEMUExtendedKeyEventMessage
+		if err := conn.receive(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg != tt.msg {
+			t.Errorf("incorrect message; got = %v, want = %v", msg, tt.msg)
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%d bytes left unread", mockConn.b.Len())
+		}
+	}
+}
+
+func TestScancodeKeyEvent(t *testing.T) {
+	defer SetSettle(Settle())
+	SetSettle(0)
+
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	// Without server support, a plain KeyEvent is sent.
+	if err := conn.ScancodeKeyEvent(keys.Return, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var msg KeyEventMessage
+	if err := conn.receive(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := msg.Msg, messages.KeyEvent; got != want {
+		t.Errorf("incorrect message-type; got = %v, want = %v", got, want)
+	}
+
+	// With server support, the US layout scancode is added.
+	conn.extendedKeyEvents = true
+	mockConn.Reset()
+	if err := conn.ScancodeKeyEvent(keys.Return, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var extMsg QEMUExtendedKeyEventMessage
+	if err := conn.receive(&extMsg); err != nil {
+		t.Fatal(err)
+	}
+	want := QEMUExtendedKeyEventMessage{messages.ClientQEMU, 0, 1, keys.Return, 0x1c}
+	if extMsg != want {
+		t.Errorf("incorrect message; got = %v, want = %v", extMsg, want)
+	}
+
+	// Keys that need Shift are pressed with it, unless it is already held.
+	for _, tt := range []struct {
+		desc   string
+		events []func() error
+		want   []QEMUExtendedKeyEventMessage
+	}{
+		{"shifted",
+			[]func() error{
+				func() error { return conn.ScancodeKeyEvent(keys.Exclaim, true) },
+				func() error { return conn.ScancodeKeyEvent(keys.Exclaim, false) },
+			},
+			[]QEMUExtendedKeyEventMessage{
+				{messages.ClientQEMU, 0, 1, keys.ShiftLeft, 0x2a},
+				{messages.ClientQEMU, 0, 1, keys.Exclaim, 0x02},
+				{messages.ClientQEMU, 0, 0, keys.ShiftLeft, 0x2a},
+				{messages.ClientQEMU, 0, 0, keys.Exclaim, 0x02},
+			}},
+		{"shift held",
+			[]func() error{
+				func() error { return conn.ScancodeKeyEvent(keys.ShiftRight, true) },
+				func() error { return conn.ScancodeKeyEvent(keys.A, true) },
+				func() error { return conn.ScancodeKeyEvent(keys.A, false) },
+				func() error { return conn.ScancodeKeyEvent(keys.ShiftRight, false) },
+			},
+			[]QEMUExtendedKeyEventMessage{
+				{messages.ClientQEMU, 0, 1, keys.ShiftRight, 0x36},
+				{messages.ClientQEMU, 0, 1, keys.A, 0x1e},
+				{messages.ClientQEMU, 0, 0, keys.A, 0x1e},
+				{messages.ClientQEMU, 0, 0, keys.ShiftRight, 0x36},
+			}},
+	} {
+		mockConn.Reset()
+		for _, fn := range tt.events {
+			if err := fn(); err != nil {
+				t.Fatalf("%s: unexpected error: %v", tt.desc, err)
+			}
+		}
+		for i, want := range tt.want {
+			var msg QEMUExtendedKeyEventMessage
+			if err := conn.receive(&msg); err != nil {
+				t.Fatalf("%s: %v", tt.desc, err)
+			}
+			if msg != want {
+				t.Errorf("%s: incorrect message %d; got = %v, want = %v", tt.desc, i, msg, want)
+			}
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%s: %d bytes left unread", tt.desc, mockConn.b.Len())
+		}
+	}
+}
+
+func TestQEMUAudioPseudoEncoding(t *testing.T) {
+	e := &QEMUAudioPseudoEncoding{}
+	if got, want := e.Type(), encodings.QEMUAudioPseudo; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	if _, err := e.Read(conn, &Rectangle{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !conn.AudioSupported() {
+		t.Error("audio not supported after pseudo-encoding was received")
+	}
+}
+
+func TestEnableAudio(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	f := AudioFormat{SampleS16, 2, 44100}
+	if err := conn.SetAudioFormat(f); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := conn.EnableAudio(true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := conn.EnableAudio(false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []byte{
+		255, 1, 0, 2, 3, 2, 0, 0, 0xac, 0x44, // set format
+		255, 1, 0, 0, // enable
+		255, 1, 0, 1, // disable
+	}
+	if got := mockConn.b.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("incorrect messages; got = %v, want = %v", got, want)
+	}
+
+	for _, f := range []AudioFormat{{SampleS32 + 1, 2, 44100}, {SampleS16, 3, 44100}} {
+		if err := conn.SetAudioFormat(f); err == nil {
+			t.Errorf("%v: expected error", f)
+		}
+	}
+}
+
+func TestQEMUAudio(t *testing.T) {
+	var sink bytes.Buffer
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{AudioSink: &sink})
+
+	for _, tt := range []struct {
+		data []byte
+		msg  *QEMUAudio
+		ok   bool
+	}{
+		{[]byte{1, 0, 1}, &QEMUAudio{Op: AudioBegin}, true},
+		{[]byte{1, 0, 2, 0, 0, 0, 4, 1, 2, 3, 4}, &QEMUAudio{AudioData, []byte{1, 2, 3, 4}}, true},
+		{[]byte{1, 0, 2, 0, 0, 0, 2, 5, 6}, &QEMUAudio{AudioData, []byte{5, 6}}, true},
+		{[]byte{1, 0, 0}, &QEMUAudio{Op: AudioEnd}, true},
+		{[]byte{1, 0, 2, 0xff, 0xff, 0xff, 0xff}, nil, false},
+		{[]byte{1, 0, 3}, nil, false},
+		{[]byte{2, 0, 0}, nil, false},
+	} {
+		mockConn.Reset()
+		if err := conn.send(tt.data); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := (&QEMUAudio{}).Read(conn)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%v: expected error", tt.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tt.data, err)
+		}
+		if !reflect.DeepEqual(msg, tt.msg) {
+			t.Errorf("%v: incorrect message; got = %v, want = %v", tt.data, msg, tt.msg)
+		}
+	}
+	if got, want := sink.Bytes(), []byte{1, 2, 3, 4, 5, 6}; !bytes.Equal(got, want) {
+		t.Errorf("incorrect audio sink data; got = %v, want = %v", got, want)
+	}
+}
@@ -4,6 +4,11 @@ package vnc
 
 import (
 	"crypto/des"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"net"
 )
 
 const (
@@ -175,3 +180,102 @@ func (p *ClientAuthVNC) encrypt(key string, bytes []byte) ([]byte, error) {
 
 	return crypted, nil
 }
+
+// ServerAuth implements a method of authenticating clients.
+type ServerAuth interface {
+	// SecurityType returns the byte identifier sent to the client to
+	// identify this authentication scheme.
+	SecurityType() uint8
+
+	// Handshake is called when the authentication handshake should be
+	// performed, as part of the general RFB handshake. It records the
+	// credentials presented by the client in the Credentials of the
+	// connection, and returns an error if they are not valid.
+	Handshake(*ServerConn) error
+}
+
+// Credentials describe how a client authenticated. They are passed to the
+// Authorizer of a ServerConfig.
+type Credentials struct {
+	// SecurityType is the negotiated security type.
+	SecurityType uint8
+
+	// VeNCryptSubType is the negotiated subtype, if the security type is
+	// VeNCrypt.
+	VeNCryptSubType uint32
+
+	// Username and Password are sent by clients using a VeNCrypt Plain
+	// subtype. VNC authentication proves the client knows the password
+	// without sending it, so they are not set for it. Password is cleared
+	// once the Authorizer has been called.
+	Username, Password string
+
+	// TLS is the state of the TLS connection, for the VeNCrypt TLS and X509
+	// subtypes. It holds the client certificates, if the tls.Config asked for
+	// them.
+	TLS *tls.ConnectionState
+}
+
+// An Authorizer decides whether a client that has authenticated may use the
+// server. It is called with the remote address of the client and the
+// credentials it presented, and denies access by returning an error, which is
+// sent to the client as the failure reason.
+type Authorizer func(addr net.Addr, creds *Credentials) error
+
+// ServerAuthNone is the "none" authentication. See 7.2.1.
+type ServerAuthNone struct{}
+
+// Verify that interfaces are honored.
+var _ ServerAuth = (*ServerAuthNone)(nil)
+
+func (*ServerAuthNone) SecurityType() uint8 {
+	return secTypeNone
+}
+
+func (*ServerAuthNone) Handshake(conn *ServerConn) error {
+	return nil
+}
+
+// ServerAuthVNC is the standard password authentication. See 7.2.2.
+//
+// Only the first eight characters of the password are used.
+type ServerAuthVNC struct {
+	Password string
+}
+
+// Verify that interfaces are honored.
+var _ ServerAuth = (*ServerAuthVNC)(nil)
+
+func (*ServerAuthVNC) SecurityType() uint8 {
+	return secTypeVNCAuth
+}
+
+func (auth *ServerAuthVNC) Handshake(conn *ServerConn) error {
+	return vncAuthHandshake(conn, auth.Password)
+}
+
+// vncAuthHandshake sends a random challenge to the client, and checks that
+// the response is the challenge encrypted with the password.
+func vncAuthHandshake(conn *ServerConn, password string) error {
+	var challenge vncAuthChallenge
+	if _, err := rand.Read(challenge[:]); err != nil {
+		return err
+	}
+	if err := conn.send(challenge); err != nil {
+		return err
+	}
+
+	var response vncAuthChallenge
+	if err := conn.receive(&response); err != nil {
+		return err
+	}
+
+	want, err := (&ClientAuthVNC{}).encrypt(password, challenge[:])
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(response[:], want) != 1 {
+		return fmt.Errorf("authentication failed")
+	}
+	return nil
+}
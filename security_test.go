@@ -1,10 +1,22 @@
 package vnc
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
 	"encoding/hex"
+	"fmt"
 	"io"
+	"math/big"
+	"net"
 	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/net/context"
 )
 
 func TestClientAuthNone_Impl(t *testing.T) {
@@ -97,3 +109,177 @@ func TestClientAuthVNC_encode(t *testing.T) {
 		}
 	}
 }
+
+// testTLSConfig returns a server TLS config with a self-signed certificate.
+func testTLSConfig(t *testing.T) *tls.Config {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+}
+
+// connectTest connects a client to a server for cfg, and returns the error
+// from Connect.
+func connectTest(t *testing.T, cfg *ServerConfig, ccfg *ClientConfig) error {
+	addr, stop := newTestServer(t, cfg)
+	defer stop()
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("error connecting to server: %s", err)
+	}
+	vc, err := Connect(context.Background(), nc, ccfg)
+	if err != nil {
+		nc.Close()
+		return err
+	}
+	vc.Close()
+	return nil
+}
+
+func TestServerAuth(t *testing.T) {
+	tlsConfig := testTLSConfig(t)
+
+	for _, tt := range []struct {
+		desc     string
+		auth     []ServerAuth
+		password string
+		deny     bool
+		creds    Credentials
+		ok       bool
+	}{
+		{"none", nil, "", false, Credentials{SecurityType: secTypeNone}, true},
+		{"vnc", []ServerAuth{&ServerAuthVNC{"secret"}}, "secret", false, Credentials{SecurityType: secTypeVNCAuth}, true},
+		{"vnc wrong password", []ServerAuth{&ServerAuthVNC{"secret"}}, "guess", false, Credentials{}, false},
+		{"vnc denied", []ServerAuth{&ServerAuthVNC{"secret"}}, "secret", true, Credentials{SecurityType: secTypeVNCAuth}, false},
+		{"vencrypt x509vnc", []ServerAuth{&ServerAuthVeNCrypt{Config: tlsConfig, Password: "secret"}}, "secret", false,
+			Credentials{SecurityType: secTypeVeNCrypt, VeNCryptSubType: VeNCryptX509Vnc}, true},
+		{"vencrypt x509vnc wrong password", []ServerAuth{&ServerAuthVeNCrypt{Config: tlsConfig, Password: "secret"}}, "guess", false,
+			Credentials{}, false},
+	} {
+		var creds *Credentials
+		cfg := NewServerConfig(8, 8)
+		cfg.Auth = tt.auth
+		cfg.Authorizer = func(addr net.Addr, c *Credentials) error {
+			if !strings.HasPrefix(addr.String(), "127.0.0.1:") {
+				t.Errorf("%s: incorrect address %v", tt.desc, addr)
+			}
+			creds = c
+			if tt.deny {
+				return fmt.Errorf("access denied")
+			}
+			return nil
+		}
+
+		err := connectTest(t, cfg, NewClientConfig(tt.password))
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if tt.deny && (err == nil || !strings.Contains(err.Error(), "access denied")) {
+			t.Errorf("%s: expected the failure reason; got = %v", tt.desc, err)
+		}
+		if tt.creds.SecurityType == 0 {
+			if creds != nil {
+				t.Errorf("%s: expected Authorizer not to be called", tt.desc)
+			}
+			continue
+		}
+		if creds == nil {
+			t.Fatalf("%s: expected Authorizer to be called", tt.desc)
+		}
+		if creds.SecurityType != tt.creds.SecurityType || creds.VeNCryptSubType != tt.creds.VeNCryptSubType {
+			t.Errorf("%s: incorrect credentials; got = %+v, want = %+v", tt.desc, creds, tt.creds)
+		}
+		if got, want := creds.TLS != nil, tt.creds.SecurityType == secTypeVeNCrypt; got != want {
+			t.Errorf("%s: incorrect TLS state; got = %v, want = %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestServerAuthVeNCrypt_Plain(t *testing.T) {
+	for _, tt := range []struct {
+		desc       string
+		authorizer Authorizer
+		ok         bool
+	}{
+		{"no authorizer", nil, false},
+		{"authorized", func(addr net.Addr, c *Credentials) error {
+			if c.Username != "user" || c.Password != "pass" {
+				return fmt.Errorf("invalid credentials")
+			}
+			return nil
+		}, true},
+	} {
+		cfg := NewServerConfig(8, 8)
+		cfg.Auth = []ServerAuth{&ServerAuthVeNCrypt{SubTypes: []uint32{VeNCryptPlain}}}
+		cfg.Authorizer = tt.authorizer
+		cfg.DesktopName = "test"
+
+		client, server := net.Pipe()
+		errc := make(chan error, 1)
+		go func() {
+			conn, err := Accept(context.Background(), server, cfg)
+			if err == nil {
+				if got := conn.Credentials(); got.Username != "user" || got.Password != "" {
+					err = fmt.Errorf("incorrect credentials: %+v", got)
+				}
+			}
+			errc <- err
+		}()
+
+		// Act as an RFB 3.8 client choosing the Plain subtype.
+		read := func(n int) []byte {
+			b := make([]byte, n)
+			if _, err := io.ReadFull(client, b); err != nil {
+				t.Fatalf("%s: read error: %v", tt.desc, err)
+			}
+			return b
+		}
+		write := func(data interface{}) {
+			if err := binary.Write(client, binary.BigEndian, data); err != nil {
+				t.Fatalf("%s: write error: %v", tt.desc, err)
+			}
+		}
+		read(pvLen)
+		write([]byte(PROTO_VERS_3_8))
+		if got := read(2); got[0] != 1 || got[1] != secTypeVeNCrypt {
+			t.Fatalf("%s: incorrect security types %v", tt.desc, got)
+		}
+		write(secTypeVeNCrypt)
+		read(2) // version
+		write([2]uint8{0, 2})
+		if got := read(1); got[0] != 0 {
+			t.Fatalf("%s: version not accepted", tt.desc)
+		}
+		if got := read(5); got[0] != 1 || binary.BigEndian.Uint32(got[1:]) != VeNCryptPlain {
+			t.Fatalf("%s: incorrect subtypes %v", tt.desc, got)
+		}
+		write(VeNCryptPlain)
+		write([2]uint32{4, 4})
+		write([]byte("userpass"))
+
+		result := binary.BigEndian.Uint32(read(4))
+		if got, want := result == 0, tt.ok; got != want {
+			t.Errorf("%s: incorrect SecurityResult %d", tt.desc, result)
+		}
+		if result == 0 {
+			write([]byte{1}) // ClientInit
+			read(24 + len(cfg.DesktopName))
+		} else {
+			read(int(binary.BigEndian.Uint32(read(4))))
+		}
+		if err := <-errc; (err == nil) != tt.ok {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		client.Close()
+	}
+}
@@ -7,6 +7,8 @@ package vnc
 import (
 	"fmt"
 	"image"
+	"image/color"
+	"unicode"
 
 	"github.com/phox/go-vnc/encodings"
 	"github.com/phox/go-vnc/messages"
@@ -60,6 +62,8 @@ func (m *FramebufferUpdate) Read(c *ClientConn) (ServerMessage, error) {
 	// }
 	// encs[Raw] = &RawEncoding{} // Raw encoding support required.
 
+	start, startBytes := timeNow(), c.metrics["bytes-received"].Value()
+
 	// Read packet.
 	var pad [1]byte
 	if err := c.receive(&pad); err != nil {
@@ -71,15 +75,32 @@ func (m *FramebufferUpdate) Read(c *ClientConn) (ServerMessage, error) {
 		return nil, err
 	}
 
-	// Extract rectangles.
-	rects := make([]Rectangle, numRects)
-	for i := 0; i < int(numRects); i++ {
+	// Extract rectangles. Servers that don't know the number of rectangles in
+	// advance end the update with a LastRect rectangle instead, if the client
+	// supports it.
+	untilLastRect := false
+	if numRects == lastRectMarker {
+		_, untilLastRect = c.Encodable(encodings.LastRectPseudo)
+	}
+	var rects []Rectangle
+	if !untilLastRect {
+		rects = make([]Rectangle, 0, numRects)
+	}
+	for i := 0; untilLastRect || i < int(numRects); i++ {
 		rect := NewRectangle(c.Encodable)
 		if err := rect.Read(c); err != nil {
 			return nil, err
 		}
-		rects[i] = *rect
+		if _, ok := rect.Enc.(*LastRectPseudoEncoding); ok {
+			break
+		}
+		rects = append(rects, *rect)
+	}
+	var n uint64
+	if end := c.metrics["bytes-received"].Value(); end > startBytes {
+		n = end - startBytes
 	}
+	c.updateReceived(start, n)
 
 	return newFramebufferUpdate(rects), nil
 }
@@ -120,7 +141,7 @@ type EncodableFunc func(enc encodings.Encoding) (Encoding, bool)
 // Encodable returns the Encoding that can be used to encode a Rectangle, or
 // false if the encoding isn't recognized.
 func (c *ClientConn) Encodable(enc encodings.Encoding) (Encoding, bool) {
-	for _, e := range c.encodings {
+	for _, e := range c.Encodings() {
 		if e.Type() == enc {
 			return e, true
 		}
@@ -128,6 +149,18 @@ func (c *ClientConn) Encodable(enc encodings.Encoding) (Encoding, bool) {
 	return nil, false
 }
 
+// pixelEncoding reports whether rectangles of the encoding enc hold pixels of
+// the framebuffer, rather than being pseudo-encodings.
+func pixelEncoding(enc encodings.Encoding) bool {
+	switch enc {
+	case encodings.Raw, encodings.CopyRect, encodings.RRE, encodings.CoRRE,
+		encodings.Hextile, encodings.Zlib, encodings.Tight, encodings.ZlibHex,
+		encodings.TRLE, encodings.ZRLE:
+		return true
+	}
+	return false
+}
+
 // rectangleMessage holds a Rectangle wire format message.
 type rectangleMessage struct {
 	X, Y uint16             // x-, y-position
@@ -165,6 +198,12 @@ func (r *Rectangle) Read(c *ClientConn) error {
 		return fmt.Errorf("unsupported encoding type: %d", msg.E)
 	}
 
+	// Decoders allocate for the whole rectangle before reading its pixels, so
+	// a rectangle that doesn't fit the framebuffer is rejected up front.
+	if pixelEncoding(msg.E) && !r.bounds().In(c.fb.Bounds()) {
+		return fmt.Errorf("rectangle %v is outside of the framebuffer %v", r.bounds(), c.fb.Bounds())
+	}
+
 	enc, err := encImpl.Read(c, r)
 	if err != nil {
 		return fmt.Errorf("error reading rectangle encoding: %s", err)
@@ -209,6 +248,24 @@ func (r *Rectangle) Unmarshal(data []byte) error {
 	switch msg.E {
 	case encodings.Raw:
 		r.Enc = &RawEncoding{}
+	case encodings.CopyRect:
+		r.Enc = &CopyRectEncoding{}
+	case encodings.RRE:
+		r.Enc = &RREEncoding{}
+	case encodings.CoRRE:
+		r.Enc = &CoRREEncoding{}
+	case encodings.Hextile:
+		r.Enc = &HextileEncoding{}
+	case encodings.Zlib:
+		r.Enc = &ZlibEncoding{}
+	case encodings.Tight:
+		r.Enc = &TightEncoding{}
+	case encodings.ZlibHex:
+		r.Enc = &ZlibHexEncoding{}
+	case encodings.TRLE:
+		r.Enc = &TRLEEncoding{}
+	case encodings.ZRLE:
+		r.Enc = &ZRLEEncoding{}
 	default:
 		return fmt.Errorf("unable to unmarshal encoding %v", msg.E)
 	}
@@ -223,6 +280,11 @@ func (r *Rectangle) String() string {
 // Area returns the total area in pixels of the Rectangle.
 func (r *Rectangle) Area() int { return int(r.Width) * int(r.Height) }
 
+// bounds returns the area of the framebuffer covered by the Rectangle.
+func (r *Rectangle) bounds() image.Rectangle {
+	return image.Rect(int(r.X), int(r.Y), int(r.X)+int(r.Width), int(r.Y)+int(r.Height))
+}
+
 //-----------------------------------------------------------------------------
 // SetColorMapEntries is sent by the server to set values into
 // the color map. This message will automatically update the color map
@@ -263,20 +325,51 @@ func (*SetColorMapEntries) Read(c *ClientConn) (ServerMessage, error) {
 		return nil, err
 	}
 
+	if int(result.FirstColor)+int(numColors) > len(c.colorMap) {
+		return nil, fmt.Errorf("color map entries %d to %d exceed the %d entries of the color map",
+			result.FirstColor, int(result.FirstColor)+int(numColors)-1, len(c.colorMap))
+	}
+
 	result.Colors = make([]Color, numColors)
 	for i := uint16(0); i < numColors; i++ {
-		color := &result.Colors[i]
-		if err := c.receive(&color); err != nil {
+		var rgb [3]uint16 // red, green, blue
+		if err := c.receive(&rgb); err != nil {
 			return nil, err
 		}
+		color := &result.Colors[i]
+		color.R, color.G, color.B = rgb[0], rgb[1], rgb[2]
 
 		// Update the connection's color map
-		c.colorMap[result.FirstColor+i] = *color
+		c.colorMap[uint8(result.FirstColor+i)] = *color
 	}
 
 	return &result, nil
 }
 
+// Marshal implements the Marshaler interface.
+func (m *SetColorMapEntries) Marshal() ([]byte, error) {
+	buf := NewBuffer(nil)
+	msg := struct {
+		Msg        messages.ServerMessage // message-type
+		_          [1]byte                // padding
+		FirstColor uint16                 // first-color
+		NumColors  uint16                 // number-of-colors
+	}{
+		Msg:        messages.SetColorMapEntries,
+		FirstColor: m.FirstColor,
+		NumColors:  uint16(len(m.Colors)),
+	}
+	if err := buf.Write(msg); err != nil {
+		return nil, err
+	}
+	for _, c := range m.Colors {
+		if err := buf.Write([3]uint16{c.R, c.G, c.B}); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
 // Color represents a single color in a color map.
 type Color struct {
 	pf      *PixelFormat
@@ -287,6 +380,7 @@ type Color struct {
 
 // Verify that interfaces are honored.
 var _ MarshalerUnmarshaler = (*Color)(nil)
+var _ color.Color = (*Color)(nil)
 
 // ColorMap represents a translation map of colors.
 type ColorMap [256]Color
@@ -331,18 +425,7 @@ func (c *Color) Unmarshal(data []byte) error {
 		return nil
 	}
 
-	order := c.pf.order()
-
-	var pixel uint32
-	switch c.pf.BPP {
-	case 8:
-		pixel = uint32(data[0])
-	case 16:
-		pixel = uint32(order.Uint16(data))
-	case 32:
-		pixel = order.Uint32(data)
-	}
-
+	pixel := c.pf.pixel(data[:c.pf.bytesPerPixel()])
 	if rfbflags.IsTrueColor(c.pf.TrueColor) {
 		c.R = uint16((pixel >> c.pf.RedShift) & uint32(c.pf.RedMax))
 		c.G = uint16((pixel >> c.pf.GreenShift) & uint32(c.pf.GreenMax))
@@ -355,21 +438,27 @@ func (c *Color) Unmarshal(data []byte) error {
 	return nil
 }
 
-func colorsToImage(x, y, width, height uint16, colors []Color) *image.RGBA64 {
-	rect := image.Rect(int(x), int(y), int(x+width), int(y+height))
-	rgba := image.NewRGBA64(rect)
-	a := uint16(1)
-	for i, color := range colors {
-		rgba.Pix[4*i+0] = uint8(color.R >> 8)
-		rgba.Pix[4*i+1] = uint8(color.R)
-		rgba.Pix[4*i+2] = uint8(color.G >> 8)
-		rgba.Pix[4*i+3] = uint8(color.G)
-		rgba.Pix[4*i+4] = uint8(color.B >> 8)
-		rgba.Pix[4*i+5] = uint8(color.B)
-		rgba.Pix[4*i+6] = uint8(a >> 8)
-		rgba.Pix[4*i+7] = uint8(a)
+// RGBA implements the color.Color interface. True color values are scaled
+// from the pixel format maximums; color map entries are already 16-bit.
+func (c *Color) RGBA() (r, g, b, a uint32) {
+	if c.pf == nil || !rfbflags.IsTrueColor(c.pf.TrueColor) {
+		return uint32(c.R), uint32(c.G), uint32(c.B), 0xffff
 	}
-	return rgba
+	return scaleColor(c.R, c.pf.RedMax), scaleColor(c.G, c.pf.GreenMax), scaleColor(c.B, c.pf.BlueMax), 0xffff
+}
+
+// scaleColor scales a color value in the range [0, max] to [0, 0xffff].
+func scaleColor(v, max uint16) uint32 {
+	if max == 0 {
+		return 0
+	}
+	return uint32(v) * 0xffff / uint32(max)
+}
+
+// unscaleColor scales a color value in the range [0, 0xffff] to [0, max],
+// rounding to the nearest value.
+func unscaleColor(v uint32, max uint16) uint16 {
+	return uint16((v*uint32(max) + 0x7fff) / 0xffff)
 }
 
 //-----------------------------------------------------------------------------
@@ -392,6 +481,11 @@ func (*Bell) Read(c *ClientConn) (ServerMessage, error) {
 	return &Bell{}, nil
 }
 
+// Marshal implements the Marshaler interface.
+func (*Bell) Marshal() ([]byte, error) {
+	return []byte{uint8(messages.Bell)}, nil
+}
+
 //-----------------------------------------------------------------------------
 // ServerCutText indicates the server has new text in the cut buffer.
 //
@@ -400,8 +494,13 @@ func (*Bell) Read(c *ClientConn) (ServerMessage, error) {
 
 // ServerCutText represents the wire format message, sans message-type and
 // padding.
+//
+// If the server supports the Extended Clipboard, then Extended holds the
+// message, and Text holds the plain text it provides, if any. Otherwise, Text
+// holds the Latin-1 text decoded from the message.
 type ServerCutText struct {
-	Text string
+	Text     string
+	Extended *ExtendedClipboard
 }
 
 // Verify that interfaces are honored.
@@ -413,20 +512,77 @@ func (*ServerCutText) Type() messages.ServerMessage { return messages.ServerCutT
 // Read implements the ServerMessage interface.
 func (*ServerCutText) Read(c *ClientConn) (ServerMessage, error) {
 	// Read off the padding
-	var padding [1]byte
+	var padding [3]byte
 	if err := c.receive(&padding); err != nil {
 		return nil, err
 	}
 
-	var textLength uint32
+	var textLength int32
 	if err := c.receive(&textLength); err != nil {
 		return nil, err
 	}
 
+	// A negative length marks an Extended Clipboard message.
+	if textLength < 0 {
+		msg, err := c.readExtendedClipboard(uint32(-int64(textLength)))
+		if err != nil {
+			return nil, err
+		}
+		return &ServerCutText{Text: msg.Data[ClipboardText], Extended: msg}, nil
+	}
+
 	textBytes := make([]uint8, textLength)
 	if err := c.receive(&textBytes); err != nil {
 		return nil, err
 	}
 
-	return &ServerCutText{string(textBytes)}, nil
+	return &ServerCutText{Text: latin1ToString(textBytes)}, nil
+}
+
+// Marshal implements the Marshaler interface. The text must only contain
+// Latin-1 characters.
+func (m *ServerCutText) Marshal() ([]byte, error) {
+	text, err := stringToLatin1(m.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := NewBuffer(nil)
+	msg := struct {
+		Msg    messages.ServerMessage // message-type
+		_      [3]byte                // padding
+		Length uint32                 // length
+	}{
+		Msg:    messages.ServerCutText,
+		Length: uint32(len(text)),
+	}
+	if err := buf.Write(msg); err != nil {
+		return nil, err
+	}
+	if err := buf.Write(text); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// latin1ToString decodes Latin-1 text.
+func latin1ToString(b []byte) string {
+	text := make([]rune, len(b))
+	for i, c := range b {
+		text[i] = rune(c)
+	}
+	return string(text)
+}
+
+// stringToLatin1 encodes text as Latin-1, which can only hold characters up
+// to unicode.MaxLatin1.
+func stringToLatin1(s string) ([]byte, error) {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > unicode.MaxLatin1 {
+			return nil, NewVNCError(fmt.Sprintf("Character %q is not valid Latin-1", r))
+		}
+		b = append(b, byte(r))
+	}
+	return b, nil
 }
@@ -54,6 +54,52 @@ func TestRectangle_Unmarshal(t *testing.T) {
 	}
 }
 
+func TestRectangle_ReadOutsideFramebuffer(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		enc  Encoding
+		msg  rectangleMessage
+		ok   bool
+	}{
+		{"raw past the right edge", &RawEncoding{},
+			rectangleMessage{X: 6, Y: 0, W: 5, H: 1, E: encodings.Raw}, false},
+		{"trle past the bottom edge", &TRLEEncoding{},
+			rectangleMessage{X: 0, Y: 9, W: 1, H: 2, E: encodings.TRLE}, false},
+		{"zrle of the largest size", &ZRLEEncoding{},
+			rectangleMessage{X: 0, Y: 0, W: 0xffff, H: 0xffff, E: encodings.ZRLE}, false},
+		{"hextile of the largest size", &HextileEncoding{},
+			rectangleMessage{X: 0, Y: 0, W: 0xffff, H: 0xffff, E: encodings.Hextile}, false},
+		{"zlib of the largest size", &ZlibEncoding{},
+			rectangleMessage{X: 0, Y: 0, W: 0xffff, H: 0xffff, E: encodings.Zlib}, false},
+		{"zlibhex past the bottom right corner", &ZlibHexEncoding{},
+			rectangleMessage{X: 8, Y: 8, W: 4, H: 4, E: encodings.ZlibHex}, false},
+		{"desktop size larger than the framebuffer", &DesktopSizePseudoEncoding{},
+			rectangleMessage{X: 0, Y: 0, W: 20, H: 20, E: encodings.DesktopSizePseudo}, true},
+	} {
+		mockConn := &MockConn{}
+		conn := NewClientConn(mockConn, &ClientConfig{})
+		conn.encodings = Encodings{tt.enc}
+		conn.fb = NewFramebuffer(10, 10)
+		if err := conn.send(tt.msg); err != nil {
+			t.Fatal(err)
+		}
+
+		err := NewRectangle(conn.Encodable).Read(conn)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.desc)
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%s: %d bytes left unread", tt.desc, mockConn.b.Len())
+		}
+	}
+}
+
 // TODO(kward): need to read encodings in addition to rectangles.
 func TestFramebufferUpdate(t *testing.T) {
 	mockConn := &MockConn{}
@@ -61,6 +107,7 @@ func TestFramebufferUpdate(t *testing.T) {
 	// Use empty PixelFormat so that the BPP is zero, and rects won't be read.
 	// TODO(kward): give some real rectangles so this hack isn't necessary.
 	conn.pixelFormat = PixelFormat{}
+	conn.fb = NewFramebuffer(10, 10)
 
 	for _, tt := range []struct {
 		desc  string
@@ -217,8 +264,92 @@ func TestColor_Unmarshal(t *testing.T) {
 	}
 }
 
-func TestSetColorMapEntries(t *testing.T) {}
+func TestSetColorMapEntries(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	msg := &SetColorMapEntries{FirstColor: 3, Colors: []Color{{R: 1, G: 2, B: 3}, {R: 0xffff, G: 0x8000, B: 0}}}
+	data, err := msg.Marshal()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := conn.send(data[1:]); err != nil { // Skip the message-type.
+		t.Fatal(err)
+	}
+	got, err := (&SetColorMapEntries{}).Read(conn)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := got.(*SetColorMapEntries).FirstColor, msg.FirstColor; got != want {
+		t.Errorf("incorrect first color; got = %v, want = %v", got, want)
+	}
+	for i, want := range msg.Colors {
+		if got := conn.colorMap[3+i]; got.R != want.R || got.G != want.G || got.B != want.B {
+			t.Errorf("incorrect color map entry %d; got = %v, want = %v", 3+i, got, want)
+		}
+	}
+}
+
+func TestSetColorMapEntries_TooMany(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	// Entries 255 and 256, the second of which is past the end of the map.
+	msg := &SetColorMapEntries{FirstColor: 255, Colors: []Color{{R: 1}, {G: 2}}}
+	data, err := msg.Marshal()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := conn.send(data[1:]); err != nil { // Skip the message-type.
+		t.Fatal(err)
+	}
+	if _, err := (&SetColorMapEntries{}).Read(conn); err == nil {
+		t.Error("expected error for color map entries past the end of the map")
+	}
+	if got := conn.colorMap[0]; got != (Color{}) {
+		t.Errorf("unexpected color map entry 0; got = %v", got)
+	}
+}
 
 func TestBell(t *testing.T) {}
 
-func TestServerCutText(t *testing.T) {}
+func TestServerCutText(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	for _, tt := range []struct {
+		text []byte
+		want string
+	}{
+		{[]byte{}, ""},
+		{[]byte("abc\n123"), "abc\n123"},
+		{[]byte{'c', 'a', 'f', 0xe9}, "café"},
+	} {
+		mockConn.Reset()
+		if err := conn.send([4]byte{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.send(uint32(len(tt.text))); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.send(tt.text); err != nil {
+			t.Fatal(err)
+		}
+		// Skip the message-type.
+		var msgType uint8
+		if err := conn.receive(&msgType); err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := (&ServerCutText{}).Read(conn)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := msg.(*ServerCutText); got.Text != tt.want || got.Extended != nil {
+			t.Errorf("incorrect message; got = %+v, want text %q", got, tt.want)
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%d unread bytes", mockConn.b.Len())
+		}
+	}
+}
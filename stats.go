@@ -0,0 +1,216 @@
+// Estimation of the connection round-trip time and throughput.
+
+package vnc
+
+import (
+	"bytes"
+	"time"
+)
+
+// timeNow returns the current time. It is replaced in tests.
+var timeNow = time.Now
+
+const (
+	// The interval between fences sent to measure the round-trip time.
+	rttProbeInterval = time.Second
+
+	// The minimum size of a FramebufferUpdate used to estimate throughput.
+	// Smaller updates are dominated by latency rather than bandwidth.
+	minBandwidthSample = 16 * 1024
+)
+
+// rttProbePayload is the payload of fences sent to measure the round-trip time.
+var rttProbePayload = []byte("go-vnc rtt")
+
+// Stats holds estimates of the connection round-trip time and throughput.
+//
+// When the server supports fences, the round-trip time is measured with
+// periodic Fence messages. Otherwise, it is measured from the time between a
+// non-incremental FramebufferUpdateRequest and the start of the
+// FramebufferUpdate that answers it, which includes the time the server takes
+// to encode the update. Incremental requests are not used, as the server may
+// wait for the framebuffer to change before answering them.
+//
+// The throughput is measured from the time taken to receive large
+// FramebufferUpdate messages.
+type Stats struct {
+	// RTT is the smoothed round-trip time, or zero if it hasn't been measured.
+	RTT time.Duration
+
+	// Bandwidth is the smoothed throughput in bytes per second, or zero if it
+	// hasn't been measured.
+	Bandwidth uint64
+}
+
+// statsTracker holds the state used to estimate the connection Stats.
+type statsTracker struct {
+	Stats
+
+	fences    bool      // The server supports fences.
+	requested time.Time // When the outstanding update request was sent.
+	probe     time.Time // When the outstanding RTT fence was sent.
+	lastProbe time.Time // When the last RTT fence was sent.
+}
+
+// Stats returns the current estimates of the connection round-trip time and
+// throughput. They are also available as the "rtt-microseconds" and
+// "bandwidth-bytes-per-second" metrics.
+func (c *ClientConn) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats.Stats
+}
+
+// smooth returns an exponentially weighted moving average, weighting the new
+// sample by 1/8 as TCP does.
+func smooth(avg, sample uint64) uint64 {
+	if avg == 0 {
+		return sample
+	}
+	return avg - avg/8 + sample/8
+}
+
+// addRTTSample updates the round-trip time estimate.
+func (c *ClientConn) addRTTSample(rtt time.Duration) {
+	c.mu.Lock()
+	c.stats.RTT = time.Duration(smooth(uint64(c.stats.RTT), uint64(rtt)))
+	v := c.stats.RTT.Nanoseconds() / 1000
+	c.mu.Unlock()
+
+	c.metrics["rtt-microseconds"].Reset()
+	c.metrics["rtt-microseconds"].Adjust(v)
+}
+
+// addBandwidthSample updates the throughput estimate.
+func (c *ClientConn) addBandwidthSample(bytes uint64, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.stats.Bandwidth = smooth(c.stats.Bandwidth, bytes*uint64(time.Second)/uint64(d))
+	v := c.stats.Bandwidth
+	c.mu.Unlock()
+
+	c.metrics["bandwidth-bytes-per-second"].Reset()
+	c.metrics["bandwidth-bytes-per-second"].Adjust(int64(v))
+
+	if c.config.AdaptiveQuality {
+		c.adaptQuality(v)
+	}
+}
+
+// updateRequested records that a FramebufferUpdateRequest was sent.
+func (c *ClientConn) updateRequested(incremental bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !incremental && c.stats.requested.IsZero() {
+		c.stats.requested = timeNow()
+	}
+}
+
+// updateReceived records that a FramebufferUpdate of n bytes, which started
+// arriving at start, has been read.
+func (c *ClientConn) updateReceived(start time.Time, n uint64) {
+	c.mu.Lock()
+	requested, fences := c.stats.requested, c.stats.fences
+	c.stats.requested = time.Time{}
+	c.mu.Unlock()
+
+	if !fences && !requested.IsZero() {
+		c.addRTTSample(start.Sub(requested))
+	}
+	if n >= minBandwidthSample {
+		c.addBandwidthSample(n, timeNow().Sub(start))
+	}
+	if fences {
+		c.probeRTT()
+	}
+}
+
+// probeRTT sends a fence to measure the round-trip time, unless one is
+// outstanding or was sent recently.
+func (c *ClientConn) probeRTT() {
+	c.mu.Lock()
+	now := timeNow()
+	if !c.stats.probe.IsZero() || now.Sub(c.stats.lastProbe) < rttProbeInterval {
+		c.mu.Unlock()
+		return
+	}
+	c.stats.probe, c.stats.lastProbe = now, now
+	c.mu.Unlock()
+
+	if err := c.Fence(FenceRequest|FenceBlockBefore, rttProbePayload); err != nil {
+		c.mu.Lock()
+		c.stats.probe = time.Time{}
+		c.mu.Unlock()
+	}
+}
+
+// fenceReceived records that a fence was received from the server, and
+// measures the round-trip time if it answers an RTT fence.
+func (c *ClientConn) fenceReceived(flags FenceFlags, payload []byte) {
+	c.mu.Lock()
+	c.stats.fences = true
+	probe := c.stats.probe
+	answered := flags&FenceRequest == 0 && !probe.IsZero() && bytes.Equal(payload, rttProbePayload)
+	if answered {
+		c.stats.probe = time.Time{}
+	}
+	c.mu.Unlock()
+
+	if answered {
+		c.addRTTSample(timeNow().Sub(probe))
+	}
+}
+
+// qualityLevels maps minimum throughputs, in bytes per second, to the JPEG
+// quality level to use for them.
+var qualityLevels = []struct {
+	bandwidth uint64
+	level     uint8
+}{
+	{2000000, 9},
+	{1000000, 8},
+	{500000, 7},
+	{250000, 6},
+	{125000, 5},
+	{64000, 4},
+	{32000, 3},
+	{0, 2},
+}
+
+// adaptQuality replaces the JPEG quality level advertised with the one that
+// suits the throughput. Nothing is changed unless a quality level is already
+// advertised, as JPEG is only used by servers when one is. It holds
+// setEncodingsMu, so that encodings set concurrently with SetEncodings are not
+// overwritten.
+func (c *ClientConn) adaptQuality(bandwidth uint64) {
+	var level uint8
+	for _, q := range qualityLevels {
+		if bandwidth >= q.bandwidth {
+			level = q.level
+			break
+		}
+	}
+
+	c.setEncodingsMu.Lock()
+	defer c.setEncodingsMu.Unlock()
+
+	encs := append(Encodings(nil), c.Encodings()...)
+	found := false
+	for i, e := range encs {
+		if q, ok := e.(*QualityLevelPseudoEncoding); ok {
+			if q.Level == level {
+				return
+			}
+			encs[i] = &QualityLevelPseudoEncoding{level}
+			found = true
+		}
+	}
+	if !found {
+		return
+	}
+	if err := c.setEncodings(encs); err != nil && c.log != nil {
+		c.log.Printf("error setting quality level: %v", err)
+	}
+}
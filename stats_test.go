@@ -0,0 +1,251 @@
+package vnc
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/rfbflags"
+)
+
+// fakeClock replaces timeNow with a clock that advances by step on each call,
+// and returns a function that restores it.
+func fakeClock(now *time.Time, step time.Duration) func() {
+	timeNow = func() time.Time {
+		t := *now
+		*now = now.Add(step)
+		return t
+	}
+	return func() { timeNow = time.Now }
+}
+
+func TestStats_UpdateRequest(t *testing.T) {
+	now := time.Unix(0, 0)
+	defer fakeClock(&now, 0)()
+
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat{}
+
+	// Incremental requests may be answered only once the framebuffer changes,
+	// so they are not used to measure the RTT.
+	if err := conn.FramebufferUpdateRequest(rfbflags.RFBTrue, 0, 0, 10, 10); err != nil {
+		t.Fatal(err)
+	}
+	mockConn.Reset()
+	now = now.Add(time.Second)
+
+	// An empty FramebufferUpdate, sans message-type.
+	if err := conn.send([]byte{0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&FramebufferUpdate{}).Read(conn); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := conn.Stats().RTT, time.Duration(0); got != want {
+		t.Errorf("incorrect RTT for incremental request; got = %v, want = %v", got, want)
+	}
+
+	if err := conn.FramebufferUpdateRequest(rfbflags.RFBFalse, 0, 0, 10, 10); err != nil {
+		t.Fatal(err)
+	}
+	mockConn.Reset()
+	now = now.Add(50 * time.Millisecond)
+
+	if err := conn.send([]byte{0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&FramebufferUpdate{}).Read(conn); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, want := conn.Stats().RTT, 50*time.Millisecond; got != want {
+		t.Errorf("incorrect RTT; got = %v, want = %v", got, want)
+	}
+	if got, want := conn.metrics["rtt-microseconds"].Value(), uint64(50000); got != want {
+		t.Errorf("incorrect rtt-microseconds metric; got = %v, want = %v", got, want)
+	}
+	if got, want := conn.Stats().Bandwidth, uint64(0); got != want {
+		t.Errorf("incorrect bandwidth for small update; got = %v, want = %v", got, want)
+	}
+}
+
+func TestStats_Bandwidth(t *testing.T) {
+	now := time.Unix(0, 0)
+	defer fakeClock(&now, 100*time.Millisecond)()
+
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat{BPP: 32, Depth: 24, BigEndian: RFBTrue, TrueColor: RFBTrue,
+		RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0}
+	conn.fb = NewFramebuffer(128, 64)
+
+	// A FramebufferUpdate, sans message-type, with a 32 KiB Raw rectangle.
+	data := []byte{0, 0, 1, 0, 0, 0, 0, 0, 128, 0, 64, 0, 0, 0, 0}
+	data = append(data, make([]byte, 128*64*4)...)
+	if err := conn.send(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&FramebufferUpdate{}).Read(conn); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The update took one clock step to receive.
+	want := uint64(len(data)) * 10
+	if got := conn.Stats().Bandwidth; got != want {
+		t.Errorf("incorrect bandwidth; got = %v, want = %v", got, want)
+	}
+	if got := conn.metrics["bandwidth-bytes-per-second"].Value(); got != want {
+		t.Errorf("incorrect bandwidth-bytes-per-second metric; got = %v, want = %v", got, want)
+	}
+}
+
+func TestStats_Fence(t *testing.T) {
+	now := time.Unix(100, 0)
+	defer fakeClock(&now, 0)()
+
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	// A fence request from the server shows that fences are supported.
+	if err := conn.send(fenceMessage{Flags: FenceRequest}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&ServerFence{}).Read(conn); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mockConn.Reset()
+
+	// Updates then trigger fences to measure the RTT, but only one at a time.
+	for i := 0; i < 2; i++ {
+		conn.updateReceived(now, 0)
+	}
+	var msg ClientFenceMessage
+	if err := conn.receive(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := msg.Flags, FenceRequest|FenceBlockBefore; got != want {
+		t.Errorf("incorrect fence flags; got = %v, want = %v", got, want)
+	}
+	if got := mockConn.b.Bytes(); !bytes.Equal(got, rttProbePayload) {
+		t.Errorf("incorrect fence payload; got = %q, want = %q", got, rttProbePayload)
+	}
+	mockConn.Reset()
+
+	now = now.Add(20 * time.Millisecond)
+	if err := conn.send(fenceMessage{Flags: FenceBlockBefore, Length: uint8(len(rttProbePayload))}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send(rttProbePayload); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&ServerFence{}).Read(conn); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := conn.Stats().RTT, 20*time.Millisecond; got != want {
+		t.Errorf("incorrect RTT; got = %v, want = %v", got, want)
+	}
+
+	// Probes are rate limited.
+	conn.updateReceived(now, 0)
+	if mockConn.b.Len() != 0 {
+		t.Errorf("unexpected fence of %d bytes", mockConn.b.Len())
+	}
+	now = now.Add(rttProbeInterval)
+	conn.updateReceived(now, 0)
+	if mockConn.b.Len() == 0 {
+		t.Error("expected fence after probe interval")
+	}
+}
+
+func TestStats_AdaptiveQuality(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{AdaptiveQuality: true})
+	conn.encodings = Encodings{&TightEncoding{}, &QualityLevelPseudoEncoding{9}, &RawEncoding{}}
+
+	for _, tt := range []struct {
+		bandwidth uint64
+		level     uint8
+		sent      bool
+	}{
+		{3000000, 9, false},
+		{100000, 4, true},
+		{100000, 4, false},
+	} {
+		mockConn.Reset()
+		conn.mu.Lock()
+		conn.stats.Bandwidth = 0
+		conn.mu.Unlock()
+		conn.addBandwidthSample(tt.bandwidth, time.Second)
+
+		var levels []uint8
+		for _, e := range conn.Encodings() {
+			if q, ok := e.(*QualityLevelPseudoEncoding); ok {
+				levels = append(levels, q.Level)
+			}
+		}
+		if len(levels) != 1 || levels[0] != tt.level {
+			t.Errorf("%d: incorrect quality levels; got = %v, want = [%d]", tt.bandwidth, levels, tt.level)
+		}
+		if got := mockConn.b.Len() != 0; got != tt.sent {
+			t.Errorf("%d: incorrect SetEncodings sent; got = %v, want = %v", tt.bandwidth, got, tt.sent)
+		}
+	}
+}
+
+func TestStats_AdaptiveQualityWithoutLevel(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{AdaptiveQuality: true})
+	conn.encodings = Encodings{&TightEncoding{}, &RawEncoding{}}
+
+	// Without a quality level, the encodings are left as they are.
+	conn.addBandwidthSample(100000, time.Second)
+	if got, want := len(conn.Encodings()), 2; got != want {
+		t.Errorf("incorrect number of encodings; got = %d, want = %d", got, want)
+	}
+	if mockConn.b.Len() != 0 {
+		t.Errorf("unexpected SetEncodings of %d bytes", mockConn.b.Len())
+	}
+}
+
+func TestStats_AdaptiveQualityConcurrent(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go io.Copy(ioutil.Discard, server)
+	conn := NewClientConn(client, &ClientConfig{AdaptiveQuality: true})
+
+	// Quality changes made by ListenAndHandle race with the user's own.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			conn.mu.Lock()
+			conn.stats.Bandwidth = 0
+			conn.mu.Unlock()
+			conn.addBandwidthSample(uint64(i%2)*3000000+100000, time.Second)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if err := conn.SetEncodings(Encodings{&TightEncoding{}, &QualityLevelPseudoEncoding{9}, &RawEncoding{}}); err != nil {
+			t.Fatal(err)
+		}
+		conn.Encodable(encodings.Tight)
+	}
+	wg.Wait()
+
+	levels := 0
+	for _, e := range conn.Encodings() {
+		if _, ok := e.(*QualityLevelPseudoEncoding); ok {
+			levels++
+		}
+	}
+	if levels != 1 {
+		t.Errorf("incorrect number of quality levels; got = %d, want = 1", levels)
+	}
+}
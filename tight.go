@@ -0,0 +1,436 @@
+/*
+Implementation of the Tight encoding and its pseudo-encodings.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#tight-encoding
+*/
+package vnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/rfbflags"
+)
+
+//-----------------------------------------------------------------------------
+// Tight Encoding
+//
+// Tight encoding sends a rectangle as a single fill color, as JPEG data, or
+// as pixel data that has been passed through a copy, palette or gradient
+// filter and then compressed with one of four zlib streams. The streams last
+// for the whole connection, unless the server asks for them to be reset.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#tight-encoding
+
+// Tight compression-control values, from the upper four bits of the
+// compression-control byte.
+const (
+	tightFill           = 0x08
+	tightJPEG           = 0x09
+	tightMaxBasic       = 0x07 // Basic compression uses values 0x00 - 0x07.
+	tightExplicitFilter = 0x04 // Basic compression with an explicit filter.
+)
+
+// Tight basic compression filters.
+const (
+	tightFilterCopy uint8 = iota
+	tightFilterPalette
+	tightFilterGradient
+)
+
+// tightMinToCompress is the data size below which basic compression data is
+// sent without being compressed.
+const tightMinToCompress = 12
+
+// Limits on the rectangles sent with Tight encoding. Rectangles are never
+// wider than tightMaxRectWidth, and those sent with basic compression have
+// at most tightMaxRectSize pixels.
+const (
+	tightMaxRectWidth = 2048
+	tightMaxRectSize  = 65536
+)
+
+// TightEncoding holds Tight encoded rectangle data.
+type TightEncoding struct {
+	// Image holds the decoded pixel data, with bounds matching the rectangle.
+	Image *image.RGBA
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*TightEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*TightEncoding) Marshal() ([]byte, error) {
+	return nil, fmt.Errorf("Marshal() unimplemented")
+}
+
+// Read implements the Encoding interface.
+func (*TightEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	if rect.Width > tightMaxRectWidth {
+		return nil, fmt.Errorf("unable to read rectangle with tight encoding: width %d exceeds %d", rect.Width, tightMaxRectWidth)
+	}
+	d := &tightDecoder{
+		c:    c,
+		r:    &connReader{c},
+		img:  image.NewRGBA(rect.bounds()),
+		size: c.pixelFormat.tpixelSize(),
+	}
+	if err := d.decode(); err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with tight encoding: %s", err)
+	}
+	c.drawTarget().draw(d.img)
+
+	return &TightEncoding{d.img}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*TightEncoding) String() string { return "TightEncoding" }
+
+// Type implements the Encoding interface.
+func (*TightEncoding) Type() encodings.Encoding { return encodings.Tight }
+
+// tpixelSize returns the number of bytes used to send a pixel with Tight
+// encoding. A TPIXEL is three bytes, holding the red, green and blue values,
+// for 32 bit true color pixel formats that have a depth of 24 and eight bits
+// per color.
+func (pf PixelFormat) tpixelSize() int {
+	if pf.BPP == 32 && pf.Depth == 24 && rfbflags.IsTrueColor(pf.TrueColor) &&
+		pf.RedMax == 255 && pf.GreenMax == 255 && pf.BlueMax == 255 {
+		return 3
+	}
+	return pf.bytesPerPixel()
+}
+
+// tightDecoder decodes a single Tight encoded rectangle.
+type tightDecoder struct {
+	c    *ClientConn
+	r    io.Reader
+	img  *image.RGBA
+	size int // TPIXEL size in bytes
+}
+
+func (d *tightDecoder) decode() error {
+	var ctl uint8 // compression-control
+	if err := binary.Read(d.r, binary.BigEndian, &ctl); err != nil {
+		return err
+	}
+	for i := range d.c.tightStreams {
+		if ctl&(1<<uint(i)) != 0 {
+			d.c.tightStreams[i].reset()
+		}
+	}
+
+	switch comp := ctl >> 4; {
+	case comp == tightFill:
+		c, err := d.readTPixel()
+		if err != nil {
+			return err
+		}
+		fillRGBA(d.img, d.img.Rect, c)
+		return nil
+	case comp == tightJPEG:
+		return d.decodeJPEG()
+	case comp <= tightMaxBasic:
+		filter := tightFilterCopy
+		if comp&tightExplicitFilter != 0 {
+			if err := binary.Read(d.r, binary.BigEndian, &filter); err != nil {
+				return err
+			}
+		}
+		return d.decodeBasic(&d.c.tightStreams[comp&0x03], filter)
+	}
+	return fmt.Errorf("invalid compression-control %#x", ctl)
+}
+
+// decodeJPEG reads JPEG compressed data.
+func (d *tightDecoder) decodeJPEG() error {
+	length, err := readCompactLength(d.r)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(d.r, data); err != nil {
+		return err
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	draw.Draw(d.img, d.img.Rect, img, img.Bounds().Min, draw.Src)
+	return nil
+}
+
+// decodeBasic reads filtered and, unless it is small, compressed data.
+func (d *tightDecoder) decodeBasic(z *zlibStream, filter uint8) error {
+	w, h := d.img.Rect.Dx(), d.img.Rect.Dy()
+	if w*h > tightMaxRectSize {
+		return fmt.Errorf("%d pixels exceed %d for basic compression", w*h, tightMaxRectSize)
+	}
+
+	var (
+		palette []color.RGBA
+		size    int // size of the filtered data
+	)
+	switch filter {
+	case tightFilterCopy, tightFilterGradient:
+		size = w * h * d.size
+	case tightFilterPalette:
+		var n uint8 // number-of-colors - 1
+		if err := binary.Read(d.r, binary.BigEndian, &n); err != nil {
+			return err
+		}
+		palette = make([]color.RGBA, int(n)+1)
+		for i := range palette {
+			c, err := d.readTPixel()
+			if err != nil {
+				return err
+			}
+			palette[i] = c
+		}
+		size = w * h
+		if len(palette) == 2 {
+			size = (w + 7) / 8 * h
+		}
+	default:
+		return fmt.Errorf("invalid filter %d", filter)
+	}
+
+	r := d.r
+	if size >= tightMinToCompress {
+		length, err := readCompactLength(d.r)
+		if err != nil {
+			return err
+		}
+		if r, err = z.read(d.c, length); err != nil {
+			return err
+		}
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+
+	switch filter {
+	case tightFilterCopy:
+		d.copyFilter(data)
+	case tightFilterPalette:
+		return d.paletteFilter(data, palette)
+	case tightFilterGradient:
+		d.gradientFilter(data)
+	}
+	return nil
+}
+
+// copyFilter sets the image from unfiltered TPIXELs.
+func (d *tightDecoder) copyFilter(data []byte) {
+	i := 0
+	for y := d.img.Rect.Min.Y; y < d.img.Rect.Max.Y; y++ {
+		for x := d.img.Rect.Min.X; x < d.img.Rect.Max.X; x++ {
+			d.img.SetRGBA(x, y, d.rgba(data[i:i+d.size]))
+			i += d.size
+		}
+	}
+}
+
+// paletteFilter sets the image from palette indices, which are packed one
+// bit per pixel, with rows padded to a whole byte, for two color palettes.
+func (d *tightDecoder) paletteFilter(data []byte, palette []color.RGBA) error {
+	w := d.img.Rect.Dx()
+	i := 0
+	for y := d.img.Rect.Min.Y; y < d.img.Rect.Max.Y; y++ {
+		for x := 0; x < w; x++ {
+			var index int
+			if len(palette) == 2 {
+				index = int(data[i+x/8]>>uint(7-x%8)) & 1
+			} else {
+				index = int(data[i+x])
+			}
+			if index >= len(palette) {
+				return fmt.Errorf("invalid palette index %d", index)
+			}
+			d.img.SetRGBA(d.img.Rect.Min.X+x, y, palette[index])
+		}
+		if len(palette) == 2 {
+			i += (w + 7) / 8
+		} else {
+			i += w
+		}
+	}
+	return nil
+}
+
+// gradientFilter sets the image from gradient filtered TPIXELs. Each color
+// component is predicted from the pixels to the left and above, and the
+// data holds the difference from the prediction.
+func (d *tightDecoder) gradientFilter(data []byte) {
+	pf := &d.c.pixelFormat
+	max := [3]int{int(pf.RedMax), int(pf.GreenMax), int(pf.BlueMax)}
+	shift := [3]uint8{pf.RedShift, pf.GreenShift, pf.BlueShift}
+	if d.size == 3 {
+		max = [3]int{255, 255, 255}
+	}
+
+	w := d.img.Rect.Dx()
+	prev := make([][3]int, w) // components of the row above
+	this := make([][3]int, w)
+	i := 0
+	for y := d.img.Rect.Min.Y; y < d.img.Rect.Max.Y; y++ {
+		for x := 0; x < w; x++ {
+			var diff [3]int
+			if d.size == 3 {
+				diff = [3]int{int(data[i]), int(data[i+1]), int(data[i+2])}
+			} else {
+				pixel := pf.pixel(data[i : i+d.size])
+				for c := range diff {
+					diff[c] = int(pixel>>shift[c]) & max[c]
+				}
+			}
+			i += d.size
+
+			var pixel uint32
+			for c := range diff {
+				var left, upperLeft int
+				if x > 0 {
+					left, upperLeft = this[x-1][c], prev[x-1][c]
+				}
+				p := left + prev[x][c] - upperLeft
+				if p < 0 {
+					p = 0
+				} else if p > max[c] {
+					p = max[c]
+				}
+				this[x][c] = (p + diff[c]) & max[c]
+				pixel |= uint32(this[x][c]) << shift[c]
+			}
+
+			if d.size == 3 {
+				d.img.SetRGBA(d.img.Rect.Min.X+x, y, color.RGBA{uint8(this[x][0]), uint8(this[x][1]), uint8(this[x][2]), 0xff})
+			} else {
+				if d.c.alphaPixels {
+					pixel |= 0xff000000 // The filter has no alpha.
+				}
+				d.img.SetRGBA(d.img.Rect.Min.X+x, y, d.c.rgba(pixel))
+			}
+		}
+		prev, this = this, prev
+	}
+}
+
+// readTPixel reads a single TPIXEL.
+func (d *tightDecoder) readTPixel() (color.RGBA, error) {
+	var data [4]byte
+	if _, err := io.ReadFull(d.r, data[:d.size]); err != nil {
+		return color.RGBA{}, err
+	}
+	return d.rgba(data[:d.size]), nil
+}
+
+// rgba converts a TPIXEL to a color.
+func (d *tightDecoder) rgba(data []byte) color.RGBA {
+	if len(data) == 3 {
+		return color.RGBA{data[0], data[1], data[2], 0xff}
+	}
+	return d.c.rgba(d.c.pixelFormat.pixel(data))
+}
+
+// readCompactLength reads a length that is sent in one to three bytes, with
+// seven bits in each of the first two bytes, and the high bit set if another
+// byte follows.
+func readCompactLength(r io.Reader) (int, error) {
+	length := 0
+	for i := uint(0); i < 3; i++ {
+		var b uint8
+		if err := binary.Read(r, binary.BigEndian, &b); err != nil {
+			return 0, err
+		}
+		if i == 2 {
+			length |= int(b) << 14
+			break
+		}
+		length |= int(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return length, nil
+}
+
+//-----------------------------------------------------------------------------
+// Compression Level Pseudo-Encoding
+//
+// The compression level pseudo-encoding tells the server the preferred zlib
+// compression level, from 0 (fastest) to 9 (best compression), for the Tight
+// and other zlib based encodings.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#compression-level-pseudo-encoding
+
+// CompressLevelPseudoEncoding represents a compression level.
+type CompressLevelPseudoEncoding struct {
+	Level uint8
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*CompressLevelPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (e *CompressLevelPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (e *CompressLevelPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	return &CompressLevelPseudoEncoding{e.Level}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (e *CompressLevelPseudoEncoding) String() string {
+	return fmt.Sprintf("CompressLevelPseudoEncoding(%d)", e.Level)
+}
+
+// Type implements the Encoding interface.
+func (e *CompressLevelPseudoEncoding) Type() encodings.Encoding {
+	return encodings.CompressLevelPseudo + encodings.Encoding(e.Level)
+}
+
+//-----------------------------------------------------------------------------
+// Quality Level Pseudo-Encoding
+//
+// The quality level pseudo-encoding tells the server the preferred JPEG
+// quality, from 0 (lowest) to 9 (highest), for the Tight encoding. Without
+// it, the server will not use JPEG compression.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#jpeg-quality-level-pseudo-encoding
+
+// QualityLevelPseudoEncoding represents a JPEG quality level.
+type QualityLevelPseudoEncoding struct {
+	Level uint8
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*QualityLevelPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (e *QualityLevelPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (e *QualityLevelPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	return &QualityLevelPseudoEncoding{e.Level}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (e *QualityLevelPseudoEncoding) String() string {
+	return fmt.Sprintf("QualityLevelPseudoEncoding(%d)", e.Level)
+}
+
+// Type implements the Encoding interface.
+func (e *QualityLevelPseudoEncoding) Type() encodings.Encoding {
+	return encodings.QualityLevelPseudo + encodings.Encoding(e.Level)
+}
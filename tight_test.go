@@ -0,0 +1,249 @@
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strings"
+	"testing"
+
+	"github.com/phox/go-vnc/encodings"
+)
+
+func TestTightEncoding_Type(t *testing.T) {
+	e := &TightEncoding{}
+	if got, want := e.Type(), encodings.Tight; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+}
+
+func TestTightEncoding_Read(t *testing.T) {
+	var (
+		cRed  = color.RGBA{255, 0, 0, 255}
+		cBlue = color.RGBA{0, 0, 255, 255}
+	)
+	join := func(bs ...[]byte) []byte {
+		var data []byte
+		for _, b := range bs {
+			data = append(data, b...)
+		}
+		return data
+	}
+
+	// The zlib streams, whose state is kept across rectangles.
+	var zbufs [4]bytes.Buffer
+	var zws [4]*zlib.Writer
+	compress := func(stream int, reset bool, data []byte) []byte {
+		if zws[stream] == nil || reset {
+			zws[stream] = zlib.NewWriter(&zbufs[stream])
+		}
+		zbufs[stream].Reset()
+		if _, err := zws[stream].Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := zws[stream].Flush(); err != nil {
+			t.Fatal(err)
+		}
+		n := zbufs[stream].Len()
+		return append([]byte{byte(n&0x7f | 0x80), byte(n >> 7)}, zbufs[stream].Bytes()...)
+	}
+
+	jpegImg := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	fillRGBA(jpegImg, jpegImg.Rect, color.RGBA{0, 128, 0, 255})
+	var jpegBuf bytes.Buffer
+	if err := jpeg.Encode(&jpegBuf, jpegImg, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	jpegLen := jpegBuf.Len()
+
+	paletteData := bytes.Repeat([]byte{0x80, 0x01}, 8)
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat{BPP: 32, Depth: 24, BigEndian: RFBFalse, TrueColor: RFBTrue,
+		RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0}
+	conn.fb = NewFramebuffer(20, 10)
+
+	for _, tt := range []struct {
+		desc   string
+		rect   Rectangle
+		data   func() []byte
+		pixels map[image.Point]color.RGBA
+	}{
+		{"fill",
+			Rectangle{X: 0, Y: 0, Width: 20, Height: 10},
+			func() []byte { return []byte{tightFill << 4, 255, 0, 0} },
+			map[image.Point]color.RGBA{{0, 0}: cRed, {19, 9}: cRed}},
+		{"uncompressed copy filter",
+			Rectangle{X: 1, Y: 1, Width: 2, Height: 1},
+			func() []byte { return []byte{0x00, 0, 0, 255, 0, 255, 0} },
+			map[image.Point]color.RGBA{{1, 1}: cBlue, {2, 1}: {0, 255, 0, 255}, {3, 1}: cRed}},
+		{"palette filter",
+			Rectangle{X: 2, Y: 2, Width: 16, Height: 8},
+			func() []byte {
+				return join([]byte{(tightExplicitFilter | 1) << 4, tightFilterPalette, 1, 255, 0, 0, 0, 0, 255},
+					compress(1, false, paletteData))
+			},
+			map[image.Point]color.RGBA{{2, 2}: cBlue, {3, 2}: cRed, {17, 9}: cBlue, {16, 9}: cRed}},
+		{"palette filter continues stream",
+			Rectangle{X: 2, Y: 2, Width: 16, Height: 8},
+			func() []byte {
+				return join([]byte{(tightExplicitFilter | 1) << 4, tightFilterPalette, 1, 0, 0, 255, 255, 0, 0},
+					compress(1, false, paletteData))
+			},
+			map[image.Point]color.RGBA{{2, 2}: cRed, {3, 2}: cBlue, {17, 9}: cRed, {16, 9}: cBlue}},
+		{"palette filter after stream reset",
+			Rectangle{X: 2, Y: 2, Width: 16, Height: 8},
+			func() []byte {
+				return join([]byte{(tightExplicitFilter|1)<<4 | 1<<1, tightFilterPalette, 1, 255, 0, 0, 0, 0, 255},
+					compress(1, true, paletteData))
+			},
+			map[image.Point]color.RGBA{{2, 2}: cBlue, {3, 2}: cRed, {17, 9}: cBlue, {16, 9}: cRed}},
+		{"palette filter with indices",
+			Rectangle{X: 0, Y: 0, Width: 3, Height: 1},
+			func() []byte {
+				return []byte{tightExplicitFilter << 4, tightFilterPalette, 2, 255, 0, 0, 0, 0, 255, 0, 255, 0, 2, 1, 0}
+			},
+			map[image.Point]color.RGBA{{0, 0}: {0, 255, 0, 255}, {1, 0}: cBlue, {2, 0}: cRed}},
+		{"gradient filter",
+			Rectangle{X: 0, Y: 0, Width: 2, Height: 2},
+			func() []byte {
+				return join([]byte{(tightExplicitFilter | 2) << 4, tightFilterGradient},
+					compress(2, false, []byte{10, 20, 30, 10, 0, 0, 0, 20, 0, 5, 0, 0}))
+			},
+			map[image.Point]color.RGBA{
+				{0, 0}: {10, 20, 30, 255}, {1, 0}: {20, 20, 30, 255},
+				{0, 1}: {10, 40, 30, 255}, {1, 1}: {25, 40, 30, 255}}},
+		{"jpeg",
+			Rectangle{X: 10, Y: 0, Width: 8, Height: 8},
+			func() []byte {
+				return join([]byte{tightJPEG << 4, byte(jpegLen&0x7f | 0x80), byte(jpegLen >> 7)}, jpegBuf.Bytes())
+			},
+			nil},
+	} {
+		if err := conn.send(tt.data()); err != nil {
+			t.Fatal(err)
+		}
+
+		enc, err := (&TightEncoding{}).Read(conn, &tt.rect)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if got, want := enc.(*TightEncoding).Image.Bounds(), tt.rect.bounds(); got != want {
+			t.Errorf("%s: incorrect image bounds; got = %v, want = %v", tt.desc, got, want)
+		}
+		for p, c := range tt.pixels {
+			if got, want := conn.Framebuffer().At(p.X, p.Y), c; got != want {
+				t.Errorf("%s: incorrect framebuffer pixel at %v; got = %v, want = %v", tt.desc, p, got, want)
+			}
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%s: %d bytes left unread", tt.desc, mockConn.b.Len())
+		}
+	}
+
+	// JPEG is lossy, so only check that the color is close.
+	c := conn.Framebuffer().At(13, 4).(color.RGBA)
+	if c.R > 8 || c.G < 120 || c.G > 136 || c.B > 8 {
+		t.Errorf("jpeg: incorrect framebuffer pixel; got = %v, want ~ %v", c, color.RGBA{0, 128, 0, 255})
+	}
+}
+
+func TestTightEncoding_ReadGradient16bpp(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat{BPP: 16, Depth: 16, BigEndian: RFBTrue, TrueColor: RFBTrue,
+		RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5, BlueShift: 0}
+	conn.fb = NewFramebuffer(4, 1)
+
+	// Pixels (31, 0, 0) and (0, 0, 31); the second has a difference of
+	// (-31, 0, 31), with red wrapping around.
+	data := []byte{(tightExplicitFilter) << 4, tightFilterGradient, 0xf8, 0x00, 0x08, 0x1f}
+	if err := conn.send(data); err != nil {
+		t.Fatal(err)
+	}
+	rect := &Rectangle{Width: 2, Height: 1}
+	if _, err := (&TightEncoding{}).Read(conn, rect); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := conn.Framebuffer().At(0, 0), (color.RGBA{255, 0, 0, 255}); got != want {
+		t.Errorf("incorrect framebuffer pixel; got = %v, want = %v", got, want)
+	}
+	if got, want := conn.Framebuffer().At(1, 0), (color.RGBA{0, 0, 255, 255}); got != want {
+		t.Errorf("incorrect framebuffer pixel; got = %v, want = %v", got, want)
+	}
+}
+
+func TestTightEncoding_ReadTooLarge(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		rect Rectangle
+		data []byte
+	}{
+		{"too wide", Rectangle{Width: tightMaxRectWidth + 1, Height: 1}, nil},
+		{"basic compression of too many pixels", Rectangle{Width: 300, Height: 300}, []byte{0}},
+	} {
+		mockConn := &MockConn{}
+		conn := NewClientConn(mockConn, &ClientConfig{})
+		conn.fb = NewFramebuffer(int(tt.rect.Width), int(tt.rect.Height))
+		if err := conn.send(tt.data); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := (&TightEncoding{}).Read(conn, &tt.rect)
+		if err == nil || !strings.Contains(err.Error(), "exceed") {
+			t.Errorf("%s: expected error for too large a rectangle; got = %v", tt.desc, err)
+		}
+	}
+}
+
+func TestReadCompactLength(t *testing.T) {
+	for _, tt := range []struct {
+		data   []byte
+		length int
+	}{
+		{[]byte{0x05}, 5},
+		{[]byte{0x90, 0x01}, 144},
+		{[]byte{0xff, 0xff, 0xff}, 4194303},
+	} {
+		length, err := readCompactLength(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", tt.data, err)
+			continue
+		}
+		if got, want := length, tt.length; got != want {
+			t.Errorf("%v: incorrect length; got = %v, want = %v", tt.data, got, want)
+		}
+	}
+}
+
+func TestCompressLevelPseudoEncoding_Type(t *testing.T) {
+	for _, tt := range []struct {
+		level uint8
+		enc   encodings.Encoding
+	}{
+		{0, -256},
+		{9, -247},
+	} {
+		e := &CompressLevelPseudoEncoding{tt.level}
+		if got, want := e.Type(), tt.enc; got != want {
+			t.Errorf("incorrect encoding; got = %d, want = %d", got, want)
+		}
+	}
+}
+
+func TestQualityLevelPseudoEncoding_Type(t *testing.T) {
+	for _, tt := range []struct {
+		level uint8
+		enc   encodings.Encoding
+	}{
+		{0, -32},
+		{9, -23},
+	} {
+		e := &QualityLevelPseudoEncoding{tt.level}
+		if got, want := e.Type(), tt.enc; got != want {
+			t.Errorf("incorrect encoding; got = %d, want = %d", got, want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Translation of images to the pixel formats requested by clients.
+
+package vnc
+
+import (
+	"sync"
+
+	"github.com/phox/go-vnc/rfbflags"
+)
+
+// maxTranslators is the number of pixel formats whose translators are kept.
+const maxTranslators = 64
+
+var (
+	translatorsMu sync.Mutex
+	translators   = make(map[PixelFormat]*pixelTranslator)
+)
+
+// colorMapFormat describes the pixel values of the color map sent to clients
+// that use a color mapped pixel format. The map holds 256 colors, with three
+// bits of red and green, and two bits of blue.
+var colorMapFormat = PixelFormat{
+	BPP:        8,
+	Depth:      8,
+	TrueColor:  rfbflags.RFBTrue,
+	RedMax:     7,
+	GreenMax:   7,
+	BlueMax:    3,
+	RedShift:   0,
+	GreenShift: 3,
+	BlueShift:  6,
+}
+
+// A pixelTranslator converts 8-bit colors to the pixel values of a pixel
+// format. A pixel value is made up of a value looked up for each color
+// component, which holds both for true color pixel formats and for indices
+// into the color map used for color mapped ones.
+type pixelTranslator struct {
+	red, green, blue [256]uint32
+}
+
+// translatorFor returns the translator for a pixel format. Translators are
+// cached, as a server usually sees few pixel formats.
+func translatorFor(pf PixelFormat) *pixelTranslator {
+	translatorsMu.Lock()
+	defer translatorsMu.Unlock()
+
+	if t, ok := translators[pf]; ok {
+		return t
+	}
+	if len(translators) >= maxTranslators {
+		translators = make(map[PixelFormat]*pixelTranslator)
+	}
+	t := newPixelTranslator(pf)
+	translators[pf] = t
+	return t
+}
+
+// newPixelTranslator builds the lookup tables for a pixel format.
+func newPixelTranslator(pf PixelFormat) *pixelTranslator {
+	if !rfbflags.IsTrueColor(pf.TrueColor) {
+		pf = colorMapFormat
+	}
+
+	t := &pixelTranslator{}
+	for i := 0; i < 256; i++ {
+		v := uint32(i) * 0x101 // Scale to 16 bits.
+		t.red[i] = uint32(unscaleColor(v, pf.RedMax)) << pf.RedShift
+		t.green[i] = uint32(unscaleColor(v, pf.GreenMax)) << pf.GreenShift
+		t.blue[i] = uint32(unscaleColor(v, pf.BlueMax)) << pf.BlueShift
+	}
+	return t
+}
+
+// pixel returns the pixel value of a color.
+func (t *pixelTranslator) pixel(r, g, b uint8) uint32 {
+	return t.red[r] | t.green[g] | t.blue[b]
+}
+
+// colorMapEntries returns the message that sets the color map used for color
+// mapped pixel formats.
+func colorMapEntries() *SetColorMapEntries {
+	pf := &colorMapFormat
+	colors := make([]Color, 256)
+	for i := range colors {
+		pixel := uint32(i)
+		colors[i] = Color{
+			R: uint16(scaleColor(uint16(pixel>>pf.RedShift)&pf.RedMax, pf.RedMax)),
+			G: uint16(scaleColor(uint16(pixel>>pf.GreenShift)&pf.GreenMax, pf.GreenMax)),
+			B: uint16(scaleColor(uint16(pixel>>pf.BlueShift)&pf.BlueMax, pf.BlueMax)),
+		}
+	}
+	return &SetColorMapEntries{FirstColor: 0, Colors: colors}
+}
@@ -0,0 +1,113 @@
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/messages"
+	"github.com/phox/go-vnc/rfbflags"
+)
+
+func TestPixelTranslator(t *testing.T) {
+	for _, tt := range []struct {
+		pf      PixelFormat
+		r, g, b uint8
+		want    uint32
+	}{
+		// 32bpp RGB and BGR.
+		{NewServerConfig(1, 1).PixelFormat, 0x12, 0x34, 0x56, 0x123456},
+		{PixelFormat{BPP: 32, Depth: 24, TrueColor: rfbflags.RFBTrue, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 0, GreenShift: 8, BlueShift: 16},
+			0x12, 0x34, 0x56, 0x563412},
+		// 16bpp 565, rounding to the nearest value.
+		{PixelFormat{BPP: 16, Depth: 16, TrueColor: rfbflags.RFBTrue, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5, BlueShift: 0},
+			0xff, 0x80, 0x00, 31<<11 | 32<<5},
+		// 8bpp true color BGR233.
+		{colorMapFormat, 0xff, 0x00, 0xff, 7 | 3<<6},
+		// Color mapped formats use indices into the color map.
+		{PixelFormat{BPP: 8, Depth: 8, TrueColor: rfbflags.RFBFalse}, 0x00, 0xff, 0x00, 7 << 3},
+		{PixelFormat{BPP: 16, Depth: 16, TrueColor: rfbflags.RFBFalse}, 0xff, 0xff, 0xff, 0xff},
+	} {
+		if got := translatorFor(tt.pf).pixel(tt.r, tt.g, tt.b); got != tt.want {
+			t.Errorf("%v: incorrect pixel for (%#x, %#x, %#x); got = %#x, want = %#x", tt.pf, tt.r, tt.g, tt.b, got, tt.want)
+		}
+	}
+
+	// Translators are cached by pixel format.
+	pf := NewServerConfig(1, 1).PixelFormat
+	if translatorFor(pf) != translatorFor(pf) {
+		t.Error("expected the same translator for the same pixel format")
+	}
+}
+
+func TestColorMapEntries(t *testing.T) {
+	cm := colorMapEntries()
+	if got, want := len(cm.Colors), 256; got != want {
+		t.Fatalf("incorrect number of colors; got = %v, want = %v", got, want)
+	}
+
+	// Each color maps to the nearest entry.
+	tr := translatorFor(PixelFormat{BPP: 8, Depth: 8})
+	for _, c := range []color.RGBA{{0, 0, 0, 0xff}, {0xff, 0xff, 0xff, 0xff}, {0xff, 0, 0, 0xff}, {0x24, 0x49, 0x55, 0xff}} {
+		e := cm.Colors[tr.pixel(c.R, c.G, c.B)]
+		if got := (color.RGBA{uint8(e.R >> 8), uint8(e.G >> 8), uint8(e.B >> 8), 0xff}); got != c {
+			t.Errorf("incorrect color map entry for %v; got = %v", c, got)
+		}
+	}
+}
+
+func TestServerConn_ColorMapped(t *testing.T) {
+	pf := PixelFormat{BPP: 8, Depth: 8, TrueColor: rfbflags.RFBFalse}
+	mockConn := &MockConn{}
+	conn := NewServerConn(mockConn, NewServerConfig(4, 4))
+	conn.setPixelFormat(pf)
+
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 2, color.RGBA{0xff, 0, 0, 0xff})
+	for i := 0; i < 2; i++ {
+		if err := conn.FramebufferUpdateImage(img, []image.Rectangle{img.Rect}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// The color map is sent once, before the first update.
+	ch := make(chan ServerMessage, 3)
+	cfg := NewClientConfig("")
+	cfg.ServerMessageCh = ch
+	client := NewClientConn(mockConn, cfg)
+	client.pixelFormat = pf
+	client.encodings = Encodings{&RawEncoding{}}
+	client.fb = NewFramebuffer(4, 4)
+	if err := client.ListenAndHandle(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []messages.ServerMessage{messages.SetColorMapEntries, messages.FramebufferUpdate, messages.FramebufferUpdate} {
+		select {
+		case msg := <-ch:
+			if got := msg.Type(); got != want {
+				t.Errorf("incorrect message; got = %v, want = %v", got, want)
+			}
+		default:
+			t.Fatalf("missing %v message", want)
+		}
+	}
+
+	if got, want := client.Framebuffer().At(1, 2), (color.RGBA{0xff, 0, 0, 0xff}); got != want {
+		t.Errorf("incorrect color; got = %v, want = %v", got, want)
+	}
+	if got, want := client.Framebuffer().At(2, 2), (color.RGBA{0, 0, 0, 0xff}); got != want {
+		t.Errorf("incorrect color; got = %v, want = %v", got, want)
+	}
+
+	// A new pixel format sends the color map again.
+	mockConn.Reset()
+	conn.setEncodings([]encodings.Encoding{encodings.Hextile})
+	conn.setPixelFormat(pf)
+	if err := conn.FramebufferUpdateImage(img, []image.Rectangle{img.Rect}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := messages.ServerMessage(mockConn.b.Bytes()[0]), messages.SetColorMapEntries; got != want {
+		t.Errorf("incorrect message; got = %v, want = %v", got, want)
+	}
+}
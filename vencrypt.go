@@ -91,3 +91,142 @@ func (auth *ClientAuthVeNCryptAuth) Handshake(c *ClientConn) error {
 
 	return nil
 }
+
+// VeNCrypt subtypes.
+const (
+	VeNCryptPlain     uint32 = 256
+	VeNCryptTLSNone   uint32 = 257
+	VeNCryptTLSVnc    uint32 = 258
+	VeNCryptTLSPlain  uint32 = 259
+	VeNCryptX509None  uint32 = 260
+	VeNCryptX509Vnc   uint32 = 261
+	VeNCryptX509Plain uint32 = 262
+)
+
+// maxPlainCredentialLen is the longest username or password accepted with the
+// VeNCrypt Plain subtypes.
+const maxPlainCredentialLen = 1024
+
+// ServerAuthVeNCrypt is the VeNCrypt security type, which wraps None, VNC or
+// Plain (username and password) authentication in TLS.
+//
+// The TLS and X509 subtypes both use Config for the TLS handshake. Go does
+// not implement the anonymous TLS used by some clients for the TLS subtypes,
+// so Config must hold a certificate for them too.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#vencrypt
+type ServerAuthVeNCrypt struct {
+	// Config is used for the TLS handshake of all subtypes other than Plain.
+	Config *tls.Config
+
+	// SubTypes are offered to the client, in order of preference. If this
+	// is not set, then X509Vnc and X509Plain are offered.
+	SubTypes []uint32
+
+	// Password is used for VNC authentication by the Vnc subtypes.
+	Password string
+}
+
+// Verify that interfaces are honored.
+var _ ServerAuth = (*ServerAuthVeNCrypt)(nil)
+
+func (*ServerAuthVeNCrypt) SecurityType() uint8 {
+	return secTypeVeNCrypt
+}
+
+func (auth *ServerAuthVeNCrypt) Handshake(c *ServerConn) error {
+	// Version matching. Only version 0.2 is supported.
+	if err := c.send([2]uint8{0, 2}); err != nil {
+		return err
+	}
+	var version [2]uint8
+	if err := c.receive(&version); err != nil {
+		return err
+	}
+	if version != [2]uint8{0, 2} {
+		c.send(uint8(255))
+		return fmt.Errorf("unsupported VeNCrypt version %d.%d", version[0], version[1])
+	}
+	if err := c.send(uint8(0)); err != nil {
+		return err
+	}
+
+	subTypes := auth.SubTypes
+	if len(subTypes) == 0 {
+		subTypes = []uint32{VeNCryptX509Vnc, VeNCryptX509Plain}
+	}
+	if err := c.send(uint8(len(subTypes))); err != nil {
+		return err
+	}
+	if err := c.send(subTypes); err != nil {
+		return err
+	}
+
+	var subType uint32
+	if err := c.receive(&subType); err != nil {
+		return err
+	}
+	supported := false
+	for _, t := range subTypes {
+		if t == subType {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return fmt.Errorf("unsupported VeNCrypt subtype: %d", subType)
+	}
+	c.credentials.VeNCryptSubType = subType
+
+	if subType != VeNCryptPlain {
+		if auth.Config == nil {
+			c.send(uint8(0))
+			return fmt.Errorf("no TLS config for VeNCrypt subtype %d", subType)
+		}
+		// Accept the subtype, and switch the connection to TLS.
+		if err := c.send(uint8(1)); err != nil {
+			return err
+		}
+		tconn := tls.Server(c.Conn, auth.Config)
+		if err := tconn.Handshake(); err != nil {
+			return fmt.Errorf("TLS handshake failed: %s", err)
+		}
+		c.Conn = tconn
+		state := tconn.ConnectionState()
+		c.credentials.TLS = &state
+	}
+
+	switch subType {
+	case VeNCryptTLSNone, VeNCryptX509None:
+		return nil
+	case VeNCryptTLSVnc, VeNCryptX509Vnc:
+		return vncAuthHandshake(c, auth.Password)
+	}
+	return auth.plainHandshake(c)
+}
+
+// plainHandshake reads the username and password sent by the client, which
+// are checked by the Authorizer of the config.
+func (auth *ServerAuthVeNCrypt) plainHandshake(c *ServerConn) error {
+	var lengths [2]uint32 // username-length, password-length
+	if err := c.receive(&lengths); err != nil {
+		return err
+	}
+	if lengths[0] > maxPlainCredentialLen || lengths[1] > maxPlainCredentialLen {
+		return fmt.Errorf("credentials too long")
+	}
+	username := make([]byte, lengths[0])
+	if err := c.receive(&username); err != nil {
+		return err
+	}
+	password := make([]byte, lengths[1])
+	if err := c.receive(&password); err != nil {
+		return err
+	}
+	c.credentials.Username, c.credentials.Password = string(username), string(password)
+
+	if c.config.Authorizer == nil {
+		return fmt.Errorf("authentication failed")
+	}
+	return nil
+}
@@ -6,12 +6,16 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"image"
 	"io"
 	"log"
 	"net"
 	"reflect"
+	"sync"
 
+	"github.com/phox/go-vnc/buttons"
 	"github.com/phox/go-vnc/go/metrics"
+	"github.com/phox/go-vnc/keys"
 	"github.com/phox/go-vnc/messages"
 	"golang.org/x/net/context"
 )
@@ -57,6 +61,9 @@ func Connect(ctx context.Context, c net.Conn, cfg *ClientConfig) (*ClientConn, e
 
 	// Send client-to-server messages.
 	encs := conn.encodings
+	if cfg.Encodings != nil {
+		encs = cfg.Encodings
+	}
 	if err := conn.SetEncodings(encs); err != nil {
 		conn.Close()
 		return nil, Errorf("failure calling SetEncodings; %s", err)
@@ -91,11 +98,27 @@ type ClientConfig struct {
 	// disconnected when a connection is established to the VNC server.
 	Exclusive bool
 
+	// The encodings sent to the server with SetEncodings once connected, in
+	// order of preference. Raw encoding is always supported. If this is not
+	// set, then only Raw encoding will be used.
+	Encodings Encodings
+
+	// AudioSink receives the PCM samples of QEMU audio, as they arrive. See
+	// EnableAudio and NewWAVWriter.
+	AudioSink io.Writer
+
+	// AdaptiveQuality determines whether the advertised JPEG quality level is
+	// adjusted automatically to suit the measured throughput. See Stats. It
+	// has no effect unless Encodings includes a QualityLevelPseudoEncoding.
+	AdaptiveQuality bool
+
 	// The channel that all messages received from the server will be
 	// sent on. If the channel blocks, then the goroutine reading data
 	// from the VNC server may block indefinitely. It is up to the user
 	// of the library to ensure that this channel is properly read.
-	// If this is not set, then all messages will be discarded.
+	// If this is not set, then all messages will be discarded. Messages
+	// synthesized by the client, such as CursorUpdate, are sent after the
+	// message whose data they came from.
 	ServerMessageCh chan ServerMessage
 
 	// A slice of supported messages that can be read from the server.
@@ -118,6 +141,10 @@ func NewClientConfig(p string) *ClientConfig {
 			&SetColorMapEntries{},
 			&Bell{},
 			&ServerCutText{},
+			&EndOfContinuousUpdates{},
+			&ServerFence{},
+			&QEMUAudio{},
+			&XVP{},
 		},
 	}
 }
@@ -142,8 +169,19 @@ type ClientConn struct {
 	desktopName string
 
 	// Encodings supported by the client. This should not be modified
-	// directly. Instead, SetEncodings() should be used.
-	encodings Encodings
+	// directly. Instead, SetEncodings() should be used. It is guarded by mu,
+	// and setEncodingsMu serializes the changes to it.
+	encodings      Encodings
+	setEncodingsMu sync.Mutex
+
+	// The client's copy of the remote framebuffer, which decoded
+	// rectangles are drawn into.
+	fb *Framebuffer
+
+	// While a Cursor With Alpha is decoded, its rectangle is drawn into
+	// target instead of fb, and pixel values hold alpha in their top byte.
+	target      *Framebuffer
+	alphaPixels bool
 
 	// Height of the frame buffer in pixels, sent from the server.
 	fbHeight uint16
@@ -159,8 +197,53 @@ type ClientConn struct {
 	// Security types, supported by the server
 	securityTypes []uint8
 
+	// The zlib streams used by Tight, Zlib, ZlibHex and ZRLE encoded
+	// rectangles, which last for the lifetime of the connection.
+	tightStreams   [4]zlibStream
+	zlibRawStream  zlibStream
+	zlibHexStreams [2]zlibStream // Raw tiles, other tiles.
+	zrleStream     zlibStream
+
 	// Track metrics on system performance.
 	metrics map[string]metrics.Metric
+
+	// Guards desktopName and the state below, which may be read from other
+	// goroutines while ListenAndHandle is running.
+	mu sync.Mutex
+
+	// The cursor shape sent by the server, if any, the pointer position and
+	// the buttons last sent with PointerEvent.
+	cursor         *Cursor
+	pointerPos     image.Point
+	pointerButtons buttons.Button
+
+	// The screen layout sent by the server, if any.
+	screens []Screen
+
+	// Whether the server supports continuous updates, QEMU extended key
+	// events, QEMU audio, xvp and extended mouse buttons.
+	continuousUpdates    bool
+	extendedKeyEvents    bool
+	audio                bool
+	xvp                  bool
+	extendedMouseButtons bool
+
+	// The keyboard lock state sent by the server, if any.
+	leds      LEDState
+	ledsKnown bool
+
+	// The Shift keys held down with ScancodeKeyEvent.
+	shiftKeys map[keys.Key]bool
+
+	// The Extended Clipboard caps of the server and the client clipboard.
+	clipboard clipboardState
+
+	// Round-trip time and throughput estimates.
+	stats statsTracker
+
+	// Messages synthesized while reading a server message, which are sent on
+	// the ServerMessage channel after it.
+	pending []ServerMessage
 }
 
 func NewClientConn(c net.Conn, cfg *ClientConfig) *ClientConn {
@@ -170,10 +253,13 @@ func NewClientConn(c net.Conn, cfg *ClientConfig) *ClientConn {
 		config:         cfg,
 		log:            cfg.Logger,
 		encodings:      Encodings{&RawEncoding{}},
+		fb:             NewFramebuffer(0, 0),
 		pixelFormat:    PixelFormat32bit,
 		metrics: map[string]metrics.Metric{
-			"bytes-received": &metrics.Gauge{},
-			"bytes-sent":     &metrics.Gauge{},
+			"bytes-received":             &metrics.Gauge{},
+			"bytes-sent":                 &metrics.Gauge{},
+			"rtt-microseconds":           &metrics.Gauge{},
+			"bandwidth-bytes-per-second": &metrics.Gauge{},
 		},
 	}
 }
@@ -187,8 +273,11 @@ func (c *ClientConn) Close() error {
 	return c.Conn.Close()
 }
 
-// DesktopName returns the server provided desktop name.
+// DesktopName returns the server provided desktop name. It is updated when
+// the server renames the desktop with the DesktopName pseudo-encoding.
 func (c *ClientConn) DesktopName() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.desktopName
 }
 
@@ -197,14 +286,32 @@ func (c *ClientConn) setDesktopName(name string) {
 	if c.log != nil {
 		c.log.Printf("desktopName: %s\n", name)
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.desktopName = name
 }
 
 // Encodings returns the server provided encodings.
 func (c *ClientConn) Encodings() Encodings {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.encodings
 }
 
+// Framebuffer returns the client's copy of the remote framebuffer. It is
+// updated as FramebufferUpdate messages are handled by ListenAndHandle.
+func (c *ClientConn) Framebuffer() *Framebuffer {
+	return c.fb
+}
+
+// drawTarget returns the framebuffer that decoded rectangles are drawn into.
+func (c *ClientConn) drawTarget() *Framebuffer {
+	if c.target != nil {
+		return c.target
+	}
+	return c.fb
+}
+
 // FramebufferHeight returns the server provided framebuffer height.
 func (c *ClientConn) FramebufferHeight() uint16 {
 	return c.fbHeight
@@ -267,17 +374,51 @@ func (c *ClientConn) ListenAndHandle() error {
 		}
 
 		if c.config.ServerMessageCh == nil {
+			c.takePending()
 			log.Print("ignoring message; no server message channel")
 			continue
 		}
 
 		c.config.ServerMessageCh <- parsedMsg
+		for _, m := range c.takePending() {
+			c.config.ServerMessageCh <- m
+		}
 	}
 
 	log.Print("ListenAndHandle finished")
 	return nil
 }
 
+// queueMessage queues a synthesized message, to be sent on the ServerMessage
+// channel after the message currently being read.
+func (c *ClientConn) queueMessage(msg ServerMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, msg)
+}
+
+// takePending returns and clears the queued synthesized messages.
+func (c *ClientConn) takePending() []ServerMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msgs := c.pending
+	c.pending = nil
+	return msgs
+}
+
+// connReader implements the io.Reader interface for reading directly from the
+// network, for decoders that consume a stream of data.
+type connReader struct {
+	c *ClientConn
+}
+
+// Read implements the io.Reader interface.
+func (r *connReader) Read(p []byte) (int, error) {
+	n, err := r.c.Conn.Read(p)
+	r.c.metrics["bytes-received"].Adjust(int64(n))
+	return n, err
+}
+
 // receive a packet from the network.
 func (c *ClientConn) receive(data interface{}) error {
 	if err := binary.Read(c.Conn, binary.BigEndian, data); err != nil {
@@ -293,6 +434,7 @@ func (c *ClientConn) receiveN(data interface{}, n int) error {
 		return nil
 	}
 
+	size := n // Bytes received.
 	switch data.(type) {
 	case *[]uint8:
 		var v uint8
@@ -304,6 +446,7 @@ func (c *ClientConn) receiveN(data interface{}, n int) error {
 			*slice = append(*slice, v)
 		}
 	case *[]int32:
+		size = 4 * n
 		var v int32
 		for i := 0; i < n; i++ {
 			if err := binary.Read(c.Conn, binary.BigEndian, &v); err != nil {
@@ -324,7 +467,7 @@ func (c *ClientConn) receiveN(data interface{}, n int) error {
 	default:
 		return NewVNCError(fmt.Sprintf("unrecognized data type %v", reflect.TypeOf(data)))
 	}
-	c.metrics["bytes-received"].Adjust(int64(binary.Size(data)))
+	c.metrics["bytes-received"].Adjust(int64(size))
 	return nil
 }
 
@@ -0,0 +1,740 @@
+// VNC server implementation.
+
+package vnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/messages"
+	"github.com/phox/go-vnc/rfbflags"
+	"golang.org/x/net/context"
+)
+
+// Serve accepts connections on the listener, and serves each of them on its
+// own goroutine, until the context is done or the listener fails. Errors on
+// individual connections are logged to the Logger of the config, if set.
+//
+// Before Serve returns, the listener and all of the connections are closed,
+// and their goroutines have finished.
+func Serve(ctx context.Context, l net.Listener, cfg *ServerConfig) error {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		conns  = make(map[net.Conn]struct{})
+		closed bool
+	)
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		l.Close()
+		mu.Lock()
+		closed = true
+		for nc := range conns {
+			nc.Close()
+		}
+		mu.Unlock()
+	}()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		mu.Lock()
+		if closed {
+			mu.Unlock()
+			nc.Close()
+			continue
+		}
+		conns[nc] = struct{}{}
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				mu.Lock()
+				delete(conns, nc)
+				mu.Unlock()
+			}()
+
+			conn, err := Accept(ctx, nc, cfg)
+			if err != nil {
+				if cfg.Logger != nil {
+					cfg.Logger.Printf("error negotiating connection with %v: %v", nc.RemoteAddr(), err)
+				}
+				return
+			}
+			defer conn.Close()
+			if err := conn.ListenAndHandle(); err != nil && cfg.Logger != nil {
+				cfg.Logger.Printf("error serving %v: %v", nc.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Accept negotiates a connection with a VNC client. The handshake fails if
+// it is not complete by the deadline of the context, or by the
+// HandshakeTimeout of the config, or if the context is cancelled first.
+func Accept(ctx context.Context, c net.Conn, cfg *ServerConfig) (*ServerConn, error) {
+	timeout := cfg.HandshakeTimeout
+	if timeout == 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn := NewServerConn(c, cfg)
+	stop := setContextDeadline(ctx, c)
+	err := conn.handshake()
+	stop()
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("handshake not completed: %s", ctx.Err())
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// handshake performs the handshake and initialization of the connection.
+func (c *ServerConn) handshake() error {
+	if err := c.protocolVersionHandshake(); err != nil {
+		return err
+	}
+	if err := c.securityHandshake(); err != nil {
+		return err
+	}
+	if err := c.securityResultHandshake(c.authenticate()); err != nil {
+		return err
+	}
+	if err := c.clientInit(); err != nil {
+		return err
+	}
+	return c.serverInit()
+}
+
+// setContextDeadline makes reads and writes on c fail at the deadline of the
+// context, or once it is cancelled, until the returned function is called.
+func setContextDeadline(ctx context.Context, c net.Conn) (stop func()) {
+	if d, ok := ctx.Deadline(); ok {
+		c.SetDeadline(d)
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Unix(1, 0)) // In the past.
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+		c.SetDeadline(time.Time{})
+	}
+}
+
+// A ServerConfig structure is used to configure a ServerConn. After one has
+// been passed to serve a connection, it must not be modified.
+type ServerConfig struct {
+	// Logger
+	Logger *log.Logger
+
+	// The size of the framebuffer, sent to the client in ServerInit.
+	Width, Height uint16
+
+	// The pixel format of the server, sent to the client in ServerInit. It is
+	// used until the client sends a SetPixelFormat message.
+	PixelFormat PixelFormat
+
+	// DesktopName is the name associated with the desktop.
+	DesktopName string
+
+	// A slice of ServerAuth methods offered to clients, in order of
+	// preference. If this is not set, then only None is offered.
+	Auth []ServerAuth
+
+	// Authorizer, if set, is asked whether each client that authenticates
+	// may connect. It is required for the VeNCrypt Plain subtypes, whose
+	// credentials are only checked by it.
+	Authorizer Authorizer
+
+	// Handler is called with each message read from the client. If it returns
+	// an error, the connection is closed. If this is not set, then all
+	// messages are discarded.
+	Handler ServerHandler
+
+	// A slice of supported messages that can be read from the client.
+	ClientMessages []ClientMessage
+
+	// Source provides the framebuffer contents. If set, FramebufferUpdate
+	// requests from the client are answered from it, with only the areas
+	// that changed for incremental requests. The requests are still passed
+	// to the Handler. If the size of the Source changes, clients that support
+	// the DesktopSize or ExtendedDesktopSize pseudo-encodings are sent the
+	// new size.
+	Source FrameSource
+
+	// Encoders creates the encoders offered to each client, which uses the
+	// first of them in its order of preference. If this is not set, then
+	// DefaultServerEncoders is used.
+	Encoders []func() ServerEncoder
+
+	// UpdateInterval is how often the Source is checked for changes while an
+	// incremental request is pending. If zero, DefaultUpdateInterval is used.
+	UpdateInterval time.Duration
+
+	// HandshakeTimeout limits how long Accept waits for a client to complete
+	// the handshake. If zero, DefaultHandshakeTimeout is used.
+	HandshakeTimeout time.Duration
+}
+
+// DefaultUpdateInterval is how often a FrameSource is checked for changes, if
+// the ServerConfig does not set an UpdateInterval.
+const DefaultUpdateInterval = 40 * time.Millisecond
+
+// DefaultHandshakeTimeout is how long a client has to complete the handshake,
+// if the ServerConfig does not set a HandshakeTimeout.
+const DefaultHandshakeTimeout = 30 * time.Second
+
+// NewServerConfig returns a populated ServerConfig, for a framebuffer of the
+// given size in 32-bit true color.
+func NewServerConfig(width, height uint16) *ServerConfig {
+	return &ServerConfig{
+		Width:  width,
+		Height: height,
+		PixelFormat: PixelFormat{
+			BPP:        32,
+			Depth:      24,
+			BigEndian:  rfbflags.RFBFalse,
+			TrueColor:  rfbflags.RFBTrue,
+			RedMax:     255,
+			GreenMax:   255,
+			BlueMax:    255,
+			RedShift:   16,
+			GreenShift: 8,
+			BlueShift:  0,
+		},
+		ClientMessages: []ClientMessage{
+			&SetPixelFormatMessage{},
+			&SetEncodings{},
+			&FramebufferUpdateRequestMessage{},
+			&KeyEventMessage{},
+			&PointerEventMessage{},
+			&ClientCutText{},
+		},
+	}
+}
+
+// ServerHandler responds to messages read from a client.
+type ServerHandler interface {
+	// Handle is called with each message read from the client, in order.
+	// The connection state, such as the pixel format, has already been
+	// updated from the message.
+	Handle(c *ServerConn, msg ClientMessage) error
+}
+
+// ServerHandlerFunc adapts a function to the ServerHandler interface.
+type ServerHandlerFunc func(c *ServerConn, msg ClientMessage) error
+
+// Verify that interfaces are honored.
+var _ ServerHandler = ServerHandlerFunc(nil)
+
+// Handle implements the ServerHandler interface.
+func (f ServerHandlerFunc) Handle(c *ServerConn, msg ClientMessage) error {
+	return f(c, msg)
+}
+
+// The ServerConn type holds server connection information.
+type ServerConn struct {
+	Conn            net.Conn
+	config          *ServerConfig
+	protocolVersion string
+
+	log *log.Logger
+
+	// The negotiated security type, its authentication, and the credentials
+	// presented by the client.
+	secType     uint8
+	auth        ServerAuth
+	credentials Credentials
+
+	// Whether the client allows other clients to share the desktop.
+	shared bool
+
+	// Guards the state below, which may be read from other goroutines while
+	// ListenAndHandle is running.
+	mu sync.Mutex
+
+	// The pixel format and encodings requested by the client.
+	pixelFormat PixelFormat
+	encodings   []encodings.Encoding
+
+	// Whether the color map has been sent for a color mapped pixel format.
+	colorMapSent bool
+
+	// The latest FramebufferUpdate request not yet answered.
+	updateRequest *FramebufferUpdateRequestMessage
+
+	// Signals the update loop that a request arrived.
+	wake chan struct{}
+
+	// The state of the framebuffer of the client, used only by the update
+	// loop: its size, from ServerInit or the last desktop size sent to it,
+	// the generation of the Source last looked at, and the areas changed up
+	// to that generation that have not been sent to it.
+	fbSize  image.Point
+	sentGen uint64
+	sentAny bool
+	unsent  []image.Rectangle
+
+	// The encoders created for the client, by encoding type. Guarded by
+	// encMu, as they keep state between updates.
+	encMu    sync.Mutex
+	encoders map[encodings.Encoding]ServerEncoder
+
+	// Serializes messages sent to the client.
+	sendMu sync.Mutex
+}
+
+// NewServerConn returns a ServerConn for the connection, without
+// negotiating it.
+func NewServerConn(c net.Conn, cfg *ServerConfig) *ServerConn {
+	return &ServerConn{
+		Conn:        c,
+		config:      cfg,
+		log:         cfg.Logger,
+		pixelFormat: cfg.PixelFormat,
+		fbSize:      image.Pt(int(cfg.Width), int(cfg.Height)),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Close a connection to a VNC client.
+func (c *ServerConn) Close() error {
+	return c.Conn.Close()
+}
+
+// Credentials returns how the client authenticated.
+func (c *ServerConn) Credentials() Credentials {
+	return c.credentials
+}
+
+// Shared returns whether the client allows the desktop to be shared with
+// other clients, as requested in ClientInit.
+func (c *ServerConn) Shared() bool {
+	return c.shared
+}
+
+// PixelFormat returns the pixel format that the client expects.
+func (c *ServerConn) PixelFormat() PixelFormat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pixelFormat
+}
+
+// setPixelFormat stores the pixel format requested by the client.
+func (c *ServerConn) setPixelFormat(pf PixelFormat) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pixelFormat = pf
+	c.colorMapSent = false
+}
+
+// sendColorMap sends the color map used for color mapped pixel formats, if
+// it has not been sent since the client set its pixel format.
+//
+// See RFC 6143 Section 7.6.2
+func (c *ServerConn) sendColorMap() error {
+	c.mu.Lock()
+	sent := c.colorMapSent
+	c.colorMapSent = true
+	c.mu.Unlock()
+
+	if sent {
+		return nil
+	}
+	return c.sendMessage(colorMapEntries())
+}
+
+// Encodings returns the encodings supported by the client, in order of
+// preference.
+func (c *ServerConn) Encodings() []encodings.Encoding {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.encodings
+}
+
+// setEncodings stores the encodings supported by the client.
+func (c *ServerConn) setEncodings(encs []encodings.Encoding) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.encodings = encs
+}
+
+// requestUpdate stores a FramebufferUpdate request, and wakes the update loop.
+// A later request replaces one that has not been answered yet.
+func (c *ServerConn) requestUpdate(req *FramebufferUpdateRequestMessage) {
+	c.mu.Lock()
+	c.updateRequest = req
+	c.mu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ListenAndHandle reads messages from the VNC client, and passes them to the
+// Handler of the config. It returns when the connection is closed, or a
+// message can not be read or handled.
+func (c *ServerConn) ListenAndHandle() error {
+	if c.config.ClientMessages == nil {
+		return NewVNCError("Server config error: ClientMessages undefined")
+	}
+	clientMessages := make(map[messages.ClientMessage]ClientMessage)
+	for _, m := range c.config.ClientMessages {
+		clientMessages[m.Type()] = m
+	}
+
+	if c.config.Source != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go c.serveUpdates(done)
+	}
+
+	for {
+		var messageType messages.ClientMessage
+		if err := c.receive(&messageType); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if c.log != nil {
+			c.log.Printf("message-type: %s", messageType)
+		}
+
+		msg, ok := clientMessages[messageType]
+		if !ok {
+			return fmt.Errorf("unsupported message-type: %v", messageType)
+		}
+
+		parsedMsg, err := msg.Read(c)
+		if err != nil {
+			return fmt.Errorf("error parsing message: %v", err)
+		}
+
+		if c.config.Handler == nil {
+			continue
+		}
+		if err := c.config.Handler.Handle(c, parsedMsg); err != nil {
+			return err
+		}
+	}
+}
+
+// serveUpdates answers FramebufferUpdate requests from the Source of the
+// config, until done is closed. If an update can not be sent, the connection
+// is closed.
+func (c *ServerConn) serveUpdates(done <-chan struct{}) {
+	interval := c.config.UpdateInterval
+	if interval <= 0 {
+		interval = DefaultUpdateInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-c.wake:
+		case <-ticker.C:
+		}
+		if err := c.sendPendingUpdate(); err != nil {
+			if c.log != nil {
+				c.log.Printf("error sending framebuffer update: %v", err)
+			}
+			c.Close()
+			return
+		}
+	}
+}
+
+// sendPendingUpdate answers the pending FramebufferUpdate request, if any. A
+// non-incremental request is answered with the whole requested area. An
+// incremental request is answered with the areas that the client has not
+// been sent since they changed, and stays pending until there are some in the
+// requested area. Those outside of it are kept for later requests.
+//
+// If the size of the Source changes, the request is answered with the new
+// size instead, if the client supports the DesktopSize or ExtendedDesktopSize
+// pseudo-encodings. Otherwise, updates are clipped to the size the client
+// knows.
+func (c *ServerConn) sendPendingUpdate() error {
+	c.mu.Lock()
+	req := c.updateRequest
+	c.mu.Unlock()
+	if req == nil {
+		return nil
+	}
+
+	src := c.config.Source
+	img, gen := src.Frame()
+	if size := img.Bounds().Size(); size != c.fbSize {
+		if rect, ok := c.desktopSizeRect(size); ok {
+			c.fbSize, c.sentAny = size, false
+			c.clearUpdateRequest(req)
+			return c.FramebufferUpdate([]Rectangle{rect})
+		}
+	}
+
+	fb := image.Rectangle{Max: c.fbSize}
+	switch {
+	case !c.sentAny:
+		c.unsent = []image.Rectangle{fb}
+	case gen != c.sentGen:
+		rects, ok := src.Dirty(c.sentGen)
+		if !ok {
+			rects = []image.Rectangle{fb}
+		}
+		c.unsent = addDirty(c.unsent, fb, rects)
+	}
+	c.sentGen, c.sentAny = gen, true
+
+	area := image.Rect(int(req.X), int(req.Y), int(req.X)+int(req.Width), int(req.Y)+int(req.Height))
+	area = area.Intersect(fb).Intersect(img.Bounds())
+
+	var dirty []image.Rectangle
+	if rfbflags.ToBool(req.Inc) {
+		for _, r := range c.unsent {
+			if r = r.Intersect(area); !r.Empty() {
+				dirty = append(dirty, r)
+			}
+		}
+		if len(dirty) == 0 {
+			// Nothing changed in the requested area, so the request stays
+			// pending.
+			return nil
+		}
+	} else {
+		dirty = []image.Rectangle{area}
+	}
+	c.unsent = subtractDirty(c.unsent, area)
+	c.clearUpdateRequest(req)
+
+	// A non-incremental request must be answered, so one for an area outside
+	// of the framebuffer gets an update without rectangles.
+	return c.FramebufferUpdateImage(img, dirty)
+}
+
+// clearUpdateRequest marks the FramebufferUpdate request as answered, unless
+// it has been replaced by a later one.
+func (c *ServerConn) clearUpdateRequest(req *FramebufferUpdateRequestMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.updateRequest == req {
+		c.updateRequest = nil
+	}
+}
+
+// desktopSizeRect returns the rectangle that tells the client of a new
+// framebuffer size, with the ExtendedDesktopSize pseudo-encoding if it
+// supports it, or else DesktopSize. It returns false if the client supports
+// neither.
+func (c *ServerConn) desktopSizeRect(size image.Point) (Rectangle, bool) {
+	rect := Rectangle{Width: uint16(size.X), Height: uint16(size.Y)}
+	var desktopSize bool
+	for _, enc := range c.Encodings() {
+		switch enc {
+		case encodings.ExtendedDesktopSizePseudo:
+			rect.X, rect.Y = uint16(DesktopSizeServer), uint16(DesktopSizeOK)
+			rect.Enc = &ExtendedDesktopSizePseudoEncoding{
+				Reason:  DesktopSizeServer,
+				Status:  DesktopSizeOK,
+				Screens: []Screen{{Width: rect.Width, Height: rect.Height}},
+			}
+			return rect, true
+		case encodings.DesktopSizePseudo:
+			desktopSize = true
+		}
+	}
+	if !desktopSize {
+		return Rectangle{}, false
+	}
+	rect.Enc = &DesktopSizePseudoEncoding{}
+	return rect, true
+}
+
+// encoder returns the encoder for the encoding that the client prefers, out
+// of those in the config. Raw encoding is used if there are none.
+func (c *ServerConn) encoder() ServerEncoder {
+	newEncoders := c.config.Encoders
+	if newEncoders == nil {
+		newEncoders = DefaultServerEncoders
+	}
+	if c.encoders == nil {
+		c.encoders = make(map[encodings.Encoding]ServerEncoder)
+		for _, fn := range newEncoders {
+			e := fn()
+			c.encoders[e.Type()] = e
+		}
+	}
+
+	for _, enc := range c.Encodings() {
+		if e, ok := c.encoders[enc]; ok {
+			return e
+		}
+	}
+	if e, ok := c.encoders[encodings.Raw]; ok {
+		return e
+	}
+	e := NewRawEncoder()
+	c.encoders[encodings.Raw] = e
+	return e
+}
+
+// encodeOptions returns the parameters negotiated with the client.
+func (c *ServerConn) encodeOptions() *EncodeOptions {
+	opts := &EncodeOptions{
+		PixelFormat:   c.PixelFormat(),
+		CompressLevel: -1,
+		QualityLevel:  -1,
+	}
+	for _, enc := range c.Encodings() {
+		switch {
+		case enc >= encodings.CompressLevelPseudo && enc <= encodings.CompressLevelPseudo+9:
+			opts.CompressLevel = int(enc - encodings.CompressLevelPseudo)
+		case enc >= encodings.QualityLevelPseudo && enc <= encodings.QualityLevelPseudo+9:
+			opts.QualityLevel = int(enc - encodings.QualityLevelPseudo)
+		}
+	}
+	return opts
+}
+
+// FramebufferUpdateImage sends the areas of img to the client, encoded with
+// the encoding it prefers, in its pixel format. If the pixel format is color
+// mapped, the color map is sent first.
+//
+// See RFC 6143 Section 7.6.1
+func (c *ServerConn) FramebufferUpdateImage(img image.Image, areas []image.Rectangle) error {
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+
+	opts := c.encodeOptions()
+	if !rfbflags.IsTrueColor(opts.PixelFormat.TrueColor) {
+		if err := c.sendColorMap(); err != nil {
+			return err
+		}
+	}
+	enc := c.encoder()
+
+	var rects []image.Rectangle
+	for _, r := range areas {
+		if r = r.Intersect(img.Bounds()); r.Empty() {
+			continue
+		}
+		if s, ok := enc.(rectSplitter); ok {
+			rects = append(rects, s.split(r)...)
+		} else {
+			rects = append(rects, r)
+		}
+	}
+	if len(rects) > 0xffff {
+		return NewVNCError(fmt.Sprintf("too many rectangles in framebuffer update: %d", len(rects)))
+	}
+
+	buf := NewBuffer(nil)
+	header := []byte{uint8(messages.FramebufferUpdate), 0, uint8(len(rects) >> 8), uint8(len(rects))}
+	if err := buf.Write(header); err != nil {
+		return err
+	}
+	for _, r := range rects {
+		msg := rectangleMessage{uint16(r.Min.X), uint16(r.Min.Y), uint16(r.Dx()), uint16(r.Dy()), enc.Type()}
+		if err := buf.Write(msg); err != nil {
+			return err
+		}
+		data, err := enc.Encode(img, r, opts)
+		if err != nil {
+			return err
+		}
+		if err := buf.Write(data); err != nil {
+			return err
+		}
+	}
+	return c.send(buf.Bytes())
+}
+
+// FramebufferUpdate sends the rectangles to the client.
+//
+// See RFC 6143 Section 7.6.1
+func (c *ServerConn) FramebufferUpdate(rects []Rectangle) error {
+	return c.sendMessage(newFramebufferUpdate(rects))
+}
+
+// Bell asks the client to ring a bell.
+//
+// See RFC 6143 Section 7.6.3
+func (c *ServerConn) Bell() error {
+	return c.sendMessage(&Bell{})
+}
+
+// ServerCutText tells the client that the server has new text in its cut
+// buffer. The text must only contain Latin-1 characters.
+//
+// See RFC 6143 Section 7.6.4
+func (c *ServerConn) ServerCutText(text string) error {
+	return c.sendMessage(&ServerCutText{Text: text})
+}
+
+// sendMessage marshals a message and sends it to the client.
+func (c *ServerConn) sendMessage(m Marshaler) error {
+	bytes, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	return c.send(bytes)
+}
+
+// receiveMessage reads a message whose message-type has already been read.
+func (c *ServerConn) receiveMessage(t messages.ClientMessage, msg interface{}) error {
+	r := io.MultiReader(bytes.NewReader([]byte{uint8(t)}), c.Conn)
+	return binary.Read(r, binary.BigEndian, msg)
+}
+
+// receive a packet from the network.
+func (c *ServerConn) receive(data interface{}) error {
+	return binary.Read(c.Conn, binary.BigEndian, data)
+}
+
+// send a packet to the network.
+func (c *ServerConn) send(data interface{}) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	return binary.Write(c.Conn, binary.BigEndian, data)
+}
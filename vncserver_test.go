@@ -0,0 +1,547 @@
+package vnc
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/phox/go-vnc/buttons"
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/keys"
+	"github.com/phox/go-vnc/messages"
+	"github.com/phox/go-vnc/rfbflags"
+	"golang.org/x/net/context"
+)
+
+// newTestServer serves cfg on a local listener, and returns its address, and
+// a function that stops the server.
+func newTestServer(t *testing.T, cfg *ServerConfig) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- Serve(ctx, ln, cfg) }()
+	stop := func() {
+		cancel()
+		if err := <-errc; err != context.Canceled {
+			t.Errorf("Serve() returned %v, want %v", err, context.Canceled)
+		}
+	}
+	return ln.Addr().String(), stop
+}
+
+// nextMessage returns the next message passed to a handler on ch.
+func nextMessage(t *testing.T, ch chan ClientMessage) ClientMessage {
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for client message")
+		return nil
+	}
+}
+
+func TestServe(t *testing.T) {
+	defer SetSettle(Settle())
+	SetSettle(0)
+
+	msgs := make(chan ClientMessage, 16)
+	cfg := NewServerConfig(64, 48)
+	cfg.DesktopName = "test desktop"
+	cfg.Handler = ServerHandlerFunc(func(c *ServerConn, msg ClientMessage) error {
+		msgs <- msg
+		return nil
+	})
+
+	addr, stop := newTestServer(t, cfg)
+	defer stop()
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("error connecting to server: %s", err)
+	}
+	vcc := NewClientConfig("")
+	vcc.Encodings = Encodings{&HextileEncoding{}, &RawEncoding{}}
+	vc, err := Connect(context.Background(), nc, vcc)
+	if err != nil {
+		t.Fatalf("Connect() unexpected error: %v", err)
+	}
+	defer vc.Close()
+
+	if got, want := vc.FramebufferWidth(), uint16(64); got != want {
+		t.Errorf("incorrect width; got = %v, want = %v", got, want)
+	}
+	if got, want := vc.FramebufferHeight(), uint16(48); got != want {
+		t.Errorf("incorrect height; got = %v, want = %v", got, want)
+	}
+	if got, want := vc.DesktopName(), "test desktop"; got != want {
+		t.Errorf("incorrect desktop name; got = %q, want = %q", got, want)
+	}
+
+	// Connect sends the encodings and pixel format.
+	encs := nextMessage(t, msgs).(*SetEncodings)
+	if got, want := encs.Encodings, []encodings.Encoding{encodings.Hextile, encodings.Raw}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect encodings; got = %v, want = %v", got, want)
+	}
+	pf := nextMessage(t, msgs).(*SetPixelFormatMessage)
+	if got, want := pf.PF, cfg.PixelFormat; got != want {
+		t.Errorf("incorrect pixel format; got = %v, want = %v", got, want)
+	}
+
+	if err := vc.FramebufferUpdateRequest(rfbflags.RFBTrue, 1, 2, 3, 4); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := *nextMessage(t, msgs).(*FramebufferUpdateRequestMessage),
+		(FramebufferUpdateRequestMessage{messages.FramebufferUpdateRequest, rfbflags.RFBTrue, 1, 2, 3, 4}); got != want {
+		t.Errorf("incorrect update request; got = %v, want = %v", got, want)
+	}
+
+	if err := vc.KeyEvent(keys.SmallA, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := *nextMessage(t, msgs).(*KeyEventMessage),
+		(KeyEventMessage{Msg: messages.KeyEvent, DownFlag: rfbflags.RFBTrue, Key: keys.SmallA}); got != want {
+		t.Errorf("incorrect key event; got = %v, want = %v", got, want)
+	}
+
+	if err := vc.PointerEvent(buttons.Left|buttons.Right, 10, 20); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := *nextMessage(t, msgs).(*PointerEventMessage),
+		(PointerEventMessage{messages.PointerEvent, 0x05, 10, 20}); got != want {
+		t.Errorf("incorrect pointer event; got = %v, want = %v", got, want)
+	}
+
+	if err := vc.ClientCutText("café\r\nbar"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := nextMessage(t, msgs).(*ClientCutText).Text, "café\nbar"; got != want {
+		t.Errorf("incorrect cut text; got = %q, want = %q", got, want)
+	}
+}
+
+// nextUpdate returns the next FramebufferUpdate received on ch.
+func nextUpdate(t *testing.T, ch chan ServerMessage) *FramebufferUpdate {
+	for {
+		select {
+		case msg := <-ch:
+			if fu, ok := msg.(*FramebufferUpdate); ok {
+				return fu
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for framebuffer update")
+			return nil
+		}
+	}
+}
+
+func TestServeUpdates(t *testing.T) {
+	defer SetSettle(Settle())
+	SetSettle(0)
+
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+	canvas := NewCanvas(16, 8)
+	canvas.Fill(canvas.Bounds(), red)
+
+	cfg := NewServerConfig(16, 8)
+	cfg.Source = canvas
+	cfg.UpdateInterval = time.Millisecond
+
+	addr, stop := newTestServer(t, cfg)
+	defer stop()
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("error connecting to server: %s", err)
+	}
+	ch := make(chan ServerMessage, 16)
+	vcc := NewClientConfig("")
+	vcc.Encodings = Encodings{&ZRLEEncoding{}, &RawEncoding{}}
+	vcc.ServerMessageCh = ch
+	vc, err := Connect(context.Background(), nc, vcc)
+	if err != nil {
+		t.Fatalf("Connect() unexpected error: %v", err)
+	}
+	defer vc.Close()
+	go vc.ListenAndHandle()
+
+	// A non-incremental request is answered with the whole area.
+	if err := vc.FramebufferUpdateRequest(rfbflags.RFBFalse, 0, 0, 16, 8); err != nil {
+		t.Fatal(err)
+	}
+	fu := nextUpdate(t, ch)
+	if got, want := len(fu.Rects), 1; got != want {
+		t.Fatalf("incorrect number of rectangles; got = %v, want = %v", got, want)
+	}
+	if got, want := fu.Rects[0].bounds(), image.Rect(0, 0, 16, 8); got != want {
+		t.Errorf("incorrect rectangle; got = %v, want = %v", got, want)
+	}
+	if got, want := fu.Rects[0].Enc.Type(), encodings.ZRLE; got != want {
+		t.Errorf("incorrect encoding; got = %v, want = %v", got, want)
+	}
+	if got := vc.Framebuffer().At(5, 5); got != red {
+		t.Errorf("incorrect color; got = %v, want = %v", got, red)
+	}
+
+	// An incremental request is answered with the changed areas only, once
+	// they change.
+	if err := vc.FramebufferUpdateRequest(rfbflags.RFBTrue, 0, 0, 16, 8); err != nil {
+		t.Fatal(err)
+	}
+	canvas.Fill(image.Rect(2, 3, 6, 5), blue)
+	fu = nextUpdate(t, ch)
+	if got, want := len(fu.Rects), 1; got != want {
+		t.Fatalf("incorrect number of rectangles; got = %v, want = %v", got, want)
+	}
+	if got, want := fu.Rects[0].bounds(), image.Rect(2, 3, 6, 5); got != want {
+		t.Errorf("incorrect rectangle; got = %v, want = %v", got, want)
+	}
+	if got := vc.Framebuffer().At(3, 4); got != blue {
+		t.Errorf("incorrect color; got = %v, want = %v", got, blue)
+	}
+	if got := vc.Framebuffer().At(10, 4); got != red {
+		t.Errorf("incorrect color; got = %v, want = %v", got, red)
+	}
+
+	// Changes outside the area of an incremental request leave it pending,
+	// until something in the area changes.
+	if err := vc.FramebufferUpdateRequest(rfbflags.RFBTrue, 0, 0, 4, 2); err != nil {
+		t.Fatal(err)
+	}
+	canvas.Fill(image.Rect(10, 5, 14, 7), blue)
+	time.Sleep(20 * time.Millisecond)
+	canvas.Fill(image.Rect(0, 0, 2, 1), blue)
+	fu = nextUpdate(t, ch)
+	if got, want := len(fu.Rects), 1; got != want {
+		t.Fatalf("incorrect number of rectangles; got = %v, want = %v", got, want)
+	}
+	if got, want := fu.Rects[0].bounds(), image.Rect(0, 0, 2, 1); got != want {
+		t.Errorf("incorrect rectangle; got = %v, want = %v", got, want)
+	}
+	if got := vc.Framebuffer().At(11, 6); got != red {
+		t.Errorf("incorrect color; got = %v, want = %v", got, red)
+	}
+
+	// The changes outside of that area are sent with a later request.
+	if err := vc.FramebufferUpdateRequest(rfbflags.RFBTrue, 0, 0, 16, 8); err != nil {
+		t.Fatal(err)
+	}
+	fu = nextUpdate(t, ch)
+	if got, want := len(fu.Rects), 1; got != want {
+		t.Fatalf("incorrect number of rectangles; got = %v, want = %v", got, want)
+	}
+	if got, want := fu.Rects[0].bounds(), image.Rect(10, 5, 14, 7); got != want {
+		t.Errorf("incorrect rectangle; got = %v, want = %v", got, want)
+	}
+	if got := vc.Framebuffer().At(11, 6); got != blue {
+		t.Errorf("incorrect color; got = %v, want = %v", got, blue)
+	}
+
+	// A non-incremental request outside of the framebuffer is still answered.
+	if err := vc.FramebufferUpdateRequest(rfbflags.RFBFalse, 20, 20, 4, 4); err != nil {
+		t.Fatal(err)
+	}
+	fu = nextUpdate(t, ch)
+	if got, want := len(fu.Rects), 0; got != want {
+		t.Errorf("incorrect number of rectangles; got = %v, want = %v", got, want)
+	}
+}
+
+func TestServeUpdates_Resize(t *testing.T) {
+	defer SetSettle(Settle())
+	SetSettle(0)
+
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	green := color.RGBA{0, 0xff, 0, 0xff}
+
+	for _, tt := range []struct {
+		desc string
+		encs Encodings
+		size image.Point // The size the client is left with.
+	}{
+		{"extended desktop size", Encodings{&RawEncoding{}, &ExtendedDesktopSizePseudoEncoding{}}, image.Pt(24, 12)},
+		{"desktop size", Encodings{&RawEncoding{}, &DesktopSizePseudoEncoding{}}, image.Pt(24, 12)},
+		{"unsupported", Encodings{&RawEncoding{}}, image.Pt(16, 8)},
+	} {
+		var mu sync.Mutex
+		frame := image.NewRGBA(image.Rect(0, 0, 16, 8))
+		fillRGBA(frame, frame.Rect, red)
+		src := NewPollingSource(func() image.Image {
+			mu.Lock()
+			defer mu.Unlock()
+			return frame
+		}, 0)
+
+		cfg := NewServerConfig(16, 8)
+		cfg.Source = src
+		cfg.UpdateInterval = time.Millisecond
+
+		addr, stop := newTestServer(t, cfg)
+		nc, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("error connecting to server: %s", err)
+		}
+		ch := make(chan ServerMessage, 16)
+		vcc := NewClientConfig("")
+		vcc.Encodings = tt.encs
+		vcc.ServerMessageCh = ch
+		vc, err := Connect(context.Background(), nc, vcc)
+		if err != nil {
+			t.Fatalf("%s: Connect() unexpected error: %v", tt.desc, err)
+		}
+		go vc.ListenAndHandle()
+
+		if err := vc.FramebufferUpdateRequest(rfbflags.RFBFalse, 0, 0, 16, 8); err != nil {
+			t.Fatal(err)
+		}
+		nextUpdate(t, ch)
+
+		// The source grows, and the client is told of the new size if it
+		// supports it. Otherwise, updates are clipped to its size.
+		mu.Lock()
+		frame = image.NewRGBA(image.Rect(0, 0, 24, 12))
+		fillRGBA(frame, frame.Rect, green)
+		mu.Unlock()
+		if err := vc.FramebufferUpdateRequest(rfbflags.RFBTrue, 0, 0, 16, 8); err != nil {
+			t.Fatal(err)
+		}
+		fu := nextUpdate(t, ch)
+		if tt.size != image.Pt(16, 8) {
+			if got, want := len(fu.Rects), 1; got != want {
+				t.Fatalf("%s: incorrect number of rectangles; got = %v, want = %v", tt.desc, got, want)
+			}
+			if got, want := fu.Rects[0].Enc.Type(), tt.encs[1].Type(); got != want {
+				t.Errorf("%s: incorrect encoding; got = %v, want = %v", tt.desc, got, want)
+			}
+			if err := vc.FramebufferUpdateRequest(rfbflags.RFBTrue, 0, 0, 24, 12); err != nil {
+				t.Fatal(err)
+			}
+			fu = nextUpdate(t, ch)
+		}
+		if got, want := len(fu.Rects), 1; got != want {
+			t.Fatalf("%s: incorrect number of rectangles; got = %v, want = %v", tt.desc, got, want)
+		}
+		if got, want := fu.Rects[0].bounds(), (image.Rectangle{Max: tt.size}); got != want {
+			t.Errorf("%s: incorrect rectangle; got = %v, want = %v", tt.desc, got, want)
+		}
+		if got, want := vc.Framebuffer().Bounds(), (image.Rectangle{Max: tt.size}); got != want {
+			t.Errorf("%s: incorrect framebuffer bounds; got = %v, want = %v", tt.desc, got, want)
+		}
+		if got := vc.Framebuffer().At(tt.size.X-1, tt.size.Y-1); got != green {
+			t.Errorf("%s: incorrect color; got = %v, want = %v", tt.desc, got, green)
+		}
+		vc.Close()
+		stop()
+	}
+}
+
+func TestServe_Cancel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- Serve(ctx, ln, NewServerConfig(8, 8)) }()
+
+	// One client has connected, and another is still in the handshake.
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("error connecting to server: %s", err)
+	}
+	vc, err := Connect(context.Background(), nc, NewClientConfig(""))
+	if err != nil {
+		t.Fatalf("Connect() unexpected error: %v", err)
+	}
+	defer vc.Close()
+	stalled, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("error connecting to server: %s", err)
+	}
+	defer stalled.Close()
+	if _, err := io.ReadFull(stalled, make([]byte, pvLen)); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	select {
+	case err := <-errc:
+		if err != context.Canceled {
+			t.Errorf("Serve() returned %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Serve to return")
+	}
+	for _, c := range []net.Conn{nc, stalled} {
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("expected the server to close the connection; got = %v", err)
+		}
+	}
+}
+
+func TestAccept_Deadline(t *testing.T) {
+	// stallTLS is a client that chooses VeNCrypt X509Vnc, and then stalls
+	// instead of starting the TLS handshake.
+	stallTLS := func(c net.Conn) error {
+		for _, step := range []func() error{
+			func() error { _, err := io.ReadFull(c, make([]byte, pvLen)); return err },
+			func() error { _, err := c.Write([]byte(PROTO_VERS_3_8)); return err },
+			func() error { _, err := io.ReadFull(c, make([]byte, 2)); return err },
+			func() error { _, err := c.Write([]byte{secTypeVeNCrypt}); return err },
+			func() error { _, err := io.ReadFull(c, make([]byte, 2)); return err },
+			func() error { _, err := c.Write([]byte{0, 2}); return err },
+			func() error { _, err := io.ReadFull(c, make([]byte, 1+1+4*2)); return err },
+			func() error { return binary.Write(c, binary.BigEndian, VeNCryptX509Vnc) },
+			func() error { _, err := io.ReadFull(c, make([]byte, 1)); return err },
+		} {
+			if err := step(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, tt := range []struct {
+		desc    string
+		timeout time.Duration
+		cancel  bool
+		auth    []ServerAuth
+		client  func(c net.Conn) error
+	}{
+		{"silent client", 50 * time.Millisecond, false, nil, func(net.Conn) error { return nil }},
+		{"cancelled", time.Minute, true, nil, func(net.Conn) error { return nil }},
+		{"stalled TLS handshake", 50 * time.Millisecond, false,
+			[]ServerAuth{&ServerAuthVeNCrypt{Config: testTLSConfig(t)}}, stallTLS},
+	} {
+		cfg := NewServerConfig(8, 8)
+		cfg.HandshakeTimeout = tt.timeout
+		cfg.Auth = tt.auth
+
+		client, server := net.Pipe()
+		clientErr := make(chan error, 1)
+		go func() { clientErr <- tt.client(client) }()
+		ctx, cancel := context.WithCancel(context.Background())
+		if tt.cancel {
+			time.AfterFunc(50*time.Millisecond, cancel)
+		}
+
+		start := time.Now()
+		if _, err := Accept(ctx, server, cfg); err == nil {
+			t.Errorf("%s: expected error", tt.desc)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Errorf("%s: Accept() took %v", tt.desc, d)
+		}
+		if err := <-clientErr; err != nil {
+			t.Errorf("%s: client error: %v", tt.desc, err)
+		}
+		client.Close()
+		cancel()
+	}
+}
+
+func TestServerConnMessages(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewServerConn(mockConn, NewServerConfig(10, 10))
+
+	for _, tt := range []struct {
+		msg  ServerMessage
+		send func() error
+	}{
+		{&Bell{}, conn.Bell},
+		{&ServerCutText{Text: "naïve"}, func() error { return conn.ServerCutText("naïve") }},
+	} {
+		mockConn.Reset()
+		if err := tt.send(); err != nil {
+			t.Fatalf("%v: unexpected error: %v", tt.msg.Type(), err)
+		}
+
+		// Read the message back in as a client.
+		client := NewClientConn(mockConn, &ClientConfig{})
+		var msgType messages.ServerMessage
+		if err := client.receive(&msgType); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := msgType, tt.msg.Type(); got != want {
+			t.Errorf("incorrect message-type; got = %v, want = %v", got, want)
+		}
+		msg, err := tt.msg.Read(client)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tt.msg.Type(), err)
+		}
+		if !reflect.DeepEqual(msg, tt.msg) {
+			t.Errorf("incorrect message; got = %v, want = %v", msg, tt.msg)
+		}
+	}
+
+	if err := conn.ServerCutText("ɹɐqooɟ"); err == nil {
+		t.Error("expected error for non Latin-1 text")
+	}
+}
+
+func TestServerHandshake(t *testing.T) {
+	for _, tt := range []struct {
+		version string
+		secType uint8
+		result  []byte
+		ok      bool
+	}{
+		// Version 3.8 sends a SecurityResult for None.
+		{PROTO_VERS_3_8, secTypeNone, []byte{0, 0, 0, 0}, true},
+		// Version 3.3 chooses None, and sends no SecurityResult.
+		{"RFB 003.003\n", 0, nil, true},
+		// Version 3.7 offers a list of security types, but sends no
+		// SecurityResult for None, nor a failure reason.
+		{PROTO_VERS_3_7, secTypeNone, nil, true},
+		{PROTO_VERS_3_7, secTypeVNCAuth, []byte{0, 0, 0, 1}, false},
+		// An unsupported security type fails with a reason.
+		{PROTO_VERS_3_8, secTypeVNCAuth, append([]byte{0, 0, 0, 1, 0, 0, 0, 28}, "unsupported security type: 2"...), false},
+		{"RFB 004.000\n", 0, nil, false},
+	} {
+		// The client messages are queued before the handshake runs, and the
+		// server messages are appended after them.
+		mockConn := &MockConn{}
+		conn := NewServerConn(mockConn, NewServerConfig(10, 10))
+		if err := conn.send([]byte(tt.version)); err != nil {
+			t.Fatal(err)
+		}
+		if tt.secType != 0 {
+			if err := conn.send(tt.secType); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := conn.protocolVersionHandshake()
+		if err == nil {
+			err = conn.securityHandshake()
+		}
+		if err == nil {
+			err = conn.securityResultHandshake(nil)
+		}
+		if (err == nil) != tt.ok {
+			t.Fatalf("%q: unexpected error: %v", tt.version, err)
+		}
+		if conn.protocolVersion == "" {
+			continue
+		}
+
+		want := []byte(PROTO_VERS_3_8)
+		if conn.protocolVersion == PROTO_VERS_3_3 {
+			want = append(want, 0, 0, 0, secTypeNone)
+		} else {
+			want = append(append(want, 1, secTypeNone), tt.result...)
+		}
+		if got := mockConn.b.Bytes(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: incorrect security handshake; got = %v, want = %v", tt.version, got, want)
+		}
+	}
+}
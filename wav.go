@@ -0,0 +1,110 @@
+// Writing of PCM audio to WAV files.
+
+package vnc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// wavHeader holds the header of a WAV file with PCM samples.
+type wavHeader struct {
+	RIFF          [4]byte // "RIFF"
+	RIFFSize      uint32  // Size of the file, less 8 bytes.
+	WAVE          [4]byte // "WAVE"
+	Fmt           [4]byte // "fmt "
+	FmtSize       uint32  // 16
+	AudioFormat   uint16  // 1 for PCM.
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+	Data          [4]byte // "data"
+	DataSize      uint32
+}
+
+// wavHeaderSize is the size of the header, which precedes the samples.
+const wavHeaderSize = 44
+
+// WAVWriter writes PCM audio to a WAV file. It can be used as the AudioSink
+// of a ClientConfig.
+//
+// The sizes in the header are only known once all the samples have been
+// written. If the underlying writer is an io.WriteSeeker, such as an
+// *os.File, then Close updates them. Otherwise, they are left at their
+// maximum, which most programs accept for streamed audio.
+type WAVWriter struct {
+	w      io.Writer
+	header wavHeader
+	n      uint32 // Bytes of samples written.
+}
+
+// Verify that interfaces are honored.
+var _ io.WriteCloser = (*WAVWriter)(nil)
+
+// NewWAVWriter writes a WAV header for audio of the given format to w, and
+// returns a WAVWriter that writes samples after it. WAV files only support
+// unsigned 8-bit and signed 16- and 32-bit samples.
+func NewWAVWriter(w io.Writer, f AudioFormat) (*WAVWriter, error) {
+	switch f.Sample {
+	case SampleU8, SampleS16, SampleS32:
+	default:
+		return nil, fmt.Errorf("sample format %d is not supported by WAV", f.Sample)
+	}
+
+	size := uint16(f.Sample.Size())
+	ww := &WAVWriter{
+		w: w,
+		header: wavHeader{
+			RIFF:          [4]byte{'R', 'I', 'F', 'F'},
+			RIFFSize:      math.MaxUint32,
+			WAVE:          [4]byte{'W', 'A', 'V', 'E'},
+			Fmt:           [4]byte{'f', 'm', 't', ' '},
+			FmtSize:       16,
+			AudioFormat:   1,
+			Channels:      uint16(f.Channels),
+			SampleRate:    f.Frequency,
+			ByteRate:      f.Frequency * uint32(f.Channels) * uint32(size),
+			BlockAlign:    uint16(f.Channels) * size,
+			BitsPerSample: 8 * size,
+			Data:          [4]byte{'d', 'a', 't', 'a'},
+			DataSize:      math.MaxUint32 - wavHeaderSize + 8,
+		},
+	}
+	if err := binary.Write(w, binary.LittleEndian, &ww.header); err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+// Write implements the io.Writer interface. The samples are written as is,
+// so they must be in the format given to NewWAVWriter.
+func (ww *WAVWriter) Write(p []byte) (int, error) {
+	n, err := ww.w.Write(p)
+	ww.n += uint32(n)
+	return n, err
+}
+
+// Close implements the io.Closer interface. It updates the sizes in the
+// header if the underlying writer is an io.WriteSeeker, leaving it positioned
+// at the end of the samples. It does not close the underlying writer.
+func (ww *WAVWriter) Close() error {
+	ws, ok := ww.w.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+
+	ww.header.RIFFSize = wavHeaderSize - 8 + ww.n
+	ww.header.DataSize = ww.n
+	if _, err := ws.Seek(-int64(ww.n)-wavHeaderSize, io.SeekCurrent); err != nil {
+		return err
+	}
+	if err := binary.Write(ws, binary.LittleEndian, &ww.header); err != nil {
+		return err
+	}
+	_, err := ws.Seek(int64(ww.n), io.SeekCurrent)
+	return err
+}
@@ -0,0 +1,109 @@
+package vnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// seekBuffer is an in-memory io.WriteSeeker.
+type seekBuffer struct {
+	b   []byte
+	pos int
+}
+
+func (s *seekBuffer) Write(p []byte) (int, error) {
+	if n := s.pos + len(p); n > len(s.b) {
+		s.b = append(s.b, make([]byte, n-len(s.b))...)
+	}
+	copy(s.b[s.pos:], p)
+	s.pos += len(p)
+	return len(p), nil
+}
+
+func (s *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	pos := int64(s.pos)
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos += offset
+	case io.SeekEnd:
+		pos = int64(len(s.b)) + offset
+	}
+	if pos < 0 {
+		return 0, errors.New("negative position")
+	}
+	s.pos = int(pos)
+	return pos, nil
+}
+
+func TestWAVWriter(t *testing.T) {
+	f := AudioFormat{SampleS16, 2, 48000}
+	samples := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	for _, tt := range []struct {
+		desc     string
+		w        io.Writer
+		bytes    func() []byte
+		dataSize uint32
+	}{
+		{"streamed", &bytes.Buffer{}, nil, 0xffffffff - 36},
+		{"seekable", &seekBuffer{}, nil, uint32(len(samples))},
+	} {
+		switch w := tt.w.(type) {
+		case *bytes.Buffer:
+			tt.bytes = w.Bytes
+		case *seekBuffer:
+			tt.bytes = func() []byte { return w.b }
+		}
+
+		ww, err := NewWAVWriter(tt.w, f)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if _, err := ww.Write(samples[:4]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ww.Write(samples[4:]); err != nil {
+			t.Fatal(err)
+		}
+		if err := ww.Close(); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.desc, err)
+		}
+
+		data := tt.bytes()
+		if got, want := len(data), wavHeaderSize+len(samples); got != want {
+			t.Fatalf("%s: incorrect file size; got = %d, want = %d", tt.desc, got, want)
+		}
+		var h wavHeader
+		if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &h); err != nil {
+			t.Fatal(err)
+		}
+		if string(h.RIFF[:]) != "RIFF" || string(h.WAVE[:]) != "WAVE" || string(h.Data[:]) != "data" {
+			t.Errorf("%s: incorrect chunk IDs; got = %q %q %q", tt.desc, h.RIFF, h.WAVE, h.Data)
+		}
+		if h.Channels != 2 || h.SampleRate != 48000 || h.BitsPerSample != 16 {
+			t.Errorf("%s: incorrect format; got = %d channels, %d Hz, %d bits",
+				tt.desc, h.Channels, h.SampleRate, h.BitsPerSample)
+		}
+		if got, want := h.ByteRate, uint32(192000); got != want {
+			t.Errorf("%s: incorrect byte rate; got = %d, want = %d", tt.desc, got, want)
+		}
+		if got, want := h.DataSize, tt.dataSize; got != want {
+			t.Errorf("%s: incorrect data size; got = %d, want = %d", tt.desc, got, want)
+		}
+		if got, want := h.RIFFSize, tt.dataSize+36; got != want {
+			t.Errorf("%s: incorrect RIFF size; got = %d, want = %d", tt.desc, got, want)
+		}
+		if got := data[wavHeaderSize:]; !bytes.Equal(got, samples) {
+			t.Errorf("%s: incorrect samples; got = %v, want = %v", tt.desc, got, samples)
+		}
+	}
+
+	if _, err := NewWAVWriter(&bytes.Buffer{}, AudioFormat{SampleU16, 1, 8000}); err == nil {
+		t.Error("expected error for unsupported sample format")
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Implementation of the xvp extension, for controlling the power of the remote
+machine.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#xvp-client-message
+*/
+package vnc
+
+import (
+	"fmt"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/messages"
+)
+
+// xvpVersion is the supported version of the xvp extension.
+const xvpVersion = 1
+
+// XVPCode is the operation of an xvp message.
+type XVPCode uint8
+
+// xvp message codes.
+const (
+	XVPFail     XVPCode = iota // The server failed to perform an operation.
+	XVPInit                    // The server supports xvp.
+	XVPShutdown                // Shut down the machine cleanly.
+	XVPReboot                  // Reboot the machine cleanly.
+	XVPReset                   // Reset the machine immediately.
+)
+
+// XVPSupported returns true once the server has indicated that it supports
+// xvp operations, by sending an XVP message with the XVPInit code.
+func (c *ClientConn) XVPSupported() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.xvp
+}
+
+// XVPPseudoEncoding tells the server that the client supports xvp.
+type XVPPseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*XVPPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*XVPPseudoEncoding) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Read implements the Encoding interface.
+func (*XVPPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	return nil, fmt.Errorf("XVPPseudoEncoding is never sent by the server")
+}
+
+// String implements the fmt.Stringer interface.
+func (*XVPPseudoEncoding) String() string { return "XVPPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*XVPPseudoEncoding) Type() encodings.Encoding { return encodings.XVPPseudo }
+
+// XVPMessage holds the wire format message.
+type XVPMessage struct {
+	Msg     messages.ClientMessage // message-type
+	_       [1]byte                // padding
+	Version uint8                  // xvp-extension-version
+	Code    XVPCode                // xvp-message-code
+}
+
+// XVPOperation asks the server to shut down, reboot or reset the remote
+// machine. It requires the server to support xvp. If the operation fails,
+// the server sends an XVP message with the XVPFail code.
+func (c *ClientConn) XVPOperation(code XVPCode) error {
+	switch code {
+	case XVPShutdown, XVPReboot, XVPReset:
+	default:
+		return fmt.Errorf("invalid xvp operation: %d", code)
+	}
+	if !c.XVPSupported() {
+		return fmt.Errorf("xvp is not supported by the server")
+	}
+	return c.send(XVPMessage{Msg: messages.ClientXVP, Version: xvpVersion, Code: code})
+}
+
+// Shutdown asks the server to shut down the remote machine cleanly.
+func (c *ClientConn) Shutdown() error { return c.XVPOperation(XVPShutdown) }
+
+// Reboot asks the server to reboot the remote machine cleanly.
+func (c *ClientConn) Reboot() error { return c.XVPOperation(XVPReboot) }
+
+// Reset asks the server to reset the remote machine immediately.
+func (c *ClientConn) Reset() error { return c.XVPOperation(XVPReset) }
+
+// XVP represents the wire format message, sans message-type and padding.
+type XVP struct {
+	Version uint8
+	Code    XVPCode
+}
+
+// Verify that interfaces are honored.
+var _ ServerMessage = (*XVP)(nil)
+
+// Type implements the ServerMessage interface.
+func (*XVP) Type() messages.ServerMessage { return messages.ServerXVP }
+
+// Read implements the ServerMessage interface.
+func (*XVP) Read(c *ClientConn) (ServerMessage, error) {
+	var msg struct {
+		_ [1]byte // padding
+		XVP
+	}
+	if err := c.receive(&msg); err != nil {
+		return nil, err
+	}
+
+	if msg.Code == XVPInit && msg.Version == xvpVersion {
+		c.mu.Lock()
+		c.xvp = true
+		c.mu.Unlock()
+	}
+
+	return &msg.XVP, nil
+}
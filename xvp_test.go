@@ -0,0 +1,82 @@
+package vnc
+
+import (
+	"testing"
+
+	"github.com/phox/go-vnc/encodings"
+	"github.com/phox/go-vnc/messages"
+)
+
+func TestXVPPseudoEncoding(t *testing.T) {
+	e := &XVPPseudoEncoding{}
+	if got, want := e.Type(), encodings.XVPPseudo; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+	if _, err := e.Read(NewClientConn(&MockConn{}, &ClientConfig{}), &Rectangle{}); err == nil {
+		t.Error("expected error reading XVPPseudoEncoding")
+	}
+}
+
+func TestXVP(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	if err := conn.Shutdown(); err == nil {
+		t.Error("expected error before xvp is supported")
+	}
+	if mockConn.b.Len() != 0 {
+		t.Fatalf("message sent before xvp is supported")
+	}
+
+	for _, tt := range []struct {
+		version   uint8
+		code      XVPCode
+		supported bool
+	}{
+		{1, XVPFail, false},
+		{2, XVPInit, false},
+		{1, XVPInit, true},
+	} {
+		mockConn.Reset()
+		if err := conn.send([]byte{0, tt.version, uint8(tt.code)}); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := (&XVP{}).Read(conn)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, want := *msg.(*XVP), (XVP{tt.version, tt.code}); got != want {
+			t.Errorf("incorrect message; got = %v, want = %v", got, want)
+		}
+		if got, want := conn.XVPSupported(), tt.supported; got != want {
+			t.Errorf("XVPSupported() = %v, want %v", got, want)
+		}
+	}
+
+	for _, tt := range []struct {
+		op   func() error
+		code XVPCode
+	}{
+		{conn.Shutdown, XVPShutdown},
+		{conn.Reboot, XVPReboot},
+		{conn.Reset, XVPReset},
+	} {
+		mockConn.Reset()
+		if err := tt.op(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var msg XVPMessage
+		if err := conn.receive(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if want := (XVPMessage{Msg: messages.ClientXVP, Version: 1, Code: tt.code}); msg != want {
+			t.Errorf("incorrect message; got = %v, want = %v", msg, want)
+		}
+	}
+
+	for _, code := range []XVPCode{XVPFail, XVPInit, 5} {
+		if err := conn.XVPOperation(code); err == nil {
+			t.Errorf("expected error for xvp operation %d", code)
+		}
+	}
+}
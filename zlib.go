@@ -0,0 +1,139 @@
+/*
+Implementation of the Zlib and ZlibHex encodings.
+https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#zlib-encoding
+*/
+package vnc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/phox/go-vnc/encodings"
+)
+
+//-----------------------------------------------------------------------------
+// Zlib Encoding
+//
+// Zlib encoding sends raw pixel data compressed with a single zlib stream,
+// which lasts for the whole connection.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#zlib-encoding
+
+// ZlibEncoding holds Zlib encoded rectangle data.
+type ZlibEncoding struct {
+	// Image holds the decoded pixel data, with bounds matching the rectangle.
+	Image *image.RGBA
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*ZlibEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*ZlibEncoding) Marshal() ([]byte, error) {
+	return nil, fmt.Errorf("Marshal() unimplemented")
+}
+
+// Read implements the Encoding interface.
+func (*ZlibEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	var length uint32
+	if err := c.receive(&length); err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with zlib encoding: %s", err)
+	}
+	r, err := c.zlibRawStream.read(c, int(length))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with zlib encoding: %s", err)
+	}
+
+	img := image.NewRGBA(rect.bounds())
+	if err := c.readPixels(r, img, img.Rect, c.pixelFormat.bytesPerPixel()); err != nil {
+		return nil, fmt.Errorf("unable to read rectangle with zlib encoding: %s", err)
+	}
+	c.drawTarget().draw(img)
+
+	return &ZlibEncoding{img}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*ZlibEncoding) String() string { return "ZlibEncoding" }
+
+// Type implements the Encoding interface.
+func (*ZlibEncoding) Type() encodings.Encoding { return encodings.Zlib }
+
+//-----------------------------------------------------------------------------
+// ZlibHex Encoding
+//
+// ZlibHex encoding is Hextile encoding where each tile may be compressed.
+// Raw tiles are compressed with one zlib stream, and the remaining tile data
+// of other tiles with another. Both streams last for the whole connection.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#zlibhex-encoding
+
+// ZlibHex subencoding mask bits, in addition to the Hextile ones.
+const (
+	zlibHexZlibRaw uint8 = 1 << (iota + 5)
+	zlibHexZlibHex
+)
+
+// ZlibHexEncoding holds ZlibHex encoded rectangle data.
+type ZlibHexEncoding struct {
+	// Image holds the decoded pixel data, with bounds matching the rectangle.
+	Image *image.RGBA
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*ZlibHexEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*ZlibHexEncoding) Marshal() ([]byte, error) {
+	return nil, fmt.Errorf("Marshal() unimplemented")
+}
+
+// Read implements the Encoding interface.
+func (*ZlibHexEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	d := newHextileDecoder(c, rect)
+	for _, tile := range tiles(rect.bounds(), hextileTileSize) {
+		if err := readZlibHexTile(c, d, tile); err != nil {
+			return nil, fmt.Errorf("unable to read rectangle with zlibhex encoding: %s", err)
+		}
+	}
+	c.drawTarget().draw(d.img)
+
+	return &ZlibHexEncoding{d.img}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*ZlibHexEncoding) String() string { return "ZlibHexEncoding" }
+
+// Type implements the Encoding interface.
+func (*ZlibHexEncoding) Type() encodings.Encoding { return encodings.ZlibHex }
+
+// readZlibHexTile reads a single tile, decompressing it if necessary.
+func readZlibHexTile(c *ClientConn, d *hextileDecoder, tile image.Rectangle) error {
+	var r io.Reader = &connReader{c}
+	var subenc uint8
+	if err := binary.Read(r, binary.BigEndian, &subenc); err != nil {
+		return err
+	}
+
+	var z *zlibStream
+	switch {
+	case subenc&zlibHexZlibRaw != 0:
+		z, subenc = &c.zlibHexStreams[0], hextileRaw
+	case subenc&zlibHexZlibHex != 0:
+		z, subenc = &c.zlibHexStreams[1], subenc&^zlibHexZlibHex
+	}
+	if z != nil {
+		var length uint16
+		if err := c.receive(&length); err != nil {
+			return err
+		}
+		var err error
+		if r, err = z.read(c, int(length)); err != nil {
+			return err
+		}
+	}
+
+	return d.decodeTile(r, tile, subenc)
+}
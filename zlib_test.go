@@ -0,0 +1,187 @@
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"runtime"
+	"testing"
+
+	"github.com/phox/go-vnc/encodings"
+)
+
+// zlibTestStream compresses data on a persistent zlib stream, flushing after
+// each write as servers do.
+type zlibTestStream struct {
+	buf bytes.Buffer
+	w   *zlib.Writer
+}
+
+func (z *zlibTestStream) compress(t *testing.T, data []byte) []byte {
+	if z.w == nil {
+		z.w = zlib.NewWriter(&z.buf)
+	}
+	z.buf.Reset()
+	if _, err := z.w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte(nil), z.buf.Bytes()...)
+}
+
+func TestZlibStream_ReadShort(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	if err := conn.send([]byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A length far beyond the data sent must not be allocated up front.
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	var z zlibStream
+	if _, err := z.read(conn, 1<<30); err == nil {
+		t.Error("expected error for short data")
+	}
+	runtime.ReadMemStats(&after)
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("read allocated %d bytes for 3 bytes of data", n)
+	}
+}
+
+func TestZlibEncoding_Type(t *testing.T) {
+	e := &ZlibEncoding{}
+	if got, want := e.Type(), encodings.Zlib; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+}
+
+func TestZlibEncoding_Read(t *testing.T) {
+	var (
+		// Pixels for a little-endian 32bpp depth 24 pixel format.
+		red  = []byte{0, 0, 255, 0}
+		blue = []byte{255, 0, 0, 0}
+
+		cRed  = color.RGBA{255, 0, 0, 255}
+		cBlue = color.RGBA{0, 0, 255, 255}
+	)
+
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat{BPP: 32, Depth: 24, BigEndian: RFBFalse, TrueColor: RFBTrue,
+		RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0}
+	conn.fb = NewFramebuffer(4, 2)
+
+	// Both rectangles share a single zlib stream.
+	var z zlibTestStream
+	for _, tt := range []struct {
+		desc   string
+		rect   Rectangle
+		data   []byte
+		pixels map[image.Point]color.RGBA
+	}{
+		{"first rectangle",
+			Rectangle{X: 0, Y: 0, Width: 2, Height: 2},
+			bytes.Join([][]byte{red, blue, blue, red}, nil),
+			map[image.Point]color.RGBA{{0, 0}: cRed, {1, 0}: cBlue, {0, 1}: cBlue, {1, 1}: cRed, {2, 0}: {0, 0, 0, 255}}},
+		{"continued stream",
+			Rectangle{X: 2, Y: 1, Width: 2, Height: 1},
+			bytes.Join([][]byte{blue, blue}, nil),
+			map[image.Point]color.RGBA{{2, 1}: cBlue, {3, 1}: cBlue, {3, 0}: {0, 0, 0, 255}}},
+	} {
+		zdata := z.compress(t, tt.data)
+		if err := conn.send(uint32(len(zdata))); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.send(zdata); err != nil {
+			t.Fatal(err)
+		}
+
+		enc, err := (&ZlibEncoding{}).Read(conn, &tt.rect)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if got, want := enc.(*ZlibEncoding).Image.Bounds(), tt.rect.bounds(); got != want {
+			t.Errorf("%s: incorrect image bounds; got = %v, want = %v", tt.desc, got, want)
+		}
+		for p, c := range tt.pixels {
+			if got, want := conn.Framebuffer().At(p.X, p.Y), c; got != want {
+				t.Errorf("%s: incorrect framebuffer pixel at %v; got = %v, want = %v", tt.desc, p, got, want)
+			}
+		}
+		if mockConn.b.Len() != 0 {
+			t.Errorf("%s: %d bytes left unread", tt.desc, mockConn.b.Len())
+		}
+	}
+}
+
+func TestZlibHexEncoding_Type(t *testing.T) {
+	e := &ZlibHexEncoding{}
+	if got, want := e.Type(), encodings.ZlibHex; got != want {
+		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
+	}
+}
+
+func TestZlibHexEncoding_Read(t *testing.T) {
+	var (
+		// Pixels for a little-endian 32bpp depth 24 pixel format.
+		red   = []byte{0, 0, 255, 0}
+		green = []byte{0, 255, 0, 0}
+		blue  = []byte{255, 0, 0, 0}
+
+		cRed   = color.RGBA{255, 0, 0, 255}
+		cGreen = color.RGBA{0, 255, 0, 255}
+		cBlue  = color.RGBA{0, 0, 255, 255}
+	)
+
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat{BPP: 32, Depth: 24, BigEndian: RFBFalse, TrueColor: RFBTrue,
+		RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0}
+	conn.fb = NewFramebuffer(36, 1)
+
+	var rawStream, hexStream zlibTestStream
+	// zlibTile returns a compressed tile, with its subencoding and length.
+	zlibTile := func(z *zlibTestStream, subenc uint8, data []byte) []byte {
+		zdata := z.compress(t, data)
+		b := make([]byte, 3, 3+len(zdata))
+		b[0] = subenc
+		binary.BigEndian.PutUint16(b[1:], uint16(len(zdata)))
+		return append(b, zdata...)
+	}
+
+	rect := Rectangle{X: 0, Y: 0, Width: 36, Height: 1}
+	data := bytes.Join([][]byte{
+		// Tile 1: raw pixels, compressed.
+		zlibTile(&rawStream, zlibHexZlibRaw, bytes.Repeat(red, 16)),
+		// Tile 2: background only, compressed.
+		zlibTile(&hexStream, zlibHexZlibHex|hextileBackgroundSpecified, green),
+		// Tile 3: background only, uncompressed.
+		{hextileBackgroundSpecified}, blue,
+	}, nil)
+	if err := conn.send(data); err != nil {
+		t.Fatal(err)
+	}
+
+	enc, err := (&ZlibHexEncoding{}).Read(conn, &rect)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := enc.(*ZlibHexEncoding).Image.Bounds(), rect.bounds(); got != want {
+		t.Errorf("incorrect image bounds; got = %v, want = %v", got, want)
+	}
+	for p, c := range map[image.Point]color.RGBA{
+		{0, 0}: cRed, {15, 0}: cRed, {16, 0}: cGreen, {31, 0}: cGreen, {32, 0}: cBlue, {35, 0}: cBlue,
+	} {
+		if got, want := conn.Framebuffer().At(p.X, p.Y), c; got != want {
+			t.Errorf("incorrect framebuffer pixel at %v; got = %v, want = %v", p, got, want)
+		}
+	}
+	if mockConn.b.Len() != 0 {
+		t.Errorf("%d bytes left unread", mockConn.b.Len())
+	}
+}